@@ -0,0 +1,119 @@
+//go:build !appengine
+// +build !appengine
+
+// Command verbalize serves a blog from a plain net/http server, without the
+// App Engine runtime. Run it from the site directory, which holds verbalize.yml,
+// themes/, templates/ and the static files that app.yaml would otherwise serve:
+//
+//	VERBALIZE_ADMIN_PASSWORD=secret verbalize -listen=:8080
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	blog "github.com/tstromberg/verbalize/verbalize"
+)
+
+var (
+	listen         = flag.String("listen", ":8080", "address to serve on")
+	storePath      = flag.String("store", "verbalize.json", "file to keep entries and links in; empty keeps them in memory only")
+	media_dir      = flag.String("media_dir", "media", "directory to keep uploaded images in")
+	admin_user     = flag.String("admin_user", "admin", "user name required for /admin")
+	admin_password = flag.String("admin_password", os.Getenv("VERBALIZE_ADMIN_PASSWORD"), "password required for /admin")
+	cron_every     = flag.Duration("cron_every", time.Minute, "how often to run the jobs in cron.yaml")
+)
+
+// staticFile maps a URL pattern to a file, mirroring the static handlers in app.yaml.
+type staticFile struct {
+	re   *regexp.Regexp
+	path string
+}
+
+var staticFiles = []staticFile{
+	{regexp.MustCompile(`^/third_party/(.*)$`), "third_party/$1"},
+	{regexp.MustCompile(`^/themes/(.*)/(.*\.(gif|png|jpg|css))$`), "themes/$1/$2"},
+	{regexp.MustCompile(`^/robots\.txt$`), "static/robots.txt"},
+	{regexp.MustCompile(`^/favicon\.ico$`), "static/favicon.ico"},
+	{regexp.MustCompile(`^/.*images/(.*\.(gif|png|jpg))$`), "images/$1"},
+}
+
+// serveStatic serves files matching staticFiles, and passes everything else to next.
+func serveStatic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range staticFiles {
+			if m := s.re.FindStringSubmatchIndex(r.URL.Path); m != nil {
+				path := string(s.re.ExpandString(nil, s.path, r.URL.Path, m))
+				// http.ServeFile refuses paths containing "..".
+				http.ServeFile(w, r, path)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin asks for the admin credential on /admin, /cron and /_ah, which
+// app.yaml does with "login: admin", and App Engine does for /_ah itself: the
+// warmup request there runs migrations and flushes the cache.
+func requireAdmin(next http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin") || strings.HasPrefix(r.URL.Path, "/cron/") || strings.HasPrefix(r.URL.Path, "/_ah/") {
+			u, p, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(user)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="verbalize admin"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runCron takes the place of the jobs in cron.yaml.
+func runCron(local *blog.Local, every time.Duration) {
+	r, _ := http.NewRequest("GET", "/cron/", nil)
+	for range time.Tick(every) {
+		c := local.NewContext(r)
+		if _, err := blog.PublishScheduled(c); err != nil {
+			log.Printf("Unable to publish scheduled entries: %v", err)
+		}
+		if _, err := blog.PurgeExpiredTrash(c); err != nil {
+			log.Printf("Unable to purge the trash: %v", err)
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *admin_password == "" {
+		log.Fatal("An admin password is required: use -admin_password or $VERBALIZE_ADMIN_PASSWORD")
+	}
+
+	local := &blog.Local{Cache: blog.NewMemoryCache(), Blobs: blog.NewDiskBlobStore(*media_dir)}
+	if *storePath == "" {
+		local.Store = blog.NewMemoryStore()
+	} else {
+		store, err := blog.OpenFileStore(*storePath)
+		if err != nil {
+			log.Fatalf("Unable to open %s: %v", *storePath, err)
+		}
+		local.Store = store
+	}
+
+	mux := http.NewServeMux()
+	blog.RegisterHandlers(mux, local.NewContext)
+	r, _ := http.NewRequest("GET", "/_ah/warmup", nil)
+	blog.MigrateOnStartup(local.NewContext(r), time.Time{})
+	go runCron(local, *cron_every)
+
+	log.Printf("Serving on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, requireAdmin(serveStatic(mux), *admin_user, *admin_password)))
+}
@@ -0,0 +1,143 @@
+/**
+ * A small stand-in for CKEditor's filebrowser plugin, which this build of
+ * CKEditor was made without. It shows the "Browse Server" button and the
+ * Upload tab of dialogs such as image2's, using the same configuration
+ * (filebrowser<Dialog>BrowseUrl and filebrowser<Dialog>UploadUrl) and the
+ * same CKEDITOR.tools.callFunction protocol as the original.
+ */
+( function() {
+	function ucFirst( str ) {
+		return str.charAt( 0 ).toUpperCase() + str.slice( 1 );
+	}
+
+	function addQueryString( url, params ) {
+		var queryString = [];
+		for ( var name in params )
+			queryString.push( name + '=' + encodeURIComponent( params[ name ] ) );
+		return url + ( url.indexOf( '?' ) != -1 ? '&' : '?' ) + queryString.join( '&' );
+	}
+
+	function callbackParams( editor ) {
+		return {
+			CKEditor: editor.name,
+			CKEditorFuncNum: editor._.filebrowserFn,
+			langCode: editor.langCode
+		};
+	}
+
+	function configuredUrl( editor, dialogName, kind ) {
+		var url = editor.config[ 'filebrowser' + ucFirst( dialogName ) + kind + 'Url' ];
+		if ( url === undefined )
+			url = editor.config[ 'filebrowser' + kind + 'Url' ];
+		return url;
+	}
+
+	// Opens the file browser in a new window, for a "Browse Server" button.
+	function browseServer() {
+		var dialog = this.getDialog(),
+			editor = dialog.getParentEditor();
+		editor._.filebrowserSe = this;
+		var url = addQueryString( this.filebrowser.url, callbackParams( editor ) );
+		window.open( url, 'filebrowser', 'width=900,height=600,location=no,menubar=no,toolbar=no,scrollbars=yes,resizable=yes' );
+	}
+
+	// Checks that there is a file to upload, before the upload button submits it.
+	function uploadFile() {
+		var dialog = this.getDialog(),
+			editor = dialog.getParentEditor();
+		editor._.filebrowserSe = this;
+		var input = dialog.getContentElement( this[ 'for' ][ 0 ], this[ 'for' ][ 1 ] );
+		return !!( input.getInputElement().$.value && input.getAction() );
+	}
+
+	// Puts the URL the server answered with into the element the button targets.
+	function setUrl( fileUrl, message ) {
+		var source = this._.filebrowserSe,
+			dialog = source.getDialog();
+		if ( source[ 'for' ] )
+			dialog.getContentElement( source[ 'for' ][ 0 ], source[ 'for' ][ 1 ] ).reset();
+		if ( message )
+			alert( message );
+		if ( fileUrl && source.filebrowser.target ) {
+			var target = source.filebrowser.target.split( ':' ),
+				element = dialog.getContentElement( target[ 0 ], target[ 1 ] );
+			if ( element ) {
+				element.setValue( fileUrl );
+				dialog.selectPage( target[ 0 ] );
+			}
+		}
+	}
+
+	function attachFileBrowser( editor, dialogName, definition, elements ) {
+		if ( !elements || !elements.length )
+			return;
+		for ( var i = 0; i < elements.length; i++ ) {
+			var element = elements[ i ];
+			if ( element.type == 'hbox' || element.type == 'vbox' || element.type == 'fieldset' )
+				attachFileBrowser( editor, dialogName, definition, element.children );
+			if ( !element.filebrowser )
+				continue;
+			if ( typeof element.filebrowser == 'string' ) {
+				element.filebrowser = {
+					action: element.type == 'fileButton' ? 'QuickUpload' : 'Browse',
+					target: element.filebrowser
+				};
+			}
+
+			var url;
+			if ( element.filebrowser.action == 'Browse' ) {
+				url = element.filebrowser.url || configuredUrl( editor, dialogName, 'Browse' );
+				if ( url ) {
+					element.onClick = browseServer;
+					element.filebrowser.url = url;
+					element.hidden = false;
+				}
+			} else if ( element.filebrowser.action == 'QuickUpload' && element[ 'for' ] ) {
+				url = element.filebrowser.url || configuredUrl( editor, dialogName, 'Upload' );
+				if ( url ) {
+					var onClick = element.onClick;
+					element.onClick = function( evt ) {
+						if ( onClick && onClick.call( evt.sender, evt ) === false )
+							return false;
+						return uploadFile.call( evt.sender, evt );
+					};
+					element.filebrowser.url = url;
+					element.hidden = false;
+					var fileInput = definition.getContents( element[ 'for' ][ 0 ] ).get( element[ 'for' ][ 1 ] );
+					fileInput.action = addQueryString( url, callbackParams( editor ) );
+					fileInput.filebrowser = element.filebrowser;
+				}
+			}
+		}
+	}
+
+	// Whether a tab's filebrowser element found a URL, so that the tab is shown.
+	function isConfigured( definition, tabId, elementId ) {
+		var element = definition.getContents( tabId ).get( elementId );
+		return !!( element && element.filebrowser && element.filebrowser.url );
+	}
+
+	CKEDITOR.plugins.add( 'filebrowser', {
+		requires: 'dialog',
+		init: function( editor ) {
+			editor._.filebrowserFn = CKEDITOR.tools.addFunction( setUrl, editor );
+			editor.on( 'destroy', function() {
+				CKEDITOR.tools.removeFunction( this._.filebrowserFn );
+			} );
+		}
+	} );
+
+	CKEDITOR.on( 'dialogDefinition', function( evt ) {
+		if ( !evt.editor.plugins.filebrowser )
+			return;
+		var definition = evt.data.definition;
+		for ( var i = 0; i < definition.contents.length; i++ ) {
+			var tab = definition.contents[ i ];
+			if ( !tab )
+				continue;
+			attachFileBrowser( evt.editor, evt.data.name, definition, tab.elements );
+			if ( tab.hidden && tab.filebrowser )
+				tab.hidden = !isConfigured( definition, tab.id, tab.filebrowser );
+		}
+	} );
+} )();
@@ -0,0 +1,104 @@
+//go:build appengine
+// +build appengine
+
+// Context implementation for the App Engine runtime.
+package blog
+
+import (
+	"appengine"
+	"appengine/memcache"
+	"appengine/urlfetch"
+	"appengine/user"
+	"net/http"
+	"time"
+)
+
+var (
+	// used when the "storage" setting is "memory"
+	memoryStore = NewMemoryStore()
+)
+
+// Setup the URL handlers at initialization
+func init() {
+	RegisterHandlers(http.DefaultServeMux, newAppengineContext)
+}
+
+// appengineContext adapts appengine.Context to a Context.
+type appengineContext struct {
+	appengine.Context
+}
+
+func newAppengineContext(r *http.Request) Context {
+	return appengineContext{appengine.NewContext(r)}
+}
+
+// Store returns the Store configured for this site.
+func (c appengineContext) Store() Store {
+	if storage, _ := config.Get("storage"); storage == "memory" {
+		return memoryStore
+	}
+	return NewDatastoreStore(c.Context)
+}
+
+// Blobs returns where uploaded files are kept.
+func (c appengineContext) Blobs() BlobStore {
+	return NewDatastoreBlobStore(c.Context)
+}
+
+func (c appengineContext) Cache() Cache {
+	return memcacheCache{c.Context}
+}
+
+func (c appengineContext) Client() *http.Client {
+	return urlfetch.Client(c.Context)
+}
+
+func (c appengineContext) CurrentUser() string {
+	if u := user.Current(c.Context); u != nil {
+		return u.String()
+	}
+	return ""
+}
+
+func (c appengineContext) VersionID() string {
+	return appengine.VersionID(c.Context)
+}
+
+// memcacheCache is a Cache backed by App Engine memcache.
+type memcacheCache struct {
+	c appengine.Context
+}
+
+func (m memcacheCache) Get(key string) ([]byte, error) {
+	item, err := memcache.Get(m.c, key)
+	if err == memcache.ErrCacheMiss {
+		return nil, ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+func (m memcacheCache) Add(key string, value []byte, ttl time.Duration) error {
+	if appengine.IsDevAppServer() {
+		m.c.Infof("This is a dev appserver, ignoring TTL of %s", ttl)
+		ttl = time.Second
+	}
+	err := memcache.Add(m.c, &memcache.Item{Key: key, Value: value, Expiration: ttl})
+	if err == memcache.ErrNotStored {
+		return ErrNotStored
+	}
+	return err
+}
+
+func (m memcacheCache) Set(key string, value []byte, ttl time.Duration) error {
+	if appengine.IsDevAppServer() {
+		m.c.Infof("This is a dev appserver, ignoring TTL of %s", ttl)
+		ttl = time.Second
+	}
+	return memcache.Set(m.c, &memcache.Item{Key: key, Value: value, Expiration: ttl})
+}
+
+func (m memcacheCache) Flush() error {
+	return memcache.Flush(m.c)
+}
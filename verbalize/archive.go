@@ -0,0 +1,99 @@
+// Year and month archives, and an index of them.
+package blog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	// regexp matching a date archive: /2014/, /2014/05/ and their /page/N pages.
+	date_archive_re = regexp.MustCompile(`^/(\d{4})(?:/(\d{2}))?(?:/page/(\d+))?/?$`)
+)
+
+// One month of the archive index.
+type ArchiveMonth struct {
+	Month       time.Month
+	MonthString string
+	Count       int
+	URL         string
+}
+
+// One year of the archive index, with the months that have posts in it.
+type ArchiveYear struct {
+	Year   int
+	Count  int
+	URL    string
+	Months []ArchiveMonth
+}
+
+// dateArchive returns the query and title for the archive of a year, or of a
+// month if one is given.
+func dateArchive(year string, month string) (query EntryQuery, title string, err error) {
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return query, "", err
+	}
+	query.Start = time.Date(y, time.January, 1, 0, 0, 0, 0, location)
+	query.End = query.Start.AddDate(1, 0, 0)
+	title = year
+
+	if month != "" {
+		m, err := strconv.Atoi(month)
+		if err != nil || m < 1 || m > 12 {
+			return query, "", fmt.Errorf("invalid month: %s", month)
+		}
+		query.Start = time.Date(y, time.Month(m), 1, 0, 0, 0, 0, location)
+		query.End = query.Start.AddDate(0, 1, 0)
+		title = fmt.Sprintf("%s %d", time.Month(m), y)
+	}
+	return query, title, nil
+}
+
+// dateArchiveURL returns the URL of a page of a year or month archive.
+func dateArchiveURL(year string, month string, page int) string {
+	url := "/" + year + "/"
+	if month != "" {
+		url += month + "/"
+	}
+	if page > 1 {
+		url += fmt.Sprintf("page/%d", page)
+	}
+	return url
+}
+
+// GetPublishDates retrieves the publish dates of entries matching params
+func GetPublishDates(c Context, params EntryQuery) ([]time.Time, error) {
+	return c.Store().GetPublishDates(params)
+}
+
+// getArchiveIndex counts the published posts in each month, newest first.
+func getArchiveIndex(c Context) (years []ArchiveYear, err error) {
+	dates, err := GetPublishDates(c, EntryQuery{IsPage: false})
+	if err != nil {
+		return nil, err
+	}
+	// dates are newest first, so each new year or month starts a new group.
+	for _, date := range dates {
+		date = date.In(location)
+		year := strconv.Itoa(date.Year())
+		if len(years) == 0 || years[len(years)-1].Year != date.Year() {
+			years = append(years, ArchiveYear{Year: date.Year(), URL: dateArchiveURL(year, "", 1)})
+		}
+		y := &years[len(years)-1]
+		y.Count++
+
+		if len(y.Months) == 0 || y.Months[len(y.Months)-1].Month != date.Month() {
+			month := fmt.Sprintf("%02d", date.Month())
+			y.Months = append(y.Months, ArchiveMonth{
+				Month:       date.Month(),
+				MonthString: date.Month().String(),
+				URL:         dateArchiveURL(year, month, 1),
+			})
+		}
+		y.Months[len(y.Months)-1].Count++
+	}
+	return years, nil
+}
@@ -0,0 +1,160 @@
+package blog
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// datedEntry returns a published entry from a day of a month.
+func datedEntry(slug string, year int, month time.Month, day int) SavedEntry {
+	e := testEntry(slug, 0)
+	e.PublishDate = time.Date(year, month, day, 12, 0, 0, 0, location)
+	e.setRelativeURL()
+	return e
+}
+
+func TestDateArchive(t *testing.T) {
+	tests := []struct {
+		year, month string
+		start, end  time.Time
+		title       string
+	}{
+		{"2014", "", time.Date(2014, 1, 1, 0, 0, 0, 0, location), time.Date(2015, 1, 1, 0, 0, 0, 0, location), "2014"},
+		{"2014", "05", time.Date(2014, 5, 1, 0, 0, 0, 0, location), time.Date(2014, 6, 1, 0, 0, 0, 0, location), "May 2014"},
+		{"2014", "12", time.Date(2014, 12, 1, 0, 0, 0, 0, location), time.Date(2015, 1, 1, 0, 0, 0, 0, location), "December 2014"},
+	}
+	for _, tt := range tests {
+		query, title, err := dateArchive(tt.year, tt.month)
+		if err != nil {
+			t.Errorf("dateArchive(%s, %s): %v", tt.year, tt.month, err)
+			continue
+		}
+		if !query.Start.Equal(tt.start) || !query.End.Equal(tt.end) || title != tt.title {
+			t.Errorf("dateArchive(%s, %s) = %s to %s, %q, want %s to %s, %q", tt.year, tt.month, query.Start, query.End, title, tt.start, tt.end, tt.title)
+		}
+	}
+	for _, month := range []string{"00", "13"} {
+		if _, _, err := dateArchive("2014", month); err == nil {
+			t.Errorf("dateArchive(2014, %s) returned no error", month)
+		}
+	}
+}
+
+func TestDateArchiveURLs(t *testing.T) {
+	tests := []struct {
+		path        string
+		year, month string
+		page        string
+	}{
+		{"/2014/", "2014", "", ""},
+		{"/2014/05/", "2014", "05", ""},
+		{"/2014/page/2", "2014", "", "2"},
+		{"/2014/05/page/3", "2014", "05", "3"},
+	}
+	for _, tt := range tests {
+		m := date_archive_re.FindStringSubmatch(tt.path)
+		if m == nil || m[1] != tt.year || m[2] != tt.month || m[3] != tt.page {
+			t.Errorf("date_archive_re matched %s as %q, want year %q month %q page %q", tt.path, m, tt.year, tt.month, tt.page)
+			continue
+		}
+		page, _ := strconv.Atoi(tt.page)
+		if page < 1 {
+			page = 1
+		}
+		if url := dateArchiveURL(tt.year, tt.month, page); url != tt.path {
+			t.Errorf("dateArchiveURL(%s, %s, %d) = %s, want %s", tt.year, tt.month, page, url, tt.path)
+		}
+	}
+	// Posts are at /2014/05/slug, which mustn't be taken for an archive.
+	for _, path := range []string{"/2014/05/slug", "/2014/5/", "/about"} {
+		if m := date_archive_re.FindStringSubmatch(path); m != nil {
+			t.Errorf("date_archive_re matched %s as %q", path, m)
+		}
+	}
+}
+
+func TestGetArchiveIndex(t *testing.T) {
+	c := newTestContext("")
+	hidden := datedEntry("hidden", 2013, time.March, 1)
+	hidden.IsHidden = true
+	page := datedEntry("page", 2013, time.April, 1)
+	page.IsPage = true
+	putEntries(t, c,
+		datedEntry("may-1", 2014, time.May, 1),
+		datedEntry("may-20", 2014, time.May, 20),
+		datedEntry("january", 2014, time.January, 5),
+		datedEntry("march", 2013, time.March, 2),
+		hidden, page,
+		testEntry("scheduled", -1),
+	)
+
+	years, err := getArchiveIndex(c)
+	if err != nil {
+		t.Fatalf("getArchiveIndex: %v", err)
+	}
+	want := []ArchiveYear{
+		{Year: 2014, Count: 3, URL: "/2014/", Months: []ArchiveMonth{
+			{time.May, "May", 2, "/2014/05/"},
+			{time.January, "January", 1, "/2014/01/"},
+		}},
+		{Year: 2013, Count: 1, URL: "/2013/", Months: []ArchiveMonth{
+			{time.March, "March", 1, "/2013/03/"},
+		}},
+	}
+	if len(years) != len(want) {
+		t.Fatalf("getArchiveIndex = %+v, want %+v", years, want)
+	}
+	for i := range want {
+		got := years[i]
+		if got.Year != want[i].Year || got.Count != want[i].Count || got.URL != want[i].URL || len(got.Months) != len(want[i].Months) {
+			t.Errorf("year %d = %+v, want %+v", i, got, want[i])
+			continue
+		}
+		for j := range want[i].Months {
+			if got.Months[j] != want[i].Months[j] {
+				t.Errorf("%d month %d = %+v, want %+v", got.Year, j, got.Months[j], want[i].Months[j])
+			}
+		}
+	}
+}
+
+func TestServingDateArchives(t *testing.T) {
+	c := newTestContext("")
+	putEntries(t, c,
+		datedEntry("may-post", 2014, time.May, 1),
+		datedEntry("june-post", 2014, time.June, 1),
+		datedEntry("older-post", 2013, time.May, 1),
+	)
+
+	tests := []struct {
+		year, month string
+		code        int
+		want        []string
+	}{
+		{"2014", "", http.StatusOK, []string{"june-post", "may-post"}},
+		{"2014", "05", http.StatusOK, []string{"may-post"}},
+		{"2014", "07", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		path := dateArchiveURL(tt.year, tt.month, 1)
+		if w := serve(rootHandler, "GET", path, nil, ""); w.Code != tt.code {
+			t.Errorf("%s gave %d, want %d", path, w.Code, tt.code)
+		}
+		query, _, _ := dateArchive(tt.year, tt.month)
+		entries, _, err := GetEntries(c, query)
+		if err != nil {
+			t.Fatalf("GetEntries(%s): %v", path, err)
+		}
+		if got := slugs(entries); !equalStrings(got, tt.want) {
+			t.Errorf("%s has %v, want %v", path, got, tt.want)
+		}
+	}
+	if w := serve(rootHandler, "GET", "/2014/13/", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("/2014/13/ gave %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := serve(rootHandler, "GET", "/archive", nil, ""); w.Code != http.StatusOK {
+		t.Errorf("/archive gave %d, want %d", w.Code, http.StatusOK)
+	}
+}
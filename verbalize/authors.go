@@ -0,0 +1,263 @@
+// Authors: profiles shown alongside their entries, and the roles that decide
+// what each of them may do in the admin.
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Manages authors and everything else.
+	ROLE_ADMIN = "admin"
+	// Publishes, and looks after links, comments and the trash.
+	ROLE_EDITOR = "editor"
+	// Writes their own entries, and sends them for review.
+	ROLE_CONTRIBUTOR = "contributor"
+)
+
+var (
+	// Roles from least to most powerful.
+	roles = []string{ROLE_CONTRIBUTOR, ROLE_EDITOR, ROLE_ADMIN}
+
+	role_ranks = map[string]int{ROLE_CONTRIBUTOR: 1, ROLE_EDITOR: 2, ROLE_ADMIN: 3}
+)
+
+// Author profile struct, stored in Datastore and keyed by ID, which is what
+// /author/<id> is found by.
+type SavedAuthor struct {
+	ID string
+	// The user this is the profile of, as returned by Context.CurrentUser.
+	User        string
+	Role        string
+	DisplayName string
+	Bio         string   `datastore:",noindex"`
+	AvatarURL   string   `datastore:",noindex"`
+	Links       []string `datastore:",noindex"`
+}
+
+// Name returns what the author is called on the blog.
+func (a *SavedAuthor) Name() string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.ID
+}
+
+// GetAuthors retrieves every author profile
+func GetAuthors(c Context) ([]SavedAuthor, error) {
+	return c.Store().GetAuthors()
+}
+
+// GetAuthor retrieves an author profile by ID
+func GetAuthor(c Context, id string) (SavedAuthor, error) {
+	return c.Store().GetAuthor(id)
+}
+
+// GetAuthorByUser retrieves the profile of a user
+func GetAuthorByUser(c Context, user string) (SavedAuthor, error) {
+	return c.Store().GetAuthorByUser(user)
+}
+
+// PutAuthor saves an author profile
+func PutAuthor(c Context, a *SavedAuthor) error {
+	return c.Store().PutAuthor(a)
+}
+
+// defaultRole returns the role of users without a profile.
+func defaultRole() string {
+	role, err := config.Get("default_role")
+	if err != nil || role_ranks[role] == 0 {
+		return ROLE_ADMIN
+	}
+	return role
+}
+
+// currentRole returns the role of the current user.
+func currentRole(c Context) string {
+	if a, err := GetAuthorByUser(c, c.CurrentUser()); err == nil && role_ranks[a.Role] > 0 {
+		return a.Role
+	}
+	return defaultRole()
+}
+
+// hasRole returns true if the current user has role, or a more powerful one.
+func hasRole(c Context, role string) bool {
+	return role_ranks[currentRole(c)] >= role_ranks[role]
+}
+
+// requireRole wraps an admin handler so that it refuses users without role.
+func requireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := contextFor(r)
+		if !hasRole(c, role) {
+			c.Infof("%s needs the %s role for %s", c.CurrentUser(), role, r.URL.Path)
+			http.Error(w, fmt.Sprintf("Only users with the %s role can do that.", role), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authorID suggests a profile ID for a user: the part of an email address
+// before the @, made safe for a URL.
+func authorID(user string) string {
+	id := strings.ToLower(strings.SplitN(user, "@", 2)[0])
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, id)
+}
+
+// availableAuthorID returns the ID for a new profile for user: authorID(user),
+// or it with the lowest numeric suffix that isn't someone else's profile.
+func availableAuthorID(c Context, user string) string {
+	id := authorID(user)
+	for i := 2; i < MAX_SLUG_SUFFIX; i++ {
+		if existing, err := GetAuthor(c, id); err != nil || existing.User == user {
+			return id
+		}
+		id = fmt.Sprintf("%s-%d", authorID(user), i)
+	}
+	return ""
+}
+
+// addAuthors fills in the display name and page of each entry's author.
+func addAuthors(c Context, entries []EntryContext) {
+	authors, err := GetAuthors(c)
+	if err != nil {
+		c.Errorf("Unable to get authors: %v", err)
+	}
+	by_user := make(map[string]SavedAuthor)
+	for _, a := range authors {
+		by_user[a.User] = a
+	}
+	for i := range entries {
+		if a, ok := by_user[entries[i].Author]; ok {
+			entries[i].AuthorName = a.Name()
+			entries[i].AuthorURL = "author/" + a.ID
+		} else {
+			// Without a profile, at least don't show an email address.
+			entries[i].AuthorName = strings.SplitN(entries[i].Author, "@", 2)[0]
+		}
+	}
+}
+
+// parseAuthorForm reads the profile fields that authors may change themselves.
+func parseAuthorForm(r *http.Request, a *SavedAuthor) {
+	a.DisplayName = strings.TrimSpace(r.FormValue("display_name"))
+	a.Bio = strings.TrimSpace(r.FormValue("bio"))
+	a.AvatarURL = strings.TrimSpace(r.FormValue("avatar_url"))
+	a.Links = nil
+	for _, link := range strings.Split(r.FormValue("links"), "\n") {
+		if link = strings.TrimSpace(link); link != "" {
+			a.Links = append(a.Links, link)
+		}
+	}
+}
+
+// handler for /admin/authors - lists authors, and edits the one given by "id"
+func adminAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	context, _ := GetTemplateContext(nil, nil, "Authors", "admin_authors", r)
+	context.Authors, _ = GetAuthors(c)
+	context.Roles = roles
+	context.Author = &SavedAuthor{Role: ROLE_CONTRIBUTOR}
+	if id := r.FormValue("id"); id != "" {
+		if a, err := GetAuthor(c, id); err == nil {
+			context.Author = &a
+		}
+	}
+	renderTemplate(w, *adminAuthorsTpl, context)
+}
+
+// handler for /admin/submit_author - saves an author profile, including its role
+func adminSubmitAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Saving an author requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	a := SavedAuthor{
+		ID:   strings.TrimSpace(r.FormValue("id")),
+		User: strings.TrimSpace(r.FormValue("user")),
+		Role: r.FormValue("role"),
+	}
+	parseAuthorForm(r, &a)
+	if a.ID == "" {
+		a.ID = authorID(a.User)
+	}
+	if a.User == "" || validateSlug(a.ID) != "" {
+		http.Error(w, "An author needs a user, and an ID made of letters, numbers and dashes", http.StatusBadRequest)
+		return
+	}
+	if role_ranks[a.Role] == 0 {
+		http.Error(w, fmt.Sprintf("Unknown role: %q", a.Role), http.StatusBadRequest)
+		return
+	}
+	if existing, err := GetAuthorByUser(c, a.User); err == nil && existing.ID != a.ID {
+		http.Error(w, fmt.Sprintf("%s already has a profile: %s", a.User, existing.ID), http.StatusConflict)
+		return
+	}
+	if existing, err := GetAuthor(c, a.ID); err == nil && existing.User != a.User {
+		http.Error(w, fmt.Sprintf("%s is already the profile of %s", a.ID, existing.User), http.StatusConflict)
+		return
+	}
+	if err := PutAuthor(c, &a); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Saved author %s (%s, %s)", a.ID, a.User, a.Role)
+	c.Cache().Flush()
+	http.Redirect(w, r, "/admin/authors", http.StatusFound)
+}
+
+// handler for /admin/delete_author - removes an author profile
+func adminDeleteAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Deleting requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	id := strings.TrimSpace(r.FormValue("id"))
+	if err := c.Store().DeleteAuthor(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Cache().Flush()
+	http.Redirect(w, r, "/admin/authors", http.StatusFound)
+}
+
+// handler for /admin/profile - lets anyone edit their own profile
+func adminProfileHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	user := c.CurrentUser()
+	a, err := GetAuthorByUser(c, user)
+	if err != nil {
+		a = SavedAuthor{User: user, Role: currentRole(c)}
+	}
+
+	if r.Method == "POST" {
+		if a.ID == "" {
+			// A new profile mustn't take over someone else's with the same ID.
+			if a.ID = availableAuthorID(c, user); a.ID == "" {
+				http.Error(w, fmt.Sprintf("Unable to find a free profile ID for %s", user), http.StatusConflict)
+				return
+			}
+		}
+		parseAuthorForm(r, &a)
+		if err := PutAuthor(c, &a); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.Cache().Flush()
+		http.Redirect(w, r, "/admin/profile", http.StatusFound)
+		return
+	}
+	context, _ := GetTemplateContext(nil, nil, "Profile", "admin_profile", r)
+	context.Author = &a
+	renderTemplate(w, *adminProfileTpl, context)
+}
@@ -0,0 +1,73 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAuthorID(t *testing.T) {
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"bob@example.com", "bob"},
+		{"Bob.Smith@example.com", "bob-smith"},
+		{"bob", "bob"},
+		{"bob_2+blog@example.com", "bob_2-blog"},
+	}
+	for _, tt := range tests {
+		if got := authorID(tt.user); got != tt.want {
+			t.Errorf("authorID(%q) = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestProfilesDontTakeOverOthersWithTheSameID(t *testing.T) {
+	c := newTestContext("")
+	putAuthor(t, c, "bob@a.com", ROLE_EDITOR)
+
+	form := url.Values{"display_name": {"The other Bob"}}
+	w := serve(adminProfileHandler, "POST", "/admin/profile", form, "bob@b.com")
+	if w.Code != http.StatusFound {
+		t.Fatalf("saving a profile gave %d: %s", w.Code, w.Body)
+	}
+	first, err := GetAuthor(c, "bob")
+	if err != nil || first.User != "bob@a.com" || first.Role != ROLE_EDITOR {
+		t.Errorf("GetAuthor(bob) = %+v, %v; want bob@a.com's editor profile", first, err)
+	}
+	second, err := GetAuthorByUser(c, "bob@b.com")
+	if err != nil || second.ID == "bob" || second.DisplayName != "The other Bob" {
+		t.Errorf("GetAuthorByUser(bob@b.com) = %+v, %v", second, err)
+	}
+
+	// Saving it again keeps the same profile.
+	form.Set("display_name", "Bob B.")
+	serve(adminProfileHandler, "POST", "/admin/profile", form, "bob@b.com")
+	if again, _ := GetAuthorByUser(c, "bob@b.com"); again.ID != second.ID || again.DisplayName != "Bob B." {
+		t.Errorf("after saving again, profile is %+v, want %s as Bob B.", again, second.ID)
+	}
+}
+
+func TestSubmittingAnAuthorRefusesSomeoneElsesID(t *testing.T) {
+	c := newTestContext("admin")
+	putAuthor(t, c, "bob@a.com", ROLE_EDITOR)
+
+	form := url.Values{"id": {"bob"}, "user": {"bob@b.com"}, "role": {ROLE_ADMIN}}
+	w := serve(adminSubmitAuthorHandler, "POST", "/admin/submit_author", form, "admin")
+	if w.Code != http.StatusConflict {
+		t.Errorf("submitting another user's ID gave %d, want %d", w.Code, http.StatusConflict)
+	}
+	if a, _ := GetAuthor(c, "bob"); a.User != "bob@a.com" || a.Role != ROLE_EDITOR {
+		t.Errorf("GetAuthor(bob) = %+v, want it unchanged", a)
+	}
+
+	form.Set("user", "bob@a.com")
+	w = serve(adminSubmitAuthorHandler, "POST", "/admin/submit_author", form, "admin")
+	if w.Code != http.StatusFound {
+		t.Errorf("submitting an author's own ID gave %d, want %d", w.Code, http.StatusFound)
+	}
+	if a, _ := GetAuthor(c, "bob"); a.Role != ROLE_ADMIN {
+		t.Errorf("role after saving = %s, want %s", a.Role, ROLE_ADMIN)
+	}
+}
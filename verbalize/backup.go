@@ -0,0 +1,651 @@
+// Exporting the whole site to a single archive, and restoring one into an
+// empty store, for backups and moving between hosts.
+package blog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Version of the archive format, so that later ones can still read this one.
+	// Version 2 added the media library, revisions, the trash and BaseURL.
+	ARCHIVE_VERSION = 2
+	// Name of the JSON document inside an archive.
+	ARCHIVE_SITE_FILE = "site.json"
+	// Directory of an archive that uploaded files are kept in, by blob name.
+	ARCHIVE_MEDIA_DIR = "media/"
+	// Largest image stored in an archive, and largest archive exported or
+	// restored, in bytes. App Engine accepts uploads of up to 32MB.
+	MAX_ARCHIVE_IMAGE_SIZE = 10 << 20
+	MAX_ARCHIVE_SIZE       = 32 << 20
+)
+
+var (
+	// regexp matching the source of an image in entry content
+	img_src_re = regexp.MustCompile(`<img[^>]+src="([^"]+)"`)
+	// regexps matching the source of an image in Markdown entry content,
+	// inline and as a reference
+	md_image_src_re     = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^\s)>]+)`)
+	md_reference_src_re = regexp.MustCompile(`(?m)^ {0,3}\[[^\]]+\]:\s*<?([^\s>]+)`)
+)
+
+// siteArchive is the JSON document at the heart of an archive. Drafts that
+// were autosaved but never submitted, the search index and the record of
+// which migrations have run are left out: the index is rebuilt on restore,
+// and migrations can safely run again.
+type siteArchive struct {
+	ArchiveVersion int
+	// The verbalize VERSION that made the archive, and when.
+	Version    string
+	ExportDate time.Time
+	// Where the site was, which the images in entries are resolved against.
+	BaseURL string
+	// The contents of verbalize.yml.
+	Config    string
+	Entries   []archivedEntry
+	Links     []SavedLink
+	Authors   []SavedAuthor
+	Redirects []SavedRedirect
+	// Images that entries show from outside the media library.
+	Images []archivedImage
+	// The media library, whose files are kept under ARCHIVE_MEDIA_DIR.
+	Media          []SavedMediaFile
+	Revisions      []archivedRevision
+	TrashedEntries []archivedTrashedEntry
+	TrashedLinks   []TrashedLink
+}
+
+// archivedEntry is an entry with its Content kept as text, so that the
+// archive can be read and edited by people as well as restored.
+type archivedEntry struct {
+	SavedEntry
+	Content string
+}
+
+// archivedRevision is a revision with its Content kept as text.
+type archivedRevision struct {
+	SavedRevision
+	Content string
+}
+
+// archivedTrashedEntry is an entry in the trash with its Content kept as text.
+type archivedTrashedEntry struct {
+	TrashedEntry
+	Content string
+}
+
+// archivedImage records where an image in the archive was found.
+type archivedImage struct {
+	URL string
+	// Name of the image inside the archive.
+	File string
+}
+
+// imageURLs returns the images referenced by entries, resolved against base.
+func imageURLs(entries []SavedEntry, base *url.URL) (urls []string) {
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		for _, match := range img_src_re.FindAllSubmatch(e.HTML(), -1) {
+			ref, err := url.Parse(string(match[1]))
+			if err != nil {
+				continue
+			}
+			u := base.ResolveReference(ref)
+			if u.Scheme != "http" && u.Scheme != "https" {
+				continue
+			}
+			if !seen[u.String()] {
+				seen[u.String()] = true
+				urls = append(urls, u.String())
+			}
+		}
+	}
+	return urls
+}
+
+// fetchImage downloads an image for an archive.
+func fetchImage(c Context, u string) ([]byte, error) {
+	resp, err := c.Client().Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_ARCHIVE_IMAGE_SIZE+1))
+	if err == nil && len(data) > MAX_ARCHIVE_IMAGE_SIZE {
+		err = fmt.Errorf("%s is larger than %d MB", u, MAX_ARCHIVE_IMAGE_SIZE>>20)
+	}
+	return data, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += n
+	return n, err
+}
+
+// historySlugs returns the slugs that the revisions of entries and trashed
+// entries are kept under.
+func historySlugs(entries []SavedEntry, trashed []TrashedEntry) (slugs []string) {
+	seen := make(map[string]bool)
+	add := func(slug string) {
+		if !seen[slug] {
+			seen[slug] = true
+			slugs = append(slugs, slug)
+		}
+	}
+	for _, e := range entries {
+		add(e.Slug)
+	}
+	for _, t := range trashed {
+		if t.HistorySlug != "" {
+			add(t.HistorySlug)
+		} else {
+			add(t.Slug)
+		}
+	}
+	return slugs
+}
+
+// mediaURLs returns the URLs of every variant of uploaded files, resolved
+// against base, which are archived with the media library rather than as
+// images.
+func mediaURLs(files []SavedMediaFile, base *url.URL) map[string]bool {
+	urls := make(map[string]bool)
+	for _, f := range files {
+		for _, v := range f.Variants {
+			urls[base.ResolveReference(&url.URL{Path: v.URL()}).String()] = true
+		}
+	}
+	return urls
+}
+
+// ExportSite writes an archive of every entry, page, link, author and
+// redirect, the media library, the history of entries and the trash, along
+// with the site config and the other images entries refer to. Images are
+// resolved against base, and skipped if they can't be fetched. The archive
+// is kept small enough to be restored: images that don't fit are skipped,
+// and if the rest doesn't fit it is an error.
+func ExportSite(c Context, w io.Writer, base *url.URL) error {
+	return exportSite(c, w, base, MAX_ARCHIVE_SIZE)
+}
+
+// exportSite is ExportSite, keeping the archive within limit bytes.
+func exportSite(c Context, w io.Writer, base *url.URL, limit int) error {
+	var entries []SavedEntry
+	for _, is_page := range []bool{false, true} {
+		found, _, err := GetEntries(c, EntryQuery{IsPage: is_page, IncludeHidden: true, IncludeScheduled: true})
+		if err != nil {
+			return err
+		}
+		entries = append(entries, found...)
+	}
+	site := siteArchive{ArchiveVersion: ARCHIVE_VERSION, Version: VERSION, ExportDate: time.Now(), BaseURL: base.String()}
+	for _, e := range entries {
+		site.Entries = append(site.Entries, archivedEntry{SavedEntry: e, Content: string(e.Content)})
+	}
+	var err error
+	if site.Links, err = GetLinks(c); err != nil {
+		return err
+	}
+	if site.Authors, err = GetAuthors(c); err != nil {
+		return err
+	}
+	if site.Redirects, err = c.Store().GetRedirects(); err != nil {
+		return err
+	}
+	trashed, err := c.Store().GetTrashedEntries()
+	if err != nil {
+		return err
+	}
+	for _, t := range trashed {
+		site.TrashedEntries = append(site.TrashedEntries, archivedTrashedEntry{TrashedEntry: t, Content: string(t.Content)})
+	}
+	if site.TrashedLinks, err = c.Store().GetTrashedLinks(); err != nil {
+		return err
+	}
+	for _, slug := range historySlugs(entries, trashed) {
+		revisions, err := GetRevisions(c, slug)
+		if err != nil {
+			return err
+		}
+		for _, rev := range revisions {
+			site.Revisions = append(site.Revisions, archivedRevision{SavedRevision: rev, Content: string(rev.Content)})
+		}
+	}
+	if config_data, err := ioutil.ReadFile(sitePath(CONFIG_PATH)); err == nil {
+		site.Config = string(config_data)
+	} else {
+		c.Errorf("Unable to read %s for export: %v", CONFIG_PATH, err)
+	}
+
+	too_large := fmt.Errorf("the archive would be larger than the %d MB that can be restored", limit>>20)
+	written := &countingWriter{w: w}
+	archive := zip.NewWriter(written)
+	media, err := GetMediaFiles(c)
+	if err != nil {
+		return err
+	}
+	for _, f := range media {
+		// Leave out the files whose blobs are gone, rather than archive them broken.
+		var blobs [][]byte
+		for _, v := range f.Variants {
+			data, err := c.Blobs().GetBlob(v.Blob)
+			if err != nil {
+				c.Errorf("Leaving %s (%s) out of export: %v", f.ID, f.Filename, err)
+				break
+			}
+			blobs = append(blobs, data)
+		}
+		if len(blobs) != len(f.Variants) {
+			continue
+		}
+		for i, v := range f.Variants {
+			if err := addToArchive(archive, ARCHIVE_MEDIA_DIR+v.Blob, blobs[i], site.ExportDate); err != nil {
+				return err
+			}
+		}
+		site.Media = append(site.Media, f)
+	}
+	if err := archive.Flush(); err != nil {
+		return err
+	}
+	if written.count > limit {
+		return too_large
+	}
+
+	// Room is left for the JSON document, which is written last. Images
+	// only add a line each to it.
+	data, err := json.MarshalIndent(site, "", "  ")
+	if err != nil {
+		return err
+	}
+	room := limit - len(data)
+	in_media := mediaURLs(site.Media, base)
+	for _, t := range trashed {
+		entries = append(entries, t.SavedEntry)
+	}
+	for i, u := range imageURLs(entries, base) {
+		if in_media[u] {
+			continue
+		}
+		data, err := fetchImage(c, u)
+		if err != nil {
+			c.Errorf("Leaving image out of export: %v", err)
+			continue
+		}
+		if err := archive.Flush(); err != nil {
+			return err
+		}
+		if written.count+len(data) > room {
+			c.Errorf("Leaving %s out of export: the archive would be too large to restore", u)
+			continue
+		}
+		image := archivedImage{URL: u, File: fmt.Sprintf("images/%d%s", i+1, path.Ext(path.Base(u)))}
+		if err := addToArchive(archive, image.File, data, site.ExportDate); err != nil {
+			return err
+		}
+		site.Images = append(site.Images, image)
+	}
+
+	if data, err = json.MarshalIndent(site, "", "  "); err != nil {
+		return err
+	}
+	if err := addToArchive(archive, ARCHIVE_SITE_FILE, data, site.ExportDate); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if written.count > limit {
+		return too_large
+	}
+	return nil
+}
+
+// addToArchive adds a file to a zip archive.
+func addToArchive(archive *zip.Writer, name string, data []byte, date time.Time) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	header.SetModTime(date)
+	f, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// readArchive reads the JSON document of an archive, and returns it along
+// with the other files in the archive, by name.
+func readArchive(data []byte) (site siteArchive, files map[string]*zip.File, err error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return site, nil, err
+	}
+	files = make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	f, ok := files[ARCHIVE_SITE_FILE]
+	if !ok {
+		return site, nil, fmt.Errorf("no %s in archive", ARCHIVE_SITE_FILE)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return site, nil, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(&site); err != nil {
+		return site, nil, err
+	}
+	if site.ArchiveVersion > ARCHIVE_VERSION {
+		return site, nil, fmt.Errorf("archive version %d is newer than this verbalize understands", site.ArchiveVersion)
+	}
+	return site, files, nil
+}
+
+// readArchiveFile returns the contents of a file in an archive.
+func readArchiveFile(files map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("no %s in archive", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(rc, MAX_ARCHIVE_IMAGE_SIZE+1))
+	if err == nil && len(data) > MAX_ARCHIVE_IMAGE_SIZE {
+		err = fmt.Errorf("%s is larger than %d MB", name, MAX_ARCHIVE_IMAGE_SIZE>>20)
+	}
+	return data, err
+}
+
+// isEmptyStore returns true if there are no entries, pages or links yet.
+func isEmptyStore(c Context) (bool, error) {
+	for _, is_page := range []bool{false, true} {
+		entries, _, err := GetEntries(c, EntryQuery{IsPage: is_page, IncludeHidden: true, IncludeScheduled: true, Count: 1})
+		if err != nil || len(entries) > 0 {
+			return false, err
+		}
+	}
+	links, err := GetLinks(c)
+	return len(links) == 0, err
+}
+
+// RestoreReport describes what was restored from an archive.
+type RestoreReport struct {
+	Entries int
+	Media   int
+	Images  int
+	// Files that couldn't be restored, and why. Entries still show images
+	// that weren't restored from where they were.
+	Skipped []string
+	// The archive's verbalize.yml, if it differs from the one deployed with
+	// the application, which a restore can't change.
+	Config string
+}
+
+// rewriteImageURLs points the images in content at the new URLs of those
+// that were restored from an archive, which moved gives by their old URL.
+// Image sources are resolved against base, or with no base, looked up as
+// they are.
+func rewriteImageURLs(content []byte, format string, base *url.URL, moved map[string]string) []byte {
+	if len(moved) == 0 {
+		return content
+	}
+	res := []*regexp.Regexp{img_src_re}
+	if format == FORMAT_MARKDOWN {
+		res = append(res, md_image_src_re, md_reference_src_re)
+	}
+	for _, re := range res {
+		content = re.ReplaceAllFunc(content, func(match []byte) []byte {
+			i := re.FindSubmatchIndex(match)
+			ref, err := url.Parse(string(match[i[2]:i[3]]))
+			if err != nil {
+				return match
+			}
+			old := ref.String()
+			if base != nil {
+				old = base.ResolveReference(ref).String()
+			}
+			to, ok := moved[old]
+			if !ok {
+				return match
+			}
+			rewritten := append([]byte(nil), match[:i[2]]...)
+			rewritten = append(rewritten, to...)
+			return append(rewritten, match[i[3]:]...)
+		})
+	}
+	return content
+}
+
+// restoreMedia writes an archived file of the media library and its variants
+// back to the blob store, deleting what it wrote if it can't write them all.
+func restoreMedia(c Context, f *SavedMediaFile, files map[string]*zip.File) error {
+	var written []string
+	for _, v := range f.Variants {
+		data, err := readArchiveFile(files, ARCHIVE_MEDIA_DIR+v.Blob)
+		if err == nil && !media_blob_re.MatchString(v.Blob) {
+			err = ErrInvalidBlobName
+		}
+		if err == nil {
+			err = c.Blobs().PutBlob(v.Blob, data)
+		}
+		if err != nil {
+			for _, blob := range written {
+				c.Blobs().DeleteBlob(blob)
+			}
+			return err
+		}
+		written = append(written, v.Blob)
+	}
+	return c.Store().PutMediaFile(f)
+}
+
+// restoreImages adds the images an archive kept for its entries to the media
+// library, and returns the URL of each one that was added, by its old URL.
+func restoreImages(c Context, site *siteArchive, files map[string]*zip.File, report *RestoreReport) (moved map[string]string) {
+	moved = make(map[string]string)
+	for _, image := range site.Images {
+		u, err := url.Parse(image.URL)
+		if err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", image.URL, err))
+			continue
+		}
+		data, err := readArchiveFile(files, image.File)
+		var f SavedMediaFile
+		if err == nil {
+			f, err = SaveMedia(c, path.Base(u.Path), data)
+		}
+		if err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", image.URL, err))
+			continue
+		}
+		moved[image.URL] = f.Original().URL()
+		if site.BaseURL == "" {
+			// Archives from before BaseURL was kept can only be matched by path.
+			moved[u.RequestURI()] = f.Original().URL()
+		}
+		report.Images++
+	}
+	return moved
+}
+
+// RestoreSite puts the contents of an archive into the store, with files
+// giving the rest of the archive. Its media library is written back to the
+// blob store, and its other images are added to the media library, with the
+// entries that show them changed to use their new URLs. verbalize.yml is
+// deployed with the application, so it is only reported if it differs.
+func RestoreSite(c Context, site *siteArchive, files map[string]*zip.File) (report RestoreReport, err error) {
+	for _, f := range site.Media {
+		if err := restoreMedia(c, &f, files); err != nil {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("%s: %v", f.Filename, err))
+			continue
+		}
+		report.Media++
+	}
+	moved := restoreImages(c, site, files, &report)
+	var base *url.URL
+	if site.BaseURL != "" {
+		if base, err = url.Parse(site.BaseURL); err != nil {
+			return report, err
+		}
+	}
+
+	var entries []SavedEntry
+	for _, archived := range site.Entries {
+		entry := archived.SavedEntry
+		entry.Content = rewriteImageURLs([]byte(archived.Content), entry.Format, base, moved)
+		if err := PutEntry(c, &entry); err != nil {
+			return report, err
+		}
+		entries = append(entries, entry)
+		report.Entries++
+	}
+	if err := indexEntries(c, entries); err != nil {
+		c.Errorf("Unable to index restored entries: %v", err)
+	}
+	for _, archived := range site.Revisions {
+		rev := archived.SavedRevision
+		// The store gives it a new ID, so that it can't take one it will give out.
+		rev.ID = 0
+		rev.Content = rewriteImageURLs([]byte(archived.Content), rev.Format, base, moved)
+		if err := PutRevision(c, &rev); err != nil {
+			return report, err
+		}
+	}
+	for _, archived := range site.TrashedEntries {
+		t := archived.TrashedEntry
+		t.Content = rewriteImageURLs([]byte(archived.Content), t.Format, base, moved)
+		if err := c.Store().PutTrashedEntry(&t); err != nil {
+			return report, err
+		}
+	}
+	for _, t := range site.TrashedLinks {
+		if err := c.Store().PutTrashedLink(&t); err != nil {
+			return report, err
+		}
+	}
+	for _, l := range site.Links {
+		if err := PutLink(c, &l); err != nil {
+			return report, err
+		}
+	}
+	for _, a := range site.Authors {
+		if err := PutAuthor(c, &a); err != nil {
+			return report, err
+		}
+	}
+	for _, r := range site.Redirects {
+		if err := c.Store().PutRedirect(&r); err != nil {
+			return report, err
+		}
+	}
+	if site.Config != "" {
+		deployed, _ := ioutil.ReadFile(sitePath(CONFIG_PATH))
+		if strings.TrimSpace(string(deployed)) != strings.TrimSpace(site.Config) {
+			report.Config = site.Config
+		}
+	}
+	return report, nil
+}
+
+// handler for /admin/backup - offers an export, and a form to restore one
+func adminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	context, _ := GetTemplateContext(nil, nil, "Backup", "admin_backup", r)
+	context.CanRestore, _ = isEmptyStore(c)
+	renderTemplate(w, *adminBackupTpl, context)
+}
+
+// handler for /admin/export - downloads an archive of the whole site
+func adminExportHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := &url.URL{Scheme: scheme, Host: r.Host, Path: config.Require("subdirectory")}
+
+	// Build the archive first, so that a failure can still be reported.
+	var buf bytes.Buffer
+	if err := ExportSite(c, &buf, base); err != nil {
+		http.Error(w, fmt.Sprintf("Unable to export: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"verbalize-%s.zip\"",
+		time.Now().In(location).Format("2006-01-02")))
+	w.Write(buf.Bytes())
+}
+
+// handler for /admin/restore - restores an archive into an empty store
+func adminRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Restoring requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	if empty, err := isEmptyStore(c); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !empty {
+		http.Error(w, "Archives can only be restored into a blog with no entries, pages or links.", http.StatusConflict)
+		return
+	}
+
+	file, _, err := r.FormFile("archive")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("No archive: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, MAX_ARCHIVE_SIZE+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(data) > MAX_ARCHIVE_SIZE {
+		http.Error(w, fmt.Sprintf("The archive is larger than %d MB, the most that can be restored.", MAX_ARCHIVE_SIZE>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	site, files, err := readArchive(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unable to read archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	report, err := RestoreSite(c, &site, files)
+	c.Cache().Flush()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Restore stopped after %d entries: %v", report.Entries, err), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Restored %d entries, %d uploads and %d images from an archive made %s, skipping %d files",
+		report.Entries, report.Media, report.Images, site.ExportDate, len(report.Skipped))
+	context, _ := GetTemplateContext(nil, nil, "Backup", "admin_backup", r)
+	context.Restore = &report
+	renderTemplate(w, *adminBackupTpl, context)
+}
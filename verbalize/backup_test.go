@@ -0,0 +1,244 @@
+package blog
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testPNG returns a PNG image width by height pixels across.
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRewriteImageURLs(t *testing.T) {
+	base, _ := url.Parse("http://old.example.com/blog/")
+	moved := map[string]string{
+		"http://old.example.com/blog/a.png": "/media/1/original.png",
+		"http://cdn.example.com/b.png":      "/media/2/original.png",
+	}
+	tests := []struct {
+		content string
+		format  string
+		base    *url.URL
+		want    string
+	}{
+		{`<img alt="a" src="a.png">`, FORMAT_HTML, base, `<img alt="a" src="/media/1/original.png">`},
+		{`<img src="/blog/a.png"><img src="http://cdn.example.com/b.png">`, FORMAT_HTML, base, `<img src="/media/1/original.png"><img src="/media/2/original.png">`},
+		{`<img src="c.png"> <a href="a.png">a</a>`, FORMAT_HTML, base, `<img src="c.png"> <a href="a.png">a</a>`},
+		{"![b](http://cdn.example.com/b.png \"B\")", FORMAT_MARKDOWN, base, "![b](/media/2/original.png \"B\")"},
+		{"![a][a]\n\n[a]: a.png", FORMAT_MARKDOWN, base, "![a][a]\n\n[a]: /media/1/original.png"},
+		{"![b](http://cdn.example.com/b.png)", FORMAT_HTML, base, "![b](http://cdn.example.com/b.png)"},
+		{`<img src="http://cdn.example.com/b.png"><img src="a.png">`, FORMAT_HTML, nil, `<img src="/media/2/original.png"><img src="a.png">`},
+	}
+	for _, tt := range tests {
+		if got := string(rewriteImageURLs([]byte(tt.content), tt.format, tt.base, moved)); got != tt.want {
+			t.Errorf("rewriteImageURLs(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestExportAndRestoreSite(t *testing.T) {
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cat.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testPNG(t, 4, 4))
+	}))
+	defer elsewhere.Close()
+	cat := elsewhere.URL + "/cat.png"
+
+	c := newTestContextWithBlobs(t, "editor")
+	upload, err := SaveMedia(c, "upload.png", testPNG(t, 8, 8))
+	if err != nil {
+		t.Fatalf("SaveMedia: %v", err)
+	}
+	post := testEntry("post", 2)
+	post.Content = []byte(`<img src="` + upload.Original().URL() + `"><img src="` + cat + `">`)
+	notes := testEntry("notes", 1)
+	notes.Format = FORMAT_MARKDOWN
+	notes.Content = []byte("![cat](" + cat + ")")
+	gone := testEntry("gone", 3)
+	putEntries(t, c, post, notes, gone)
+	edited := post
+	edited.Title = "Edited"
+	if err := saveEntryRevision(c, &edited, &post); err != nil {
+		t.Fatalf("saveEntryRevision: %v", err)
+	}
+	if err := saveEntryRevision(c, &gone, nil); err != nil {
+		t.Fatalf("saveEntryRevision: %v", err)
+	}
+	if err := TrashEntry(c, "gone"); err != nil {
+		t.Fatalf("TrashEntry: %v", err)
+	}
+	if err := PutLink(c, &SavedLink{Title: "Example", URL: "http://example.com/"}); err != nil {
+		t.Fatalf("PutLink: %v", err)
+	}
+	if err := TrashLink(c, "http://example.com/"); err != nil {
+		t.Fatalf("TrashLink: %v", err)
+	}
+
+	var archive bytes.Buffer
+	base, _ := url.Parse("http://blog.example.com/")
+	if err := ExportSite(c, &archive, base); err != nil {
+		t.Fatalf("ExportSite: %v", err)
+	}
+	site, files, err := readArchive(archive.Bytes())
+	if err != nil {
+		t.Fatalf("readArchive: %v", err)
+	}
+	if len(site.Images) != 1 || site.Images[0].URL != cat {
+		t.Errorf("archived images = %+v, want just %s", site.Images, cat)
+	}
+	site.Config = "title: Somewhere else\n"
+
+	restored := newTestContextWithBlobs(t, "editor")
+	report, err := RestoreSite(restored, &site, files)
+	if err != nil {
+		t.Fatalf("RestoreSite: %v", err)
+	}
+	if report.Entries != 2 || report.Media != 1 || report.Images != 1 || len(report.Skipped) != 0 {
+		t.Errorf("report = %+v, want 2 entries, 1 upload and 1 image", report)
+	}
+	if report.Config != site.Config {
+		t.Errorf("report.Config = %q, want the archive's differing config", report.Config)
+	}
+
+	if _, err := restored.Blobs().GetBlob(upload.Original().Blob); err != nil {
+		t.Errorf("GetBlob(%s) after restoring: %v", upload.Original().Blob, err)
+	}
+	media, _ := GetMediaFiles(restored)
+	if len(media) != 2 {
+		t.Fatalf("got %d media files after restoring, want the upload and the image", len(media))
+	}
+	var copied SavedMediaFile
+	for _, f := range media {
+		if f.ID != upload.ID {
+			copied = f
+		}
+	}
+	restored_post, _ := restored.Store().GetSingleEntry("post")
+	if want := `<img src="` + upload.Original().URL() + `"><img src="` + copied.Original().URL() + `">`; string(restored_post.Content) != want {
+		t.Errorf("post content = %q, want %q", restored_post.Content, want)
+	}
+	restored_notes, _ := restored.Store().GetSingleEntry("notes")
+	if want := "![cat](" + copied.Original().URL() + ")"; string(restored_notes.Content) != want {
+		t.Errorf("notes content = %q, want %q", restored_notes.Content, want)
+	}
+	if _, err := restored.Blobs().GetBlob(copied.Original().Blob); err != nil {
+		t.Errorf("GetBlob(%s): %v", copied.Original().Blob, err)
+	}
+
+	if revisions, _ := GetRevisions(restored, "post"); len(revisions) != 2 || revisions[0].Title != "Edited" {
+		t.Errorf("got revisions %+v of post, want 2, newest Edited", revisions)
+	}
+	trashed, err := restored.Store().GetTrashedEntry("gone")
+	if err != nil {
+		t.Errorf("GetTrashedEntry(gone): %v", err)
+	} else if revisions, _ := GetRevisions(restored, trashed.HistorySlug); len(revisions) != 1 {
+		t.Errorf("got %d revisions of the trashed entry, want 1", len(revisions))
+	}
+	if _, err := restored.Store().GetTrashedLink("http://example.com/"); err != nil {
+		t.Errorf("GetTrashedLink: %v", err)
+	}
+}
+
+func TestRestoreSiteSkipsImagesItCantRead(t *testing.T) {
+	c := newTestContextWithBlobs(t, "editor")
+	site := siteArchive{
+		ArchiveVersion: 1,
+		Entries:        []archivedEntry{{SavedEntry: testEntry("post", 1), Content: `<img src="http://example.com/a.svg">`}},
+		Images:         []archivedImage{{URL: "http://example.com/a.svg", File: "images/1.svg"}},
+	}
+	report, err := RestoreSite(c, &site, nil)
+	if err != nil {
+		t.Fatalf("RestoreSite: %v", err)
+	}
+	if report.Images != 0 || len(report.Skipped) != 1 || !strings.Contains(report.Skipped[0], "a.svg") {
+		t.Errorf("report = %+v, want a.svg skipped", report)
+	}
+	if post, _ := c.Store().GetSingleEntry("post"); string(post.Content) != `<img src="http://example.com/a.svg">` {
+		t.Errorf("post content = %q, want it unchanged", post.Content)
+	}
+}
+
+// noisyPNG returns a PNG image of random pixels, which doesn't compress.
+func noisyPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportSiteKeepsWithinItsLimit(t *testing.T) {
+	noise := make([]byte, 200<<10)
+	rand.New(rand.NewSource(2)).Read(noise)
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(noise)
+	}))
+	defer elsewhere.Close()
+
+	c := newTestContextWithBlobs(t, "editor")
+	post := testEntry("post", 1)
+	post.Content = []byte(`<img src="` + elsewhere.URL + `/noise.png">`)
+	putEntries(t, c, post)
+	base, _ := url.Parse("http://blog.example.com/")
+
+	for _, tt := range []struct {
+		limit  int
+		images int
+	}{{1 << 20, 1}, {100 << 10, 0}} {
+		var archive bytes.Buffer
+		if err := exportSite(c, &archive, base, tt.limit); err != nil {
+			t.Fatalf("exportSite within %d bytes: %v", tt.limit, err)
+		}
+		if archive.Len() > tt.limit {
+			t.Errorf("archive is %d bytes, over its limit of %d", archive.Len(), tt.limit)
+		}
+		if site, _, err := readArchive(archive.Bytes()); err != nil || len(site.Images) != tt.images {
+			t.Errorf("within %d bytes, archived %d images (%v), want %d", tt.limit, len(site.Images), err, tt.images)
+		}
+	}
+
+	if _, err := SaveMedia(c, "noise.png", noisyPNG(t, 200, 200)); err != nil {
+		t.Fatalf("SaveMedia: %v", err)
+	}
+	if err := exportSite(c, &bytes.Buffer{}, base, 100<<10); err == nil {
+		t.Errorf("exportSite succeeded with uploads larger than its limit")
+	}
+}
+
+func TestRestoringAnArchiveThatIsTooLarge(t *testing.T) {
+	newTestContext("admin")
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("archive", "huge.zip")
+	part.Write(make([]byte, MAX_ARCHIVE_SIZE+1))
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/admin/restore", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.SetBasicAuth("admin", "")
+	w := httptest.NewRecorder()
+	adminRestoreHandler(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "larger than 32 MB") {
+		t.Errorf("restoring an archive that's too large gave %d: %s", w.Code, w.Body)
+	}
+}
@@ -1,15 +1,14 @@
 package blog
 
 import (
-	"appengine"
-	"appengine/datastore"
-	"appengine/memcache"
 	"bytes"
+	"fmt"
 	"github.com/kylelemons/go-gypsy/yaml"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
@@ -21,24 +20,43 @@ const (
 	// Internal constants
 	CONFIG_PATH = "verbalize.yml"
 	VERSION     = "one.20140305"
+
+	// How long after it was published an entry must change to be shown as
+	// updated, so that fixing a typo straight away doesn't count.
+	UPDATED_NOTICE_AFTER = time.Hour
 )
 
 var (
-	config = yaml.ConfigFile(CONFIG_PATH)
+	site_dir = findSiteDir()
+	config   = yaml.ConfigFile(sitePath(CONFIG_PATH))
 
 	theme_path      = filepath.Join("themes", config.Require("theme"))
 	base_theme_path = filepath.Join(theme_path, "base.html")
 
-	archiveTpl       = loadTemplate(base_theme_path, filepath.Join(theme_path, "archive.html"))
-	entryTpl         = loadTemplate(base_theme_path, filepath.Join(theme_path, "entry.html"))
-	pageTpl          = loadTemplate(base_theme_path, filepath.Join(theme_path, "page.html"))
-	errorTpl         = loadTemplate(base_theme_path, "templates/error.html")
-	feedTpl          = loadTemplate("templates/feed.html")
-	adminEditTpl     = loadTemplate("templates/admin/base.html", "templates/admin/edit.html")
-	adminHomeTpl     = loadTemplate("templates/admin/base.html", "templates/admin/home.html")
-	adminPagesTpl    = loadTemplate("templates/admin/base.html", "templates/admin/pages.html")
-	adminLinksTpl    = loadTemplate("templates/admin/base.html", "templates/admin/links.html")
-	adminCommentsTpl = loadTemplate("templates/admin/base.html", "templates/admin/comments.html")
+	archiveTpl         = loadTemplate(base_theme_path, filepath.Join(theme_path, "archive.html"))
+	entryTpl           = loadTemplate(base_theme_path, filepath.Join(theme_path, "entry.html"))
+	pageTpl            = loadTemplate(base_theme_path, filepath.Join(theme_path, "page.html"))
+	errorTpl           = loadTemplate(base_theme_path, "templates/error.html")
+	archiveIndexTpl    = loadTemplate(base_theme_path, "templates/archive_index.html")
+	searchTpl          = loadTemplate(base_theme_path, "templates/search.html")
+	authorTpl          = loadTemplate(base_theme_path, "templates/author.html")
+	seriesTpl          = loadTemplate(base_theme_path, "templates/series.html")
+	feedTpl            = loadTemplate("templates/feed.html")
+	sitemapTpl         = loadTemplate("templates/sitemap.html")
+	adminEditTpl       = loadTemplate("templates/admin/base.html", "templates/admin/edit.html")
+	adminHomeTpl       = loadTemplate("templates/admin/base.html", "templates/admin/home.html")
+	adminPagesTpl      = loadTemplate("templates/admin/base.html", "templates/admin/pages.html")
+	adminLinksTpl      = loadTemplate("templates/admin/base.html", "templates/admin/links.html")
+	adminCommentsTpl   = loadTemplate("templates/admin/base.html", "templates/admin/comments.html")
+	adminRevisionsTpl  = loadTemplate("templates/admin/base.html", "templates/admin/revisions.html")
+	adminDiffTpl       = loadTemplate("templates/admin/base.html", "templates/admin/diff.html")
+	adminTrashTpl      = loadTemplate("templates/admin/base.html", "templates/admin/trash.html")
+	adminAuthorsTpl    = loadTemplate("templates/admin/base.html", "templates/admin/authors.html", "templates/admin/author_form.html")
+	adminProfileTpl    = loadTemplate("templates/admin/base.html", "templates/admin/profile.html", "templates/admin/author_form.html")
+	adminImportTpl     = loadTemplate("templates/admin/base.html", "templates/admin/import.html")
+	adminBackupTpl     = loadTemplate("templates/admin/base.html", "templates/admin/backup.html")
+	adminMigrationsTpl = loadTemplate("templates/admin/base.html", "templates/admin/migrations.html")
+	adminMediaTpl      = loadTemplate("templates/admin/base.html", "templates/admin/media.html")
 
 	// regexp matching an entry URL
 	edit_entry_re = regexp.MustCompile(`edit/(?P<slug>[\w-]+)$`)
@@ -46,77 +64,191 @@ var (
 
 /* All of the information we need to send about an entry to the template */
 type EntryContext struct {
-	Author         string
-	IsHidden       bool
-	IsPage         bool
-	AllowComments  bool
-	Timestamp      int64
-	Day            int
-	RfcDate        string
-	Hour           int
-	Minute         int
-	Month          time.Month
-	MonthString    string
-	Year           int
-	Title          template.HTML
-	Content        template.HTML
+	Author        string
+	AuthorName    string
+	AuthorURL     string
+	Status        string
+	IsHidden      bool
+	IsPage        bool
+	AllowComments bool
+	IsScheduled   bool
+	PublishDate   time.Time
+	Timestamp     int64
+	Day           int
+	RfcDate       string
+	// When the entry last changed, which is never before it was published.
+	UpdatedDate    time.Time
+	UpdatedRfcDate string
+	// Set if the entry changed a while after it was published.
+	IsUpdated   bool
+	Hour        int
+	Minute      int
+	Month       time.Month
+	MonthString string
+	Year        int
+	Title       template.HTML
+	Content     template.HTML
+	// Content as it was written, for the editor.
+	Source         string
+	Format         string
 	Excerpt        template.HTML
 	EscapedExcerpt string
 	IsExcerpted    bool
 	RelativeURL    string
 	Slug           string
+	Tags           []string
+	Categories     []string
+	// The series the entry is in, and where: SeriesOrder is the place it was
+	// given, and SeriesPart counts the published parts up to it, of SeriesParts.
+	Series         string
+	SeriesURL      string
+	SeriesOrder    int
+	SeriesPart     int
+	SeriesParts    int
+	SeriesPrevious *EntryLink
+	SeriesNext     *EntryLink
+	// Published posts on much the same subject, best first. Only filled in
+	// for the page of a single entry.
+	Related []EntryLink
+	// Whether the entry is pinned, and until when, as saved.
+	IsPinned    bool
+	PinnedUntil time.Time
+	// Set for pinned entries shown before the chronological list.
+	ShownPinned bool
+	// Custom metadata, by key: as the types declared in verbalize.yml, as
+	// written, and the fields that weren't declared, for the editor.
+	Metadata           map[string]interface{}
+	MetadataSource     map[string]string
+	UndeclaredMetadata []MetadataValue
+	Version            int64
+}
+
+// Another entry, for links to it.
+type EntryLink struct {
+	Title       template.HTML
+	RelativeURL string
 }
 
 // Entry struct, stored in Datastore.
 type SavedEntry struct {
-	Author        string
+	Author string
+	// Workflow status, one of the STATUS_ constants. IsHidden and IsScheduled
+	// follow from it, see setStatus.
+	Status        string
 	IsHidden      bool
 	IsPage        bool
 	AllowComments bool
-	PublishDate   time.Time
-	Title         string
-	Content       []byte
-	Slug          string
-	RelativeURL   string
-	// Unused: I haven't figured out how to delete this field from my tables yet.
-	RelativeUrl string
-}
-
-/* return a fetching key for a given entry */
-func (s *SavedEntry) Key(c appengine.Context) *datastore.Key {
-	return datastore.NewKey(c, "Entries", s.Slug, 0, nil)
+	// Set while PublishDate is in the future, until PublishScheduled runs.
+	IsScheduled bool
+	PublishDate time.Time
+	// When the entry was last saved from the editor, or restored from a revision.
+	UpdatedDate time.Time
+	Title       string
+	Content     []byte
+	// What Content is written in: FORMAT_HTML, or "" for entries saved
+	// before there was a choice, or FORMAT_MARKDOWN.
+	Format      string
+	Slug        string
+	RelativeURL string
+	Tags        []string
+	// Categories of entries imported from WordPress, which has both.
+	Categories []string
+	// The series the entry is in, if any, and its place there.
+	Series      string
+	SeriesOrder int
+	// Shown before the chronological list on the front page, until
+	// PinnedUntil if it is set.
+	IsPinned    bool
+	PinnedUntil time.Time
+	// Custom metadata fields, in the order they were written.
+	Metadata []MetadataValue `datastore:",noindex"`
+	// Incremented on every save, so that a save of an older version can be refused.
+	Version int64
 }
 
 /* Entry.Context() generates template data from a stored entry */
 func (s *SavedEntry) Context() EntryContext {
-	annotatedContent := bytes.Replace(s.Content, []byte("<img "), []byte("<img itemtype=\"image\" "), -1)
+	annotatedContent := bytes.Replace(s.HTML(), []byte("<img "), []byte("<img itemtype=\"image\" "), -1)
 	excerpt := bytes.SplitN(annotatedContent, []byte(config.Require("more_tag")),
 		2)[0]
 	log.Printf("ANNOTATED? %s", annotatedContent)
+	publishDate := s.PublishDate.In(location)
+	updatedDate := s.LastModified().In(location)
+	metadata, metadata_source := metadataMaps(s.Metadata)
 
 	return EntryContext{
-		Author:         s.Author,
-		IsHidden:       s.IsHidden,
-		IsPage:         s.IsPage,
-		AllowComments:  s.AllowComments,
-		Timestamp:      s.PublishDate.UTC().Unix(),
-		Day:            s.PublishDate.Day(),
-		Hour:           s.PublishDate.Hour(),
-		Minute:         s.PublishDate.Minute(),
-		Month:          s.PublishDate.Month(),
-		MonthString:    s.PublishDate.Month().String(),
-		Year:           s.PublishDate.Year(),
-		RfcDate:        s.PublishDate.Format(time.RFC3339),
-		Title:          template.HTML(s.Title),
-		Content:        template.HTML(annotatedContent),
-		Excerpt:        template.HTML(excerpt),
-		EscapedExcerpt: string(excerpt),
-		IsExcerpted:    len(s.Content) != len(excerpt),
-		RelativeURL:    s.RelativeURL,
-		Slug:           s.Slug,
+		Author:             s.Author,
+		Status:             s.CurrentStatus(),
+		IsHidden:           s.IsHidden,
+		IsPage:             s.IsPage,
+		AllowComments:      s.AllowComments,
+		IsScheduled:        s.IsScheduled,
+		PublishDate:        publishDate,
+		Timestamp:          publishDate.UTC().Unix(),
+		Day:                publishDate.Day(),
+		Hour:               publishDate.Hour(),
+		Minute:             publishDate.Minute(),
+		Month:              publishDate.Month(),
+		MonthString:        publishDate.Month().String(),
+		Year:               publishDate.Year(),
+		RfcDate:            publishDate.Format(time.RFC3339),
+		UpdatedDate:        updatedDate,
+		UpdatedRfcDate:     updatedDate.Format(time.RFC3339),
+		IsUpdated:          updatedDate.Sub(publishDate) >= UPDATED_NOTICE_AFTER,
+		Title:              template.HTML(s.Title),
+		Content:            template.HTML(annotatedContent),
+		Source:             string(s.Content),
+		Format:             s.Format,
+		Excerpt:            template.HTML(excerpt),
+		EscapedExcerpt:     string(excerpt),
+		IsExcerpted:        len(annotatedContent) != len(excerpt),
+		RelativeURL:        s.RelativeURL,
+		Slug:               s.Slug,
+		Tags:               s.Tags,
+		Categories:         s.Categories,
+		Series:             s.Series,
+		SeriesURL:          seriesURL(s.Series),
+		SeriesOrder:        s.SeriesOrder,
+		IsPinned:           s.IsPinned,
+		PinnedUntil:        s.PinnedUntil.In(location),
+		Metadata:           metadata,
+		MetadataSource:     metadata_source,
+		UndeclaredMetadata: undeclaredMetadata(s.Metadata),
+		Version:            s.Version,
+	}
+}
+
+// setRelativeURL sets where an entry lives, relative to the blog: pages at
+// their slug, and posts under the year and month they were published.
+func (s *SavedEntry) setRelativeURL() {
+	if s.IsPage {
+		s.RelativeURL = s.Slug
+	} else {
+		s.RelativeURL = fmt.Sprintf("%d/%02d/%s", s.PublishDate.Year(),
+			s.PublishDate.Month(), s.Slug)
 	}
 }
 
+// LastModified returns when an entry last changed. Entries are never
+// modified before they are published, as far as readers are concerned, and
+// ones saved before UpdatedDate was kept were last modified when published.
+func (s *SavedEntry) LastModified() time.Time {
+	if s.UpdatedDate.After(s.PublishDate) {
+		return s.UpdatedDate
+	}
+	return s.PublishDate
+}
+
+// lastModified returns when the most recently modified of entries changed.
+func lastModified(entries []SavedEntry) (modified time.Time) {
+	for i := range entries {
+		if t := entries[i].LastModified(); t.After(modified) {
+			modified = t
+		}
+	}
+	return modified
+}
+
 // Link struct, stored in Datastore.
 type SavedLink struct {
 	Title string
@@ -124,11 +256,6 @@ type SavedLink struct {
 	Order int64
 }
 
-/* return a fetching key for a given entry */
-func (sl *SavedLink) Key(c appengine.Context) *datastore.Key {
-	return datastore.NewKey(c, "Links", sl.URL, 0, nil)
-}
-
 /* This is sent to all templates */
 type GlobalTemplateContext struct {
 	SiteTitle       template.HTML
@@ -138,7 +265,7 @@ type GlobalTemplateContext struct {
 	BaseURL         template.HTML
 
 	Version string
-	Context appengine.Context
+	Context Context
 
 	PageTitle       string
 	PageId          string
@@ -155,6 +282,61 @@ type GlobalTemplateContext struct {
 	// If you would like to see more entries.
 	NextURL     string
 	PreviousURL string
+
+	// Custom metadata fields declared in verbalize.yml.
+	MetadataFields []MetadataField
+
+	// Post counts by year and month, for the archive index.
+	Archive []ArchiveYear
+
+	// What was searched for, and how many entries were found.
+	SearchQuery       string
+	SearchResultCount int
+
+	// Admin: an autosaved draft of the entry being edited.
+	Draft *SavedDraft
+	// Admin: the newer version of the entry being edited that a save conflicted with.
+	Conflict *SavedEntry
+	// Admin: the slug the entry being edited was saved under, or "" for a new
+	// one, and why the edit form is being shown again if it couldn't be saved.
+	OriginalSlug string
+	Problem      string
+	// Admin: whether the current user may publish entries.
+	CanPublish bool
+	// Admin: the workflow status entries are being listed for, the statuses
+	// there are, and how many entries have yet to be given one.
+	StatusFilter    string
+	Statuses        []string
+	UnmigratedCount int
+
+	// Admin: revision history and diffs.
+	Revisions []SavedRevision
+	TitleDiff []DiffOp
+	Diff      []DiffOp
+
+	// Admin: the trash.
+	TrashedEntries []TrashedEntry
+	TrashedLinks   []TrashedLink
+
+	// The author whose page this is, or whose profile is being edited.
+	Author *SavedAuthor
+	// Admin: every author, and the roles they may have.
+	Authors []SavedAuthor
+	Roles   []string
+
+	// Admin: what a WordPress import did, or would do.
+	Import *ImportReport
+	// Admin: whether the store is empty enough to restore a backup into, and
+	// what a restore did.
+	CanRestore bool
+	Restore    *RestoreReport
+	// Admin: every migration, and how far it got.
+	Migrations []MigrationStatus
+	// Admin: uploaded files, the variant of them the editor inserts, and the
+	// CKEditor callback to hand the chosen one to when picking for the editor.
+	MediaFiles      []SavedMediaFile
+	MediaVariant    string
+	CKEditorFuncNum string
 }
 
 /* Structure used for querying for blog entries */
@@ -163,21 +345,65 @@ type EntryQuery struct {
 	End           time.Time
 	Count         int
 	IncludeHidden bool
+	// Include entries whose PublishDate is still in the future.
+	IncludeScheduled bool
+	// Only entries still marked IsScheduled.
+	ScheduledOnly bool
 	IsPage        bool
-	Tag           string // unused
-	Offset        int
+	Tag           string
+	// Only entries in this workflow status.
+	Status string
+	// Only entries by this user.
+	Author string
+	// Only entries in this series.
+	Series string
+	// Only entries marked IsPinned, whether or not their pins have expired.
+	PinnedOnly bool
+	// Leave out the entries with these slugs. They don't count towards
+	// Count or Offset.
+	ExcludeSlugs []string
+	Offset       int
+	// Where to continue from, as returned by GetEntries. Unlike Offset, this
+	// costs the same however deep it is.
+	Cursor string
+}
+
+// findSiteDir returns the directory holding verbalize.yml, themes/ and
+// templates/. Servers run from it, but the package's tests run from the
+// directory below it.
+func findSiteDir() string {
+	if _, err := os.Stat(CONFIG_PATH); err != nil {
+		if _, err := os.Stat(filepath.Join("..", CONFIG_PATH)); err == nil {
+			return ".."
+		}
+	}
+	return "."
+}
+
+// sitePath returns the path of a file in the site directory.
+func sitePath(path string) string {
+	return filepath.Join(site_dir, path)
 }
 
 // load and configure set of templates
 func loadTemplate(paths ...string) *template.Template {
 	t := template.New(strings.Join(paths, ","))
 	t.Funcs(template.FuncMap{
-		"eq": reflect.DeepEqual,
+		"eq":   reflect.DeepEqual,
+		"join": strings.Join,
 		// see template_functions.go.
 		"DaysUntil":          DaysUntil,
+		"StatusLabel":        StatusLabel,
+		"HasRole":            hasRole,
 		"ExtractPageContent": ExtractPageContent,
+		// see tags.go.
+		"TagURL": tagURL,
 	})
-	_, err := t.ParseFiles(paths...)
+	files := make([]string, len(paths))
+	for i, path := range paths {
+		files[i] = sitePath(path)
+	}
+	_, err := t.ParseFiles(files...)
 	if err != nil {
 		panic(err)
 	}
@@ -197,10 +423,15 @@ func renderTemplate(w io.Writer, tmpl template.Template, context interface{}) {
 
 // GetTemplateContext creates a template context given a massive set of data.
 func GetTemplateContext(entries []SavedEntry, links []SavedLink, pageTitle string, pageId string, r *http.Request) (t GlobalTemplateContext, err error) {
+	c := contextFor(r)
 	entry_contexts := make([]EntryContext, 0, len(entries))
 	for _, entry := range entries {
 		entry_contexts = append(entry_contexts, entry.Context())
 	}
+	if len(entry_contexts) > 0 {
+		addAuthors(c, entry_contexts)
+		addSeries(c, entry_contexts)
+	}
 
 	/* See https://groups.google.com/forum/?fromgroups=#!topic/golang-nuts/ANpkd4zyjLU */
 	scheme := "http"
@@ -214,8 +445,6 @@ func GetTemplateContext(entries []SavedEntry, links []SavedLink, pageTitle strin
 	google_analytics_id, _ := config.Get("google_analytics_id")
 	google_analytics_domain, _ := config.Get("google_analytics_domain")
 
-	c := appengine.NewContext(r)
-
 	t = GlobalTemplateContext{
 		BaseURL:               template.HTML(base_url),
 		SiteTitle:             template.HTML(config.Require("title")),
@@ -232,72 +461,45 @@ func GetTemplateContext(entries []SavedEntry, links []SavedLink, pageTitle strin
 		DisqusId:              disqus_id,
 		GoogleAnalyticsId:     google_analytics_id,
 		GoogleAnalyticsDomain: google_analytics_domain,
+		MetadataFields:        metadata_fields,
 		Context:               c,
 	}
 	return t, err
 }
 
-// GetEntries retrieves all or some blog entries from datastore
-func GetEntries(c appengine.Context, params EntryQuery) (entries []SavedEntry, err error) {
-	q := datastore.NewQuery("Entries").Order(
-		"-PublishDate")
+// GetEntries retrieves all or some blog entries from the store, along with a
+// cursor for the entries that follow them if there are any.
+func GetEntries(c Context, params EntryQuery) (entries []SavedEntry, cursor string, err error) {
+	return c.Store().GetEntries(params)
+}
 
-	if params.Count > 0 {
-		q = q.Limit(params.Count)
-	}
-	if params.IsPage == false {
-		q = q.Filter("IsPage =", false)
-	}
-	if params.IsPage == true {
-		q = q.Filter("IsPage =", true)
-	}
-	if params.Start.IsZero() == false {
-		q = q.Filter("PublishDate >", params.End)
-	}
-	if params.End.IsZero() == false {
-		q = q.Filter("PublishDate <", params.End)
-	}
-	if params.IncludeHidden == false {
-		q = q.Filter("IsHidden = ", false)
-	}
-	if params.Offset > 0 {
-		q = q.Offset(params.Offset)
-	}
-	log.Printf("Query: %v", q)
+// GetSingleEntry retrieves a single blog entry by slug from the store
+func GetSingleEntry(c Context, slug string) (e SavedEntry, err error) {
+	return c.Store().GetSingleEntry(slug)
+}
 
-	_, err = q.GetAll(c, &entries)
-	return entries, err
+// PutEntry saves a blog entry to the store
+func PutEntry(c Context, e *SavedEntry) error {
+	return c.Store().PutEntry(e)
 }
 
-// GetSingleEntry retrieves a single blog entry by slug from datastore
-func GetSingleEntry(c appengine.Context, slug string) (e SavedEntry, err error) {
-	e.Slug = slug
-	err = datastore.Get(c, e.Key(c), &e)
-	return
+// GetLinks retrieves all links in order from the store
+func GetLinks(c Context) (links []SavedLink, err error) {
+	return c.Store().GetLinks()
 }
 
-// GetLinks retrieves all links in order from datastore
-func GetLinks(c appengine.Context) (links []SavedLink, err error) {
-	q := datastore.NewQuery("Links").Order("Order").Order("Title")
-	_, err = q.GetAll(c, &links)
-	return links, err
+// PutLink saves a link to the store
+func PutLink(c Context, l *SavedLink) error {
+	return c.Store().PutLink(l)
 }
 
-// Store content in memcache, logging and discarding errors.
-func storeInCache(c appengine.Context, key string, content []byte, ttl int) error {
-	if appengine.IsDevAppServer() {
-		c.Infof("This is a dev appserver, ignoring TTL of %d", ttl)
-		ttl = 1
-	}
-	item := &memcache.Item{
-		Key:        key,
-		Value:      content,
-		Expiration: time.Duration(ttl) * time.Second,
-	}
-	c.Infof("Caching contents of %s for %s", item.Key, item.Expiration)
-	err := memcache.Add(c, item)
+// Store content in the cache, logging and discarding errors.
+func storeInCache(c Context, key string, content []byte, ttl int) error {
+	expiration := time.Duration(ttl) * time.Second
+	c.Infof("Caching contents of %s for %s", key, expiration)
+	err := c.Cache().Add(key, content, expiration)
 	if err != nil {
-		c.Errorf("error adding %v to cache: %v", item, err)
+		c.Errorf("error adding %s to cache: %v", key, err)
 	}
 	return err
 }
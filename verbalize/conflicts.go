@@ -0,0 +1,40 @@
+// Catching simultaneous edits of an entry, so that one doesn't silently undo
+// the other.
+package blog
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// UpdateEntry saves a blog entry that was loaded at version to the store,
+// unless someone else has saved it since, in which case it returns
+// ErrVersionConflict.
+func UpdateEntry(c Context, e *SavedEntry, version int64) error {
+	return c.Store().UpdateEntry(e, version)
+}
+
+// renderEditConflict shows the edit form again with what was submitted, along
+// with how it differs from the newer version that was saved in the meantime.
+// Saving the form again replaces the newer version.
+func renderEditConflict(w http.ResponseWriter, r *http.Request, entry *SavedEntry, current *SavedEntry, original_slug string) {
+	c := contextFor(r)
+	problem := "Someone else saved this entry after you started editing it, so your changes weren't saved."
+	if revs, err := GetRevisions(c, current.Slug); err == nil && len(revs) > 0 {
+		problem = fmt.Sprintf("%s saved this entry at %s, after you started editing it, so your changes weren't saved.",
+			revs[0].Author, revs[0].Date.In(location).Format("15:04:05"))
+	}
+	problem += " Their changes are below: save again to replace them with yours, or start again from theirs."
+
+	// Base the form on the newer version, so that saving it again is deliberate.
+	entry.Version = current.Version
+	context, _ := GetTemplateContext([]SavedEntry{*entry}, nil, entry.Title, "admin_edit", r)
+	context.OriginalSlug = original_slug
+	context.CanPublish = isPublisher(c)
+	context.Problem = problem
+	context.Conflict = current
+	context.TitleDiff = Diff(current.Title, entry.Title, true)
+	context.Diff = Diff(string(current.Content), string(entry.Content), false)
+	w.WriteHeader(http.StatusConflict)
+	renderTemplate(w, *adminEditTpl, context)
+}
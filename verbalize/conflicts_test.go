@@ -0,0 +1,153 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestUpdateEntryRefusesAStaleVersion(t *testing.T) {
+	c := newTestContext("")
+	putEntries(t, c, testEntry("edited", 1))
+	loaded, _ := c.Store().GetSingleEntry("edited")
+
+	theirs := loaded
+	theirs.Title = "theirs"
+	if err := c.Store().UpdateEntry(&theirs, loaded.Version); err != nil {
+		t.Fatalf("first UpdateEntry: %v", err)
+	}
+	if theirs.Version != loaded.Version+1 {
+		t.Errorf("Version after UpdateEntry = %d, want %d", theirs.Version, loaded.Version+1)
+	}
+	ours := loaded
+	ours.Title = "ours"
+	if err := c.Store().UpdateEntry(&ours, loaded.Version); err != ErrVersionConflict {
+		t.Errorf("second UpdateEntry error = %v, want ErrVersionConflict", err)
+	}
+	if saved, _ := c.Store().GetSingleEntry("edited"); saved.Title != "theirs" {
+		t.Errorf("saved title = %q, want %q", saved.Title, "theirs")
+	}
+}
+
+func TestSubmittingAnEntryThatIsGoneIsNotFound(t *testing.T) {
+	newTestContext("editor")
+	form := url.Values{
+		"title":         {"Gone"},
+		"slug":          {"gone"},
+		"original_slug": {"gone"},
+		"content":       {"It was deleted while I was editing it."},
+		"version":       {"3"},
+	}
+	w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("submitting a deleted entry gave %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, err := contextFor(testRequest("GET", "/", nil, "")).Store().GetSingleEntry("gone"); err != ErrNoSuchEntity {
+		t.Errorf("GetSingleEntry(gone) error = %v, want ErrNoSuchEntity", err)
+	}
+}
+
+func TestRestoringARevisionBumpsTheVersion(t *testing.T) {
+	c := newTestContext("editor")
+	original := testEntry("restored", 1)
+	putEntries(t, c, original)
+	loaded, _ := c.Store().GetSingleEntry("restored")
+	edited := loaded
+	edited.Content = []byte("<p>rewritten</p>")
+	if err := c.Store().UpdateEntry(&edited, loaded.Version); err != nil {
+		t.Fatalf("UpdateEntry: %v", err)
+	}
+	if err := saveEntryRevision(c, &edited, &loaded); err != nil {
+		t.Fatalf("saveEntryRevision: %v", err)
+	}
+	revisions, _ := GetRevisions(c, "restored")
+	oldest := revisions[len(revisions)-1]
+
+	form := url.Values{"id": {strconv.FormatInt(oldest.ID, 10)}}
+	w := serve(adminRestoreRevisionHandler, "POST", "/admin/restore_revision", form, "editor")
+	if w.Code != http.StatusFound {
+		t.Fatalf("restoring a revision gave %d, want %d", w.Code, http.StatusFound)
+	}
+	saved, _ := c.Store().GetSingleEntry("restored")
+	if string(saved.Content) != string(original.Content) {
+		t.Errorf("restored content = %q, want %q", saved.Content, original.Content)
+	}
+	if saved.Version != edited.Version+1 {
+		t.Errorf("Version after restoring = %d, want %d", saved.Version, edited.Version+1)
+	}
+}
+
+// formVersion returns the version an edit form was rendered with.
+func formVersion(t *testing.T, body string) string {
+	t.Helper()
+	match := regexp.MustCompile(`name="version" value="(\d+)"`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("no version in the form: %s", body)
+	}
+	return match[1]
+}
+
+func TestAProblemDoesntHideAConflict(t *testing.T) {
+	c := newTestContext("editor")
+	putEntries(t, c, testEntry("edited", 1))
+	loaded, _ := c.Store().GetSingleEntry("edited")
+	theirs := loaded
+	theirs.Title = "theirs"
+	if err := c.Store().UpdateEntry(&theirs, loaded.Version); err != nil {
+		t.Fatalf("UpdateEntry: %v", err)
+	}
+
+	form := url.Values{
+		"title":         {"ours"},
+		"slug":          {"admin"},
+		"original_slug": {"edited"},
+		"content":       {"Ours."},
+		"status":        {STATUS_PUBLISHED},
+		"version":       {strconv.FormatInt(loaded.Version, 10)},
+	}
+	w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("renaming to admin gave %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if got := formVersion(t, w.Body.String()); got != form.Get("version") {
+		t.Errorf("the form came back with version %s, want the one it was loaded with, %s", got, form.Get("version"))
+	}
+
+	form.Set("slug", "edited")
+	form.Set("version", formVersion(t, w.Body.String()))
+	if w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor"); w.Code != http.StatusConflict {
+		t.Errorf("saving the fixed form gave %d, want %d", w.Code, http.StatusConflict)
+	}
+	if saved, _ := c.Store().GetSingleEntry("edited"); saved.Title != "theirs" {
+		t.Errorf("saved title = %q, want %q", saved.Title, "theirs")
+	}
+}
+
+func TestRenamingOntoATakenSlugCanBeSavedAgain(t *testing.T) {
+	c := newTestContext("editor")
+	putEntries(t, c, testEntry("mine", 1), testEntry("taken", 1))
+	loaded, _ := c.Store().GetSingleEntry("mine")
+
+	form := url.Values{
+		"title":         {"mine"},
+		"slug":          {"taken"},
+		"original_slug": {"mine"},
+		"content":       {"Mine."},
+		"status":        {STATUS_PUBLISHED},
+		"version":       {strconv.FormatInt(loaded.Version, 10)},
+	}
+	w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("renaming onto taken gave %d, want %d", w.Code, http.StatusConflict)
+	}
+	form.Set("slug", "taken-2")
+	form.Set("version", formVersion(t, w.Body.String()))
+	if w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor"); w.Code != http.StatusFound {
+		t.Errorf("saving under the suggested slug gave %d, want %d: %s", w.Code, http.StatusFound, w.Body)
+	}
+	if _, err := c.Store().GetSingleEntry("taken-2"); err != nil {
+		t.Errorf("GetSingleEntry(taken-2): %v", err)
+	}
+}
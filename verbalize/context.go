@@ -0,0 +1,161 @@
+// Per-request environment, so that handlers are not tied to a single runtime.
+package blog
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCacheMiss is returned by a Cache when a key is not present.
+	ErrCacheMiss = errors.New("blog: cache miss")
+	// ErrNotStored is returned by Cache.Add when a key is already present.
+	ErrNotStored = errors.New("blog: item not stored")
+)
+
+// Context is everything a handler needs from the environment it is serving in.
+type Context interface {
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+
+	// Store returns where entries and links are persisted.
+	Store() Store
+	// Blobs returns where uploaded files are kept.
+	Blobs() BlobStore
+	// Cache returns the cache used for rendered pages and external content.
+	Cache() Cache
+	// Client returns an HTTP client for fetching external pages.
+	Client() *http.Client
+	// CurrentUser returns the logged in user, or "" if there is none.
+	CurrentUser() string
+	// VersionID identifies the running version of the application.
+	VersionID() string
+}
+
+// Cache stores byte values by key, in the style of memcache.
+type Cache interface {
+	Get(key string) ([]byte, error)
+	// Add stores a value unless the key is already present. A ttl of 0 never expires.
+	Add(key string, value []byte, ttl time.Duration) error
+	// Set stores a value whether or not the key is already present.
+	Set(key string, value []byte, ttl time.Duration) error
+	Flush() error
+}
+
+// MemoryCache is a Cache kept in process memory.
+type MemoryCache struct {
+	mu    sync.Mutex
+	items map[string]memoryCacheItem
+}
+
+type memoryCacheItem struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{items: make(map[string]memoryCacheItem)}
+}
+
+// Get returns the value stored for key, or ErrCacheMiss.
+func (m *MemoryCache) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	if !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(m.items, key)
+		return nil, ErrCacheMiss
+	}
+	return item.value, nil
+}
+
+// Add stores value for key, unless a live value is already present.
+func (m *MemoryCache) Add(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item, ok := m.items[key]; ok && (item.expires.IsZero() || time.Now().Before(item.expires)) {
+		return ErrNotStored
+	}
+	item := memoryCacheItem{value: value}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	m.items[key] = item
+	return nil
+}
+
+// Set stores value for key, replacing any value already present.
+func (m *MemoryCache) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item := memoryCacheItem{value: value}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	m.items[key] = item
+	return nil
+}
+
+// Flush removes everything from the cache.
+func (m *MemoryCache) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items = make(map[string]memoryCacheItem)
+	return nil
+}
+
+// Local holds the services shared by every request of a standalone server.
+type Local struct {
+	Store  Store
+	Blobs  BlobStore
+	Cache  Cache
+	Client *http.Client
+}
+
+// NewContext returns a Context for a request served outside of App Engine.
+func (l *Local) NewContext(r *http.Request) Context {
+	return &localContext{local: l, r: r}
+}
+
+type localContext struct {
+	local *Local
+	r     *http.Request
+}
+
+func (c *localContext) Infof(format string, args ...interface{}) {
+	log.Printf("INFO: "+format, args...)
+}
+
+func (c *localContext) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func (c *localContext) Store() Store     { return c.local.Store }
+func (c *localContext) Blobs() BlobStore { return c.local.Blobs }
+func (c *localContext) Cache() Cache     { return c.local.Cache }
+
+func (c *localContext) Client() *http.Client {
+	if c.local.Client == nil {
+		return http.DefaultClient
+	}
+	return c.local.Client
+}
+
+// CurrentUser returns the name the request authenticated with, if any.
+func (c *localContext) CurrentUser() string {
+	name, _, _ := c.r.BasicAuth()
+	return name
+}
+
+func (c *localContext) VersionID() string { return VERSION }
@@ -0,0 +1,699 @@
+//go:build appengine
+// +build appengine
+
+// Store implementation backed by the App Engine datastore.
+package blog
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"log"
+	"time"
+)
+
+// Largest blob, or chunk of a larger blob, kept in a datastore entity,
+// leaving room under the 1MB limit for its key.
+const MAX_DATASTORE_BLOB_SIZE = 1000 * 1000
+
+// DatastoreStore is a Store that persists to the App Engine datastore.
+type DatastoreStore struct {
+	c appengine.Context
+}
+
+// NewDatastoreStore returns a DatastoreStore for a request context.
+func NewDatastoreStore(c appengine.Context) *DatastoreStore {
+	return &DatastoreStore{c: c}
+}
+
+/* return a fetching key for a given entry */
+func (s *SavedEntry) Key(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Entries", s.Slug, 0, nil)
+}
+
+/* return a fetching key for a given entry */
+func (sl *SavedLink) Key(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Links", sl.URL, 0, nil)
+}
+
+/* return a fetching key for a given revision */
+func (rev *SavedRevision) Key(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Revisions", "", rev.ID, nil)
+}
+
+/* return a fetching key for a given draft */
+func draftKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, "Drafts", id, 0, nil)
+}
+
+/* return a fetching key for a given trashed entry */
+func trashedEntryKey(c appengine.Context, slug string) *datastore.Key {
+	return datastore.NewKey(c, "TrashedEntries", slug, 0, nil)
+}
+
+/* return a fetching key for a given trashed link */
+func trashedLinkKey(c appengine.Context, url string) *datastore.Key {
+	return datastore.NewKey(c, "TrashedLinks", url, 0, nil)
+}
+
+/* return a fetching key for the redirect left behind by an old slug */
+func redirectKey(c appengine.Context, oldSlug string) *datastore.Key {
+	return datastore.NewKey(c, "Redirects", oldSlug, 0, nil)
+}
+
+/* return a fetching key for a given author */
+func authorKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, "Authors", id, 0, nil)
+}
+
+/* return a fetching key for a given search document */
+func searchDocumentKey(c appengine.Context, slug string) *datastore.Key {
+	return datastore.NewKey(c, "SearchIndex", slug, 0, nil)
+}
+
+/* return a fetching key for the record of a migration */
+func migrationKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, "Migrations", id, 0, nil)
+}
+
+/* return a fetching key for the record of an uploaded file */
+func mediaFileKey(c appengine.Context, id string) *datastore.Key {
+	return datastore.NewKey(c, "MediaFiles", id, 0, nil)
+}
+
+/* return a fetching key for a given blob */
+func blobKey(c appengine.Context, name string) *datastore.Key {
+	return datastore.NewKey(c, "Blobs", name, 0, nil)
+}
+
+// ignoreFieldMismatch drops the error datastore returns when an entity still
+// has a property that its struct no longer does, as entries saved before a
+// field was removed will until a migration saves them again.
+func ignoreFieldMismatch(err error) error {
+	if _, ok := err.(*datastore.ErrFieldMismatch); ok {
+		return nil
+	}
+	return err
+}
+
+// entryQuery builds the datastore query for an EntryQuery
+func entryQuery(params EntryQuery) (*datastore.Query, error) {
+	q := datastore.NewQuery("Entries").Order(
+		"-PublishDate")
+
+	// Datastore can't leave out slugs, so when some are excluded the entries
+	// skipped by Offset are too, and enough are fetched to leave them out.
+	excluding := len(params.ExcludeSlugs) > 0
+	if params.Count > 0 && excluding {
+		q = q.Limit(params.Offset + params.Count + len(params.ExcludeSlugs))
+	} else if params.Count > 0 {
+		q = q.Limit(params.Count)
+	}
+	if params.IsPage == false {
+		q = q.Filter("IsPage =", false)
+	}
+	if params.IsPage == true {
+		q = q.Filter("IsPage =", true)
+	}
+	if params.Start.IsZero() == false {
+		q = q.Filter("PublishDate >=", params.Start)
+	}
+	if end := params.end(); end.IsZero() == false {
+		q = q.Filter("PublishDate <", end)
+	}
+	if params.ScheduledOnly {
+		q = q.Filter("IsScheduled =", true)
+	}
+	if params.IncludeHidden == false {
+		q = q.Filter("IsHidden = ", false)
+	}
+	if params.Tag != "" {
+		q = q.Filter("Tags =", params.Tag)
+	}
+	if params.Status != "" {
+		q = q.Filter("Status =", params.Status)
+	}
+	if params.Author != "" {
+		q = q.Filter("Author =", params.Author)
+	}
+	if params.Series != "" {
+		q = q.Filter("Series =", params.Series)
+	}
+	if params.PinnedOnly {
+		q = q.Filter("IsPinned =", true)
+	}
+	if params.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Start(cursor)
+	}
+	if params.Offset > 0 && !excluding {
+		q = q.Offset(params.Offset)
+	}
+	log.Printf("Query: %v", q)
+	return q, nil
+}
+
+// GetEntries retrieves all or some blog entries from datastore
+func (d *DatastoreStore) GetEntries(params EntryQuery) (entries []SavedEntry, cursor string, err error) {
+	q, err := entryQuery(params)
+	if err != nil {
+		return nil, "", err
+	}
+	excluded := make(map[string]bool)
+	skip := 0
+	for _, slug := range params.ExcludeSlugs {
+		excluded[slug] = true
+		skip = params.Offset
+	}
+	t := q.Run(d.c)
+	for params.Count == 0 || len(entries) < params.Count {
+		var e SavedEntry
+		_, err := t.Next(&e)
+		err = ignoreFieldMismatch(err)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return entries, "", err
+		}
+		if excluded[e.Slug] {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if params.Count == 0 || len(entries) < params.Count {
+		return entries, "", nil
+	}
+
+	end, err := t.Cursor()
+	if err != nil {
+		return entries, "", err
+	}
+	// Only hand out a cursor if there is something after it. A keys only
+	// query is much cheaper than fetching one more entry to find out.
+	next := params
+	next.Cursor = end.String()
+	next.Offset = 0
+	next.Count = 1
+	q, err = entryQuery(next)
+	if err != nil {
+		return entries, "", err
+	}
+	keys, err := q.KeysOnly().GetAll(d.c, nil)
+	if err != nil {
+		return entries, "", err
+	}
+	for _, key := range keys {
+		if !excluded[key.StringID()] {
+			return entries, next.Cursor, nil
+		}
+	}
+	return entries, "", nil
+}
+
+// GetPublishDates retrieves the PublishDate of entries with a projection query
+func (d *DatastoreStore) GetPublishDates(params EntryQuery) (dates []time.Time, err error) {
+	var entries []SavedEntry
+	q, err := entryQuery(params)
+	if err != nil {
+		return nil, err
+	}
+	_, err = q.Project("PublishDate").GetAll(d.c, &entries)
+	for _, e := range entries {
+		dates = append(dates, e.PublishDate)
+	}
+	return dates, err
+}
+
+// GetSingleEntry retrieves a single blog entry by slug from datastore
+func (d *DatastoreStore) GetSingleEntry(slug string) (e SavedEntry, err error) {
+	e.Slug = slug
+	err = ignoreFieldMismatch(datastore.Get(d.c, e.Key(d.c), &e))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutEntry saves a blog entry to datastore, keyed by slug
+func (d *DatastoreStore) PutEntry(e *SavedEntry) error {
+	_, err := datastore.Put(d.c, e.Key(d.c), e)
+	return err
+}
+
+// UpdateEntry saves a blog entry to datastore, in a transaction so that a save
+// of an older version can't replace a newer one
+func (d *DatastoreStore) UpdateEntry(e *SavedEntry, version int64) error {
+	err := datastore.RunInTransaction(d.c, func(tc appengine.Context) error {
+		var current SavedEntry
+		err := ignoreFieldMismatch(datastore.Get(tc, e.Key(tc), &current))
+		if err == nil && current.Version != version {
+			return ErrVersionConflict
+		} else if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		saved := *e
+		saved.Version = version + 1
+		_, err = datastore.Put(tc, e.Key(tc), &saved)
+		return err
+	}, nil)
+	if err == nil {
+		e.Version = version + 1
+	}
+	return err
+}
+
+// CreateEntry saves a new blog entry to datastore, in a transaction so that
+// two saves can't both claim the same slug
+func (d *DatastoreStore) CreateEntry(e *SavedEntry) error {
+	return datastore.RunInTransaction(d.c, func(tc appengine.Context) error {
+		var existing SavedEntry
+		err := ignoreFieldMismatch(datastore.Get(tc, e.Key(tc), &existing))
+		if err == nil {
+			return ErrEntityExists
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = datastore.Put(tc, e.Key(tc), e)
+		return err
+	}, nil)
+}
+
+// DeleteEntry removes a blog entry from datastore, or returns ErrNoSuchEntity
+// if there is none. Datastore deletes missing entities without complaint, so
+// it is looked for first, in a transaction
+func (d *DatastoreStore) DeleteEntry(slug string) error {
+	e := SavedEntry{Slug: slug}
+	return datastore.RunInTransaction(d.c, func(tc appengine.Context) error {
+		var existing SavedEntry
+		err := ignoreFieldMismatch(datastore.Get(tc, e.Key(tc), &existing))
+		if err == datastore.ErrNoSuchEntity {
+			return ErrNoSuchEntity
+		} else if err != nil {
+			return err
+		}
+		return datastore.Delete(tc, e.Key(tc))
+	}, nil)
+}
+
+// GetLinks retrieves all links in order from datastore
+func (d *DatastoreStore) GetLinks() (links []SavedLink, err error) {
+	q := datastore.NewQuery("Links").Order("Order").Order("Title")
+	_, err = q.GetAll(d.c, &links)
+	return links, err
+}
+
+// PutLink saves a link to datastore, keyed by URL
+func (d *DatastoreStore) PutLink(l *SavedLink) error {
+	_, err := datastore.Put(d.c, l.Key(d.c), l)
+	return err
+}
+
+// DeleteLink removes a link from datastore
+func (d *DatastoreStore) DeleteLink(url string) error {
+	l := SavedLink{URL: url}
+	return datastore.Delete(d.c, l.Key(d.c))
+}
+
+// GetRevisions retrieves the revisions of an entry from datastore, newest first
+func (d *DatastoreStore) GetRevisions(slug string) (revisions []SavedRevision, err error) {
+	q := datastore.NewQuery("Revisions").Filter("Slug =", slug).Order("-Date")
+	_, err = q.GetAll(d.c, &revisions)
+	return revisions, err
+}
+
+// GetRevision retrieves a single revision by ID from datastore
+func (d *DatastoreStore) GetRevision(id int64) (rev SavedRevision, err error) {
+	rev.ID = id
+	err = datastore.Get(d.c, rev.Key(d.c), &rev)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutRevision saves a revision to datastore, keyed by ID. Revisions without
+// one are given an ID allocated by datastore, which is also stored in the
+// entity so that queries return it.
+func (d *DatastoreStore) PutRevision(rev *SavedRevision) error {
+	if rev.ID == 0 {
+		low, _, err := datastore.AllocateIDs(d.c, "Revisions", nil, 1)
+		if err != nil {
+			return err
+		}
+		rev.ID = low
+	}
+	_, err := datastore.Put(d.c, rev.Key(d.c), rev)
+	return err
+}
+
+// DeleteRevisions removes every revision of an entry from datastore
+func (d *DatastoreStore) DeleteRevisions(slug string) error {
+	keys, err := datastore.NewQuery("Revisions").Filter("Slug =", slug).KeysOnly().GetAll(d.c, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(d.c, keys)
+}
+
+// GetDraft retrieves a draft from datastore
+func (d *DatastoreStore) GetDraft(id string) (draft SavedDraft, err error) {
+	err = datastore.Get(d.c, draftKey(d.c, id), &draft)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutDraft saves a draft to datastore, keyed by user and entry
+func (d *DatastoreStore) PutDraft(draft *SavedDraft) error {
+	_, err := datastore.Put(d.c, draftKey(d.c, draft.ID()), draft)
+	return err
+}
+
+// DeleteDraft removes a draft from datastore
+func (d *DatastoreStore) DeleteDraft(id string) error {
+	err := datastore.Delete(d.c, draftKey(d.c, id))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return err
+}
+
+// GetTrashedEntries retrieves trashed entries from datastore, most recently deleted first
+func (d *DatastoreStore) GetTrashedEntries() (trashed []TrashedEntry, err error) {
+	q := datastore.NewQuery("TrashedEntries").Order("-DeletedDate")
+	_, err = q.GetAll(d.c, &trashed)
+	return trashed, ignoreFieldMismatch(err)
+}
+
+// GetTrashedEntry retrieves a trashed entry by slug from datastore
+func (d *DatastoreStore) GetTrashedEntry(slug string) (t TrashedEntry, err error) {
+	err = ignoreFieldMismatch(datastore.Get(d.c, trashedEntryKey(d.c, slug), &t))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutTrashedEntry saves a trashed entry to datastore, keyed by slug
+func (d *DatastoreStore) PutTrashedEntry(t *TrashedEntry) error {
+	_, err := datastore.Put(d.c, trashedEntryKey(d.c, t.Slug), t)
+	return err
+}
+
+// PurgeTrashedEntry permanently removes a trashed entry from datastore
+func (d *DatastoreStore) PurgeTrashedEntry(slug string) error {
+	return datastore.Delete(d.c, trashedEntryKey(d.c, slug))
+}
+
+// GetTrashedLinks retrieves trashed links from datastore, most recently deleted first
+func (d *DatastoreStore) GetTrashedLinks() (trashed []TrashedLink, err error) {
+	q := datastore.NewQuery("TrashedLinks").Order("-DeletedDate")
+	_, err = q.GetAll(d.c, &trashed)
+	return trashed, err
+}
+
+// GetTrashedLink retrieves a trashed link by URL from datastore
+func (d *DatastoreStore) GetTrashedLink(url string) (t TrashedLink, err error) {
+	err = datastore.Get(d.c, trashedLinkKey(d.c, url), &t)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutTrashedLink saves a trashed link to datastore, keyed by URL
+func (d *DatastoreStore) PutTrashedLink(t *TrashedLink) error {
+	_, err := datastore.Put(d.c, trashedLinkKey(d.c, t.URL), t)
+	return err
+}
+
+// PurgeTrashedLink permanently removes a trashed link from datastore
+func (d *DatastoreStore) PurgeTrashedLink(url string) error {
+	return datastore.Delete(d.c, trashedLinkKey(d.c, url))
+}
+
+// FindSearchDocuments retrieves the search documents containing term from datastore
+func (d *DatastoreStore) FindSearchDocuments(term string) (docs []SearchDocument, err error) {
+	q := datastore.NewQuery("SearchIndex").Filter("Terms =", term)
+	_, err = q.GetAll(d.c, &docs)
+	return docs, err
+}
+
+// GetSearchDocument retrieves the search document of an entry from datastore
+func (d *DatastoreStore) GetSearchDocument(slug string) (doc SearchDocument, err error) {
+	err = datastore.Get(d.c, searchDocumentKey(d.c, slug), &doc)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// CountSearchDocuments counts the documents in the search index with a keys only query
+func (d *DatastoreStore) CountSearchDocuments() (int, error) {
+	return datastore.NewQuery("SearchIndex").KeysOnly().Count(d.c)
+}
+
+// PutSearchDocument saves a search document to datastore, keyed by slug
+func (d *DatastoreStore) PutSearchDocument(doc *SearchDocument) error {
+	_, err := datastore.Put(d.c, searchDocumentKey(d.c, doc.Slug), doc)
+	return err
+}
+
+// DeleteSearchDocument removes a search document from datastore
+func (d *DatastoreStore) DeleteSearchDocument(slug string) error {
+	err := datastore.Delete(d.c, searchDocumentKey(d.c, slug))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return err
+}
+
+// DeleteSearchDocuments removes the whole search index from datastore
+func (d *DatastoreStore) DeleteSearchDocuments() error {
+	keys, err := datastore.NewQuery("SearchIndex").KeysOnly().GetAll(d.c, nil)
+	if err != nil {
+		return err
+	}
+	return datastore.DeleteMulti(d.c, keys)
+}
+
+// GetRedirects retrieves every redirect from datastore, by old slug
+func (d *DatastoreStore) GetRedirects() (redirects []SavedRedirect, err error) {
+	_, err = datastore.NewQuery("Redirects").Order("OldSlug").GetAll(d.c, &redirects)
+	return
+}
+
+// GetRedirect retrieves the redirect left behind by an old slug from datastore
+func (d *DatastoreStore) GetRedirect(oldSlug string) (r SavedRedirect, err error) {
+	err = datastore.Get(d.c, redirectKey(d.c, oldSlug), &r)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutRedirect saves a redirect to datastore, keyed by old slug
+func (d *DatastoreStore) PutRedirect(r *SavedRedirect) error {
+	_, err := datastore.Put(d.c, redirectKey(d.c, r.OldSlug), r)
+	return err
+}
+
+// DeleteRedirect removes a redirect from datastore
+func (d *DatastoreStore) DeleteRedirect(oldSlug string) error {
+	err := datastore.Delete(d.c, redirectKey(d.c, oldSlug))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return err
+}
+
+// GetAuthors retrieves every author from datastore, by ID
+func (d *DatastoreStore) GetAuthors() (authors []SavedAuthor, err error) {
+	_, err = datastore.NewQuery("Authors").Order("ID").GetAll(d.c, &authors)
+	return
+}
+
+// GetAuthor retrieves an author from datastore by ID
+func (d *DatastoreStore) GetAuthor(id string) (a SavedAuthor, err error) {
+	err = datastore.Get(d.c, authorKey(d.c, id), &a)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// GetAuthorByUser retrieves the profile of a user from datastore
+func (d *DatastoreStore) GetAuthorByUser(user string) (a SavedAuthor, err error) {
+	var authors []SavedAuthor
+	if _, err = datastore.NewQuery("Authors").Filter("User =", user).Limit(1).GetAll(d.c, &authors); err != nil {
+		return
+	}
+	if len(authors) == 0 {
+		return a, ErrNoSuchEntity
+	}
+	return authors[0], nil
+}
+
+// PutAuthor saves an author to datastore, keyed by ID
+func (d *DatastoreStore) PutAuthor(a *SavedAuthor) error {
+	_, err := datastore.Put(d.c, authorKey(d.c, a.ID), a)
+	return err
+}
+
+// DeleteAuthor removes an author from datastore
+func (d *DatastoreStore) DeleteAuthor(id string) error {
+	err := datastore.Delete(d.c, authorKey(d.c, id))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return err
+}
+
+// GetMigrations retrieves the records of every migration that has started from datastore
+func (d *DatastoreStore) GetMigrations() (migrations []SavedMigration, err error) {
+	_, err = datastore.NewQuery("Migrations").GetAll(d.c, &migrations)
+	return
+}
+
+// PutMigration saves the record of a migration to datastore, keyed by ID
+func (d *DatastoreStore) PutMigration(m *SavedMigration) error {
+	_, err := datastore.Put(d.c, migrationKey(d.c, m.ID), m)
+	return err
+}
+
+// GetMediaFiles retrieves every uploaded file from datastore, newest first
+func (d *DatastoreStore) GetMediaFiles() (files []SavedMediaFile, err error) {
+	_, err = datastore.NewQuery("MediaFiles").Order("-Uploaded").GetAll(d.c, &files)
+	return
+}
+
+// GetMediaFile retrieves the record of an uploaded file from datastore by ID
+func (d *DatastoreStore) GetMediaFile(id string) (f SavedMediaFile, err error) {
+	err = datastore.Get(d.c, mediaFileKey(d.c, id), &f)
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return
+}
+
+// PutMediaFile saves the record of an uploaded file to datastore, keyed by ID
+func (d *DatastoreStore) PutMediaFile(f *SavedMediaFile) error {
+	_, err := datastore.Put(d.c, mediaFileKey(d.c, f.ID), f)
+	return err
+}
+
+// DeleteMediaFile removes the record of an uploaded file from datastore
+func (d *DatastoreStore) DeleteMediaFile(id string) error {
+	err := datastore.Delete(d.c, mediaFileKey(d.c, id))
+	if err == datastore.ErrNoSuchEntity {
+		err = ErrNoSuchEntity
+	}
+	return err
+}
+
+// DatastoreBlobStore is a BlobStore that keeps each blob as datastore
+// entities, as App Engine applications can't write to disk. Entities are
+// limited to a megabyte, so larger blobs are split into chunks kept as
+// children of the blob's own entity.
+type DatastoreBlobStore struct {
+	c appengine.Context
+}
+
+// NewDatastoreBlobStore returns a DatastoreBlobStore for a request context.
+func NewDatastoreBlobStore(c appengine.Context) *DatastoreBlobStore {
+	return &DatastoreBlobStore{c: c}
+}
+
+// savedBlob is how a blob is stored in datastore: its data, or how many
+// chunks its data was split into.
+type savedBlob struct {
+	Data   []byte `datastore:",noindex"`
+	Chunks int    `datastore:",noindex"`
+}
+
+// chunkKeys returns the keys of the chunks of a blob.
+func chunkKeys(c appengine.Context, key *datastore.Key, from int, to int) (keys []*datastore.Key) {
+	for i := from; i < to; i++ {
+		keys = append(keys, datastore.NewKey(c, "BlobChunks", "", int64(i+1), key))
+	}
+	return keys
+}
+
+// GetBlob retrieves a blob from datastore, putting its chunks back together
+func (d *DatastoreBlobStore) GetBlob(name string) ([]byte, error) {
+	var b savedBlob
+	key := blobKey(d.c, name)
+	err := datastore.Get(d.c, key, &b)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrNoSuchEntity
+	} else if err != nil || b.Chunks == 0 {
+		return b.Data, err
+	}
+	chunks := make([]savedBlob, b.Chunks)
+	if err := datastore.GetMulti(d.c, chunkKeys(d.c, key, 0, b.Chunks), chunks); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, chunk := range chunks {
+		data = append(data, chunk.Data...)
+	}
+	return data, nil
+}
+
+// PutBlob saves a blob to datastore, keyed by name, in chunks if it is too
+// large for one entity. The chunks are written first, so that a blob is never
+// seen with some of them missing.
+func (d *DatastoreBlobStore) PutBlob(name string, data []byte) error {
+	key := blobKey(d.c, name)
+	var previous savedBlob
+	if err := datastore.Get(d.c, key, &previous); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	b := savedBlob{Data: data}
+	if len(data) > MAX_DATASTORE_BLOB_SIZE {
+		b = savedBlob{Chunks: (len(data) + MAX_DATASTORE_BLOB_SIZE - 1) / MAX_DATASTORE_BLOB_SIZE}
+		for i, chunk_key := range chunkKeys(d.c, key, 0, b.Chunks) {
+			end := (i + 1) * MAX_DATASTORE_BLOB_SIZE
+			if end > len(data) {
+				end = len(data)
+			}
+			// One at a time, as a call may only carry so much.
+			if _, err := datastore.Put(d.c, chunk_key, &savedBlob{Data: data[i*MAX_DATASTORE_BLOB_SIZE : end]}); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := datastore.Put(d.c, key, &b); err != nil {
+		return err
+	}
+	if previous.Chunks > b.Chunks {
+		return datastore.DeleteMulti(d.c, chunkKeys(d.c, key, b.Chunks, previous.Chunks))
+	}
+	return nil
+}
+
+// DeleteBlob removes a blob and its chunks from datastore
+func (d *DatastoreBlobStore) DeleteBlob(name string) error {
+	var b savedBlob
+	key := blobKey(d.c, name)
+	if err := datastore.Get(d.c, key, &b); err == datastore.ErrNoSuchEntity {
+		return ErrNoSuchEntity
+	} else if err != nil {
+		return err
+	}
+	if err := datastore.Delete(d.c, key); err != nil {
+		return err
+	}
+	if b.Chunks > 0 {
+		return datastore.DeleteMulti(d.c, chunkKeys(d.c, key, 0, b.Chunks))
+	}
+	return nil
+}
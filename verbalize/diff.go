@@ -0,0 +1,134 @@
+// Line and word diffs between two texts, used to compare entry revisions.
+package blog
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// Past this many edits, a diff gives up and reports a complete rewrite.
+	maxDiffEdits = 2000
+)
+
+var (
+	// a run of whitespace, or a run of anything else.
+	word_re = regexp.MustCompile(`\s+|\S+`)
+)
+
+// DiffOp is one run of a diff: text that is in both versions ("equal"),
+// only in the newer one ("insert") or only in the older one ("delete").
+type DiffOp struct {
+	Op   string
+	Text string
+}
+
+// splitLines splits text into lines, keeping their line endings.
+func splitLines(text string) []string {
+	return strings.SplitAfter(text, "\n")
+}
+
+// splitWords splits text into words and the whitespace between them.
+func splitWords(text string) []string {
+	return word_re.FindAllString(text, -1)
+}
+
+// Diff compares two texts a line at a time, or a word at a time if byWord is set.
+func Diff(a, b string, byWord bool) []DiffOp {
+	if byWord {
+		return diffTokens(splitWords(a), splitWords(b))
+	}
+	return diffTokens(splitLines(a), splitLines(b))
+}
+
+// diffTokens finds the shortest edit script between a and b (Myers, 1986).
+func diffTokens(a, b []string) []DiffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[max+k] is the furthest x reached on diagonal k. trace[d] keeps the
+	// diagonals -d..d as they stood after d edits, so we can walk back.
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && d <= maxDiffEdits && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k-1] < v[max+k+1]) {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+	}
+	if !found {
+		return mergeOps([]DiffOp{
+			{Op: "delete", Text: strings.Join(a, "")},
+			{Op: "insert", Text: strings.Join(b, "")},
+		})
+	}
+
+	var ops []DiffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] // diagonal k is at prev[k+d-1]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, DiffOp{Op: "equal", Text: a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, DiffOp{Op: "insert", Text: b[y-1]})
+			y--
+		} else {
+			ops = append(ops, DiffOp{Op: "delete", Text: a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, DiffOp{Op: "equal", Text: a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return mergeOps(ops)
+}
+
+// mergeOps joins neighbouring runs of the same kind, and drops empty ones.
+func mergeOps(ops []DiffOp) (merged []DiffOp) {
+	for _, op := range ops {
+		if op.Text == "" {
+			continue
+		}
+		if len(merged) > 0 && merged[len(merged)-1].Op == op.Op {
+			merged[len(merged)-1].Text += op.Text
+			continue
+		}
+		merged = append(merged, op)
+	}
+	return merged
+}
@@ -0,0 +1,90 @@
+package blog
+
+import (
+	"strings"
+	"testing"
+)
+
+// applyDiff returns the older and newer texts a diff was made from.
+func applyDiff(ops []DiffOp) (a string, b string) {
+	for _, op := range ops {
+		if op.Op != "insert" {
+			a += op.Text
+		}
+		if op.Op != "delete" {
+			b += op.Text
+		}
+	}
+	return a, b
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		byWord bool
+		want   []DiffOp
+	}{
+		{"", "", false, nil},
+		{"same\n", "same\n", false, []DiffOp{{"equal", "same\n"}}},
+		{"", "new\n", false, []DiffOp{{"insert", "new\n"}}},
+		{"old\n", "", false, []DiffOp{{"delete", "old\n"}}},
+		{"a\nb\nc\n", "a\nx\nc\n", false, []DiffOp{{"equal", "a\n"}, {"delete", "b\n"}, {"insert", "x\n"}, {"equal", "c\n"}}},
+		{"a\nc\n", "a\nb\nc\n", false, []DiffOp{{"equal", "a\n"}, {"insert", "b\n"}, {"equal", "c\n"}}},
+		{"the quick fox", "the slow fox", true, []DiffOp{{"equal", "the "}, {"delete", "quick"}, {"insert", "slow"}, {"equal", " fox"}}},
+		{"one two", "one two three", true, []DiffOp{{"equal", "one two"}, {"insert", " three"}}},
+	}
+	for _, tt := range tests {
+		got := Diff(tt.a, tt.b, tt.byWord)
+		if len(got) != len(tt.want) {
+			t.Errorf("Diff(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Diff(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDiffIsMinimal(t *testing.T) {
+	// One line changed in a hundred is one delete and one insert.
+	var a, b []string
+	for i := 0; i < 100; i++ {
+		line := strings.Repeat("x", i) + "\n"
+		a = append(a, line)
+		if i == 50 {
+			line = "changed\n"
+		}
+		b = append(b, line)
+	}
+	ops := Diff(strings.Join(a, ""), strings.Join(b, ""), false)
+	changes := 0
+	for _, op := range ops {
+		if op.Op != "equal" {
+			changes++
+		}
+	}
+	if changes != 2 {
+		t.Errorf("got %d changed runs, want 2: %v", changes, ops)
+	}
+}
+
+func TestDiffRebuildsBothTexts(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"a\nb\nc\nd\n", "b\nc\ne\nd\nf\n"},
+		{"no newline", "no newline at all"},
+		{"x\ny\n", "y\nx\n"},
+		// Past maxDiffEdits the diff is a complete rewrite, which still rebuilds both.
+		{strings.Repeat("a\n", maxDiffEdits+10), strings.Repeat("b\n", maxDiffEdits+10)},
+	}
+	for _, tt := range tests {
+		for _, byWord := range []bool{false, true} {
+			a, b := applyDiff(Diff(tt.a, tt.b, byWord))
+			if a != tt.a || b != tt.b {
+				t.Errorf("Diff(%.20q, %.20q, %v) rebuilds %.20q and %.20q", tt.a, tt.b, byWord, a, b)
+			}
+		}
+	}
+}
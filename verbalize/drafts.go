@@ -0,0 +1,109 @@
+// Server side autosave of entries that are still being edited.
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Draft struct, stored in Datastore. There is at most one draft per user and
+// entry, and it is kept apart from the SavedEntry until the entry is saved.
+type SavedDraft struct {
+	User string
+	// Slug of the entry being edited, or "" for one that has not been saved yet.
+	Entry   string
+	IsPage  bool
+	Title   string
+	Slug    string
+	Content []byte
+	Date    time.Time
+}
+
+// draftID returns the key a user's draft of an entry is stored under.
+func draftID(user string, entry string, isPage bool) string {
+	if entry == "" {
+		if isPage {
+			return user + "/new page"
+		}
+		return user + "/new post"
+	}
+	return user + "/" + entry
+}
+
+// ID returns the key this draft is stored under.
+func (d *SavedDraft) ID() string {
+	return draftID(d.User, d.Entry, d.IsPage)
+}
+
+// GetDraft retrieves a user's draft of an entry
+func GetDraft(c Context, user string, entry string, isPage bool) (SavedDraft, error) {
+	return c.Store().GetDraft(draftID(user, entry, isPage))
+}
+
+// PutDraft saves a draft to the store
+func PutDraft(c Context, d *SavedDraft) error {
+	return c.Store().PutDraft(d)
+}
+
+// DeleteDraft removes a user's draft of an entry from the store
+func DeleteDraft(c Context, user string, entry string, isPage bool) error {
+	err := c.Store().DeleteDraft(draftID(user, entry, isPage))
+	if err == ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// getRecoverableDraft returns the current user's draft of an entry, if it is
+// newer than the last time the entry was saved.
+func getRecoverableDraft(c Context, entry *SavedEntry) *SavedDraft {
+	draft, err := GetDraft(c, c.CurrentUser(), entry.Slug, entry.IsPage)
+	if err != nil {
+		return nil
+	}
+	if entry.Slug != "" {
+		if revs, err := GetRevisions(c, entry.Slug); err == nil && len(revs) > 0 && !draft.Date.After(revs[0].Date) {
+			return nil
+		}
+	}
+	return &draft
+}
+
+// handler for /admin/autosave - stores the entry being edited as a draft
+func adminAutosaveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Autosave requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	is_page, _ := strconv.ParseBool(r.FormValue("is_page"))
+	original_slug := strings.TrimSpace(r.FormValue("original_slug"))
+
+	if r.FormValue("discard") == "1" {
+		if err := DeleteDraft(c, c.CurrentUser(), original_slug, is_page); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "Discarded draft")
+		return
+	}
+
+	draft := SavedDraft{
+		User:    c.CurrentUser(),
+		Entry:   original_slug,
+		IsPage:  is_page,
+		Title:   strings.TrimSpace(r.FormValue("title")),
+		Slug:    strings.TrimSpace(r.FormValue("slug")),
+		Content: []byte(r.FormValue("content")),
+		Date:    time.Now(),
+	}
+	if err := PutDraft(c, &draft); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Autosaved draft %s", draft.ID())
+	fmt.Fprintf(w, "Draft saved at %s", draft.Date.Format("15:04:05"))
+}
@@ -0,0 +1,86 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// autosave autosaves form as user's draft, failing the test if it can't.
+func autosave(t *testing.T, user string, form url.Values) {
+	t.Helper()
+	if w := serve(adminAutosaveHandler, "POST", "/admin/autosave", form, user); w.Code != http.StatusOK {
+		t.Fatalf("autosaving gave %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestNewerDraftsAreOfferedForRecovery(t *testing.T) {
+	c := newTestContext("editor")
+	form := url.Values{
+		"title":       {"Saved"},
+		"slug":        {"post"},
+		"content":     {"As saved."},
+		"status":      {STATUS_PUBLISHED},
+		"is_new_post": {"1"},
+	}
+	entry := submitEntry(t, c, "editor", form)
+	if draft := getRecoverableDraft(c, &entry); draft != nil {
+		t.Errorf("a draft %+v is offered before anything was autosaved", draft)
+	}
+
+	autosave(t, "editor", url.Values{"original_slug": {"post"}, "title": {"Unsaved"}, "slug": {"post"}, "content": {"Still typing"}})
+	draft := getRecoverableDraft(c, &entry)
+	if draft == nil || draft.Title != "Unsaved" || string(draft.Content) != "Still typing" {
+		t.Fatalf("getRecoverableDraft = %+v, want the autosaved draft", draft)
+	}
+	// Only to the user who wrote it.
+	if other := getRecoverableDraft(newTestContext("someone"), &entry); other != nil {
+		t.Errorf("someone else is offered the draft %+v", other)
+	}
+
+	// A draft from before the entry was last saved has nothing to recover.
+	revisions, _ := GetRevisions(c, "post")
+	older := *draft
+	older.Date = revisions[0].Date.Add(-time.Minute)
+	if err := PutDraft(c, &older); err != nil {
+		t.Fatalf("PutDraft: %v", err)
+	}
+	if draft := getRecoverableDraft(c, &entry); draft != nil {
+		t.Errorf("an older draft %+v is offered for recovery", draft)
+	}
+}
+
+func TestSavingAnEntryRemovesItsDraft(t *testing.T) {
+	c := newTestContext("editor")
+	autosave(t, "editor", url.Values{"title": {"New"}, "slug": {"new"}, "content": {"Started"}})
+	if _, err := GetDraft(c, "editor", "", false); err != nil {
+		t.Fatalf("GetDraft of a new post: %v", err)
+	}
+	form := url.Values{
+		"title":       {"New"},
+		"slug":        {"new"},
+		"content":     {"Finished."},
+		"status":      {STATUS_PUBLISHED},
+		"is_new_post": {"1"},
+	}
+	entry := submitEntry(t, c, "editor", form)
+	if _, err := GetDraft(c, "editor", "", false); err != ErrNoSuchEntity {
+		t.Errorf("GetDraft after saving a new post error = %v, want ErrNoSuchEntity", err)
+	}
+
+	autosave(t, "editor", url.Values{"original_slug": {"new"}, "title": {"New"}, "slug": {"new"}, "content": {"Edited"}})
+	form.Del("is_new_post")
+	form.Set("original_slug", "new")
+	form.Set("version", "1")
+	submitEntry(t, c, "editor", form)
+	if draft := getRecoverableDraft(c, &entry); draft != nil {
+		t.Errorf("getRecoverableDraft after saving = %+v, want none", draft)
+	}
+
+	autosave(t, "editor", url.Values{"original_slug": {"new"}, "content": {"Again"}})
+	autosave(t, "editor", url.Values{"original_slug": {"new"}, "discard": {"1"}})
+	if _, err := GetDraft(c, "editor", "new", false); err != ErrNoSuchEntity {
+		t.Errorf("GetDraft after discarding error = %v, want ErrNoSuchEntity", err)
+	}
+}
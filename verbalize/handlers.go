@@ -1,35 +1,66 @@
 package blog
 
 import (
-	"appengine"
-	"appengine/datastore"
-	"appengine/memcache"
-	"appengine/user"
 	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Setup the URL handlers at initialization
-func init() {
+// contextFor returns the Context for a request, as set by RegisterHandlers.
+var contextFor func(r *http.Request) Context
+
+// RegisterHandlers sets up the URL handlers on mux, using newContext to
+// create the Context each request is served with.
+func RegisterHandlers(mux *http.ServeMux, newContext func(r *http.Request) Context) {
+	contextFor = newContext
+
 	/* ServeMux does not understand regular expressions :( */
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/feed/", feedHandler)
-
-	http.HandleFunc("/admin", adminHomeHandler)
-	http.HandleFunc("/admin/home", adminHomeHandler)
-	http.HandleFunc("/admin/pages", adminPagesHandler)
-	http.HandleFunc("/admin/edit", adminEditEntryHandler)
-	http.HandleFunc("/admin/submit_entry", adminSubmitEntryHandler)
-	http.HandleFunc("/admin/links", adminLinksHandler)
-	http.HandleFunc("/admin/submit_links", adminSubmitLinksHandler)
-	http.HandleFunc("/admin/comments", adminCommentsHandler)
+	mux.HandleFunc("/", rootHandler)
+	mux.HandleFunc("/feed/", feedHandler)
+	mux.HandleFunc("/sitemap.xml", sitemapHandler)
+	mux.HandleFunc("/search", searchHandler)
+	mux.HandleFunc("/media/", mediaHandler)
+	mux.HandleFunc("/cron/publish", cronPublishHandler)
+	mux.HandleFunc("/cron/purge_trash", cronPurgeTrashHandler)
+	mux.HandleFunc("/_ah/warmup", warmupHandler)
+
+	mux.HandleFunc("/admin", requireRole(ROLE_CONTRIBUTOR, adminHomeHandler))
+	mux.HandleFunc("/admin/home", requireRole(ROLE_CONTRIBUTOR, adminHomeHandler))
+	mux.HandleFunc("/admin/pages", requireRole(ROLE_CONTRIBUTOR, adminPagesHandler))
+	mux.HandleFunc("/admin/edit", requireRole(ROLE_CONTRIBUTOR, adminEditEntryHandler))
+	mux.HandleFunc("/admin/submit_entry", requireRole(ROLE_CONTRIBUTOR, adminSubmitEntryHandler))
+	mux.HandleFunc("/admin/autosave", requireRole(ROLE_CONTRIBUTOR, adminAutosaveHandler))
+	mux.HandleFunc("/admin/delete_entry", requireRole(ROLE_CONTRIBUTOR, adminDeleteEntryHandler))
+	mux.HandleFunc("/admin/revisions", requireRole(ROLE_CONTRIBUTOR, adminRevisionsHandler))
+	mux.HandleFunc("/admin/diff", requireRole(ROLE_CONTRIBUTOR, adminDiffHandler))
+	mux.HandleFunc("/admin/restore_revision", requireRole(ROLE_CONTRIBUTOR, adminRestoreRevisionHandler))
+	mux.HandleFunc("/admin/profile", requireRole(ROLE_CONTRIBUTOR, adminProfileHandler))
+	mux.HandleFunc("/admin/media", requireRole(ROLE_CONTRIBUTOR, adminMediaHandler))
+	mux.HandleFunc("/admin/upload_media", requireRole(ROLE_CONTRIBUTOR, adminUploadMediaHandler))
+	mux.HandleFunc("/admin/delete_media", requireRole(ROLE_CONTRIBUTOR, adminDeleteMediaHandler))
+	mux.HandleFunc("/admin/links", requireRole(ROLE_EDITOR, adminLinksHandler))
+	mux.HandleFunc("/admin/submit_links", requireRole(ROLE_EDITOR, adminSubmitLinksHandler))
+	mux.HandleFunc("/admin/delete_link", requireRole(ROLE_EDITOR, adminDeleteLinkHandler))
+	mux.HandleFunc("/admin/trash", requireRole(ROLE_EDITOR, adminTrashHandler))
+	mux.HandleFunc("/admin/submit_trash", requireRole(ROLE_EDITOR, adminSubmitTrashHandler))
+	mux.HandleFunc("/admin/comments", requireRole(ROLE_EDITOR, adminCommentsHandler))
+	mux.HandleFunc("/admin/rebuild_search", requireRole(ROLE_EDITOR, adminRebuildSearchHandler))
+	mux.HandleFunc("/admin/authors", requireRole(ROLE_ADMIN, adminAuthorsHandler))
+	mux.HandleFunc("/admin/submit_author", requireRole(ROLE_ADMIN, adminSubmitAuthorHandler))
+	mux.HandleFunc("/admin/delete_author", requireRole(ROLE_ADMIN, adminDeleteAuthorHandler))
+	mux.HandleFunc("/admin/migrations", requireRole(ROLE_ADMIN, adminMigrationsHandler))
+	mux.HandleFunc("/admin/run_migrations", requireRole(ROLE_ADMIN, adminRunMigrationsHandler))
+	mux.HandleFunc("/admin/import", requireRole(ROLE_ADMIN, adminImportHandler))
+	mux.HandleFunc("/admin/backup", requireRole(ROLE_ADMIN, adminBackupHandler))
+	mux.HandleFunc("/admin/export", requireRole(ROLE_ADMIN, adminExportHandler))
+	mux.HandleFunc("/admin/restore", requireRole(ROLE_ADMIN, adminRestoreHandler))
 
 }
 
@@ -37,16 +68,20 @@ func init() {
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-control", config.Require("cache_control_header"))
 
-	c := appengine.NewContext(r)
-	key := r.URL.Path + "@" + appengine.VersionID(c)
+	c := contextFor(r)
+	key := pageCacheKey(c, r)
 
-	if item, err := memcache.Get(c, key); err == memcache.ErrCacheMiss {
+	if key == "" {
+		c.Infof("Page %s isn't cached, as its cursor isn't the one it was linked with", r.URL)
+	} else if value, err := c.Cache().Get(key); err == ErrCacheMiss {
 		c.Infof("Page %s not in the cache", key)
 	} else if err != nil {
 		c.Errorf("error getting page: %v", err)
 	} else {
 		c.Infof("Page %s found in the cache", key)
-		w.Write(item.Value)
+		if !notModified(w, r, setCachedLastModified(c, w, key)) {
+			w.Write(value)
+		}
 		return
 	}
 
@@ -56,45 +91,91 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	previousURL := ""
 
 	var entries []SavedEntry
+	var archive_index []ArchiveYear
+	var author *SavedAuthor
+	var related []EntryLink
+	pinned_count := 0
 	links, _ := GetLinks(c)
 	path := r.URL.Path
 
+	date_match := date_archive_re.FindStringSubmatch(r.URL.Path)
 	pageCount, _ := strconv.Atoi(filepath.Base(r.URL.Path))
-	c.Infof("Page count: %d for %s", pageCount, r.URL.Path)
-	if pageCount > 1 {
+	if date_match != nil {
+		// Date archives are paged as /2014/05/page/2, so that pages can't be mistaken for months.
+		pageCount, _ = strconv.Atoi(date_match[3])
+	} else if pageCount > 1 {
 		path = filepath.Dir(path)
-		if pageCount > 2 {
-			previousURL = fmt.Sprintf("%s%d", path, pageCount-1)
-		} else {
-			// Don't link to /1, just link to the base path.
-			previousURL = path
-		}
-	} else {
+	}
+	if pageCount < 1 {
 		pageCount = 1
 	}
-
-	if path == "/" {
+	c.Infof("Page count: %d for %s", pageCount, r.URL.Path)
+	pathURL := func(page int) string { return pageURL(path, page) }
+	cursor := r.FormValue("cursor")
+
+	if date_match != nil {
+		query, archive_title, err := dateArchive(date_match[1], date_match[2])
+		if err == nil {
+			title = archive_title
+			template = *archiveTpl
+			entries, previousURL, nextURL = getArchivePage(c, query, pageCount, cursor, func(page int) string {
+				return dateArchiveURL(date_match[1], date_match[2], page)
+			})
+		}
+		if len(entries) == 0 {
+			http.Error(w, "I looked for entries from then, but there were none.", http.StatusNotFound)
+			return
+		}
+	} else if path == "/" {
 		title = config.Require("subtitle")
 		template = *archiveTpl
-		entries_per_page, _ := config.GetInt("entries_per_page")
-		offset := int(entries_per_page) * (pageCount - 1)
-		c.Infof("Page %d - Entries Per page: %d - Offset: %d", pageCount, entries_per_page, offset)
-		query := EntryQuery{
-			IsPage: false,
-			// We ask for 1 more than required so that we know if there are more links to show.
-			Count:  int(entries_per_page) + 1,
-			Offset: offset,
+		entries, previousURL, nextURL, pinned_count = getFrontPage(c, pageCount, cursor, pathURL)
+	} else if strings.HasPrefix(path, "/tag/") {
+		tag := normalizeTag(strings.TrimPrefix(path, "/tag/"))
+		title = fmt.Sprintf("Posts tagged %s", tag)
+		template = *archiveTpl
+		entries, previousURL, nextURL = getArchivePage(c, EntryQuery{IsPage: false, Tag: tag}, pageCount, cursor, pathURL)
+		if len(entries) == 0 {
+			http.Error(w, "I looked for entries with that tag, but there were none.", http.StatusNotFound)
+			return
 		}
-		entries, _ = GetEntries(c, query)
-
-		if len(entries) > int(entries_per_page) {
-			nextURL = fmt.Sprintf("%s%d", path, pageCount+1)
-			entries = entries[:entries_per_page]
+	} else if strings.HasPrefix(path, "/author/") {
+		profile, err := GetAuthor(c, strings.TrimPrefix(path, "/author/"))
+		if err != nil {
+			http.Error(w, "I looked for that author, but they were not there.", http.StatusNotFound)
+			return
 		}
-
+		author = &profile
+		title = fmt.Sprintf("Posts by %s", profile.Name())
+		template = *authorTpl
+		entries, previousURL, nextURL = getArchivePage(c, EntryQuery{IsPage: false, Author: profile.User}, pageCount, cursor, pathURL)
+	} else if strings.HasPrefix(path, "/series/") {
+		series := normalizeTag(strings.TrimPrefix(path, "/series/"))
+		title = series
+		template = *seriesTpl
+		entries, _ = GetSeries(c, series)
+		if len(entries) == 0 {
+			http.Error(w, "I looked for entries in that series, but there were none.", http.StatusNotFound)
+			return
+		}
+	} else if path == "/archive" {
+		title = "Archive"
+		template = *archiveIndexTpl
+		archive_index, _ = getArchiveIndex(c)
 	} else {
 		entry, err := GetSingleEntry(c, filepath.Base(r.URL.Path))
+		if err == nil && (entry.IsHidden || !entry.IsPublished()) {
+			err = ErrNoSuchEntity
+		}
 		if err != nil {
+			if moved, redirect_err := resolveRedirect(c, filepath.Base(r.URL.Path)); redirect_err == nil && !moved.IsHidden && moved.IsPublished() {
+				http.Redirect(w, r, config.Require("subdirectory")+moved.RelativeURL, http.StatusMovedPermanently)
+				return
+			}
+			if _, trash_err := c.Store().GetTrashedEntry(filepath.Base(r.URL.Path)); trash_err == nil {
+				http.Error(w, "This entry has been deleted.", http.StatusGone)
+				return
+			}
 			http.Error(w, "I looked for an entry, but it was not there.", http.StatusNotFound)
 			return
 		} else {
@@ -104,12 +185,23 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 				template = *pageTpl
 			} else {
 				template = *entryTpl
+				if related, err = RelatedEntries(c, entry.Slug); err != nil {
+					c.Errorf("Unable to get posts related to %s: %v", entry.Slug, err)
+				}
 			}
 		}
 	}
 	context, _ := GetTemplateContext(entries, links, title, "root", r)
 	context.PreviousURL = previousURL
 	context.NextURL = nextURL
+	context.Archive = archive_index
+	context.Author = author
+	if related != nil && len(context.Entries) == 1 {
+		context.Entries[0].Related = related
+	}
+	for i := 0; i < pinned_count; i++ {
+		context.Entries[i].ShownPinned = true
+	}
 
 	var contentBuffer bytes.Buffer
 	renderTemplate(&contentBuffer, template, context)
@@ -117,65 +209,314 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		c.Errorf("Error reading content from buffer: %v", err)
 	}
-	w.Write(content)
+	if key == "" {
+		w.Write(content)
+		return
+	}
+	generated := setLastModified(c, w, key)
 	page_ttl, _ := config.GetInt("page_cache_ttl")
 	storeInCache(c, key, content, int(page_ttl))
+	if !notModified(w, r, generated) {
+		w.Write(content)
+	}
+}
+
+// pageURL returns the URL of a numbered page of the archive at path. Page 1 is
+// path itself, as we don't link to /1.
+func pageURL(path string, page int) string {
+	if page <= 1 {
+		return path
+	}
+	return fmt.Sprintf("%s/%d", strings.TrimSuffix(path, "/"), page)
+}
+
+// cursorURL returns a page URL that carries the cursor the page starts at.
+func cursorURL(pageURL string, cursor string) string {
+	return pageURL + "?cursor=" + url.QueryEscape(cursor)
+}
+
+// pageCacheKey returns the cache key for the page a request is for, or "" if
+// it isn't cached. A page reached by the cursor it was linked with is the page
+// reached without one, which starts at the cursor remembered for it. Pages
+// reached by any other cursor aren't cached, so that made up cursors can't
+// fill the cache with copies of them.
+func pageCacheKey(c Context, r *http.Request) string {
+	key := r.URL.Path + "@" + c.VersionID()
+	cursor := r.FormValue("cursor")
+	if cursor == "" {
+		return key
+	}
+	if linked, err := c.Cache().Get(cursorCacheKey(c, r.URL.Path)); err == nil && string(linked) == cursor {
+		return key
+	}
+	return ""
+}
+
+// archiveCursor returns where the next page of an archive starts: the
+// latest PublishDate the archive is fetched up to, and the store's cursor.
+func archiveCursor(end time.Time, cursor string) string {
+	return fmt.Sprintf("%d.%s", end.UnixNano(), cursor)
+}
+
+// parseArchiveCursor splits a cursor made by archiveCursor.
+func parseArchiveCursor(value string) (end time.Time, cursor string, err error) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return end, "", fmt.Errorf("not an archive cursor: %q", value)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return end, "", err
+	}
+	return time.Unix(0, nanos), parts[1], nil
+}
+
+// cursorCacheKey returns the cache key for the cursor a page starts at.
+func cursorCacheKey(c Context, pageURL string) string {
+	return "cursor:" + pageURL + "@" + c.VersionID()
+}
+
+// lastModifiedCacheKey returns the cache key for when the page cached under key last changed.
+func lastModifiedCacheKey(key string) string {
+	return "modified:" + key
+}
+
+// setLastModified sends the time a page is being generated as when it last
+// changed, remembers it for when the page is served from the cache under key,
+// and returns it. The entries on a page can't tell when it changed, as removing
+// one or changing the links or theme leaves them older, but every such change
+// flushes the cache or changes its key, so the page is regenerated. The page
+// is only regenerated once the last one has gone from the cache, so the time
+// remembered for it replaces any already there.
+func setLastModified(c Context, w http.ResponseWriter, key string) time.Time {
+	generated := time.Now()
+	value := generated.UTC().Format(http.TimeFormat)
+	w.Header().Set("Last-Modified", value)
+	if err := c.Cache().Set(lastModifiedCacheKey(key), []byte(value), 0); err != nil {
+		c.Errorf("error setting %s in cache: %v", lastModifiedCacheKey(key), err)
+	}
+	return generated
+}
+
+// setCachedLastModified sends when a page served from the cache under key last
+// changed, and returns it, or the zero time if it isn't known.
+func setCachedLastModified(c Context, w http.ResponseWriter, key string) time.Time {
+	value, err := c.Cache().Get(lastModifiedCacheKey(key))
+	if err != nil {
+		return time.Time{}
+	}
+	w.Header().Set("Last-Modified", string(value))
+	modified, _ := http.ParseTime(string(value))
+	return modified
+}
+
+// notModified answers a GET or HEAD request with 304 Not Modified if the page
+// hasn't changed since its If-Modified-Since time, and reports whether it did.
+// Last-Modified only has whole seconds, so the page's time is compared in them.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.Truncate(time.Second).After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// getArchivePage fetches a page of entries matching query, along with the URLs
+// of the pages before and after it, if there are any. Pages start at the cursor
+// carried in their URL, or failing that the one remembered in the cache, so
+// that only pages reached some other way fall back to a costly offset.
+func getArchivePage(c Context, query EntryQuery, pageCount int, cursor string, urlFor func(page int) string) (entries []SavedEntry, previousURL string, nextURL string) {
+	return getArchivePageAfter(c, query, 0, pageCount, cursor, urlFor)
+}
+
+// getArchivePageAfter is getArchivePage for an archive whose first page is led
+// by entries of its own, which take the places of as many of the archive's.
+// At least one of the archive's entries is still shown on the first page.
+func getArchivePageAfter(c Context, query EntryQuery, lead int, pageCount int, cursor string, urlFor func(page int) string) (entries []SavedEntry, previousURL string, nextURL string) {
+	entries_per_page, _ := config.GetInt("entries_per_page")
+	per_page := int(entries_per_page)
+	if lead >= per_page {
+		lead = per_page - 1
+	}
+	if lead < 0 {
+		lead = 0
+	}
+	query.Count = per_page
+	if pageCount == 1 {
+		query.Count = per_page - lead
+	}
+	if cursor == "" && pageCount > 1 {
+		if value, err := c.Cache().Get(cursorCacheKey(c, urlFor(pageCount))); err == nil {
+			cursor = string(value)
+		}
+	}
+
+	var next string
+	var err error
+	if cursor != "" {
+		end := query.End
+		if query.End, query.Cursor, err = parseArchiveCursor(cursor); err == nil {
+			entries, next, err = GetEntries(c, query)
+		}
+		if err != nil {
+			c.Errorf("Unable to continue from cursor %s: %v", cursor, err)
+			query.End, query.Cursor = end, ""
+		}
+	}
+	if query.Cursor == "" {
+		// A cursor only carries on the query it came from, so the pages after
+		// this one are fetched up to the same time as it is.
+		query.End = query.end()
+		if pageCount > 1 {
+			query.Offset = per_page*(pageCount-1) - lead
+		}
+		c.Infof("Page %d - Entries Per page: %d - Offset: %d", pageCount, per_page, query.Offset)
+		entries, next, _ = GetEntries(c, query)
+	}
+
+	if pageCount > 1 {
+		previousURL = urlFor(pageCount - 1)
+	}
+	if next != "" {
+		next = archiveCursor(query.End, next)
+		nextURL = urlFor(pageCount + 1)
+		// Cursors are remembered until the next edit flushes the cache.
+		storeInCache(c, cursorCacheKey(c, nextURL), []byte(next), 0)
+		nextURL = cursorURL(nextURL, next)
+	}
+	return entries, previousURL, nextURL
 }
 
 // HTTP handler for /feed
 func feedHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-control", config.Require("cache_control_header"))
 
-	c := appengine.NewContext(r)
-	key := r.URL.Path + "@" + appengine.VersionID(c)
+	c := contextFor(r)
+	key := r.URL.Path + "@" + c.VersionID()
 
-	if item, err := memcache.Get(c, key); err == memcache.ErrCacheMiss {
+	if value, err := c.Cache().Get(key); err == ErrCacheMiss {
 		c.Infof("Page %s not in the cache", key)
 	} else if err != nil {
 		c.Errorf("error getting page: %v", err)
 	} else {
 		c.Infof("Page %s found in the cache", key)
-		w.Write(item.Value)
+		if !notModified(w, r, setCachedLastModified(c, w, key)) {
+			w.Write(value)
+		}
 		return
 	}
 
 	entry_count, _ := config.GetInt("entries_per_page")
-	entries, _ := GetEntries(c, EntryQuery{IsPage: false, Count: int(entry_count)})
+	entries, _, _ := GetEntries(c, EntryQuery{IsPage: false, Count: int(entry_count)})
 	links := make([]SavedLink, 0)
 
 	context, _ := GetTemplateContext(entries, links, "Atom Feed", "feed", r)
+	// The feed changes when its newest entry does, not whenever it is fetched.
+	modified := lastModified(entries)
+	if !modified.IsZero() {
+		context.PageTimeRfc3339 = modified.In(location).Format(time.RFC3339)
+	}
 	var contentBuffer bytes.Buffer
 	feedTpl.ExecuteTemplate(&contentBuffer, "feed.html", context)
 	content, _ := ioutil.ReadAll(&contentBuffer)
 
-	w.Write(content)
+	generated := setLastModified(c, w, key)
 	// Feeds get cached infinitely, until an edit flushes it.
 	storeInCache(c, key, content, 0)
+	if !notModified(w, r, generated) {
+		w.Write(content)
+	}
+}
+
+// HTTP handler for /sitemap.xml - every published post and page, and when it last changed
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-control", config.Require("cache_control_header"))
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+
+	c := contextFor(r)
+	key := r.URL.Path + "@" + c.VersionID()
+
+	if value, err := c.Cache().Get(key); err == ErrCacheMiss {
+		c.Infof("Page %s not in the cache", key)
+	} else if err != nil {
+		c.Errorf("error getting page: %v", err)
+	} else {
+		c.Infof("Page %s found in the cache", key)
+		if !notModified(w, r, setCachedLastModified(c, w, key)) {
+			w.Write(value)
+		}
+		return
+	}
+
+	entries, _, err := GetEntries(c, EntryQuery{IsPage: false})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pages, _, err := GetEntries(c, EntryQuery{IsPage: true})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	entries = append(entries, pages...)
+
+	context, _ := GetTemplateContext(entries, nil, "Sitemap", "sitemap", r)
+	// The front page changes when the newest entry does.
+	modified := lastModified(entries)
+	context.PageTimeRfc3339 = ""
+	if !modified.IsZero() {
+		context.PageTimeRfc3339 = modified.In(location).Format(time.RFC3339)
+	}
+	var contentBuffer bytes.Buffer
+	sitemapTpl.ExecuteTemplate(&contentBuffer, "sitemap.html", context)
+	content, _ := ioutil.ReadAll(&contentBuffer)
+
+	generated := setLastModified(c, w, key)
+	// Like feeds, sitemaps are cached until an edit flushes them.
+	storeInCache(c, key, content, 0)
+	if !notModified(w, r, generated) {
+		w.Write(content)
+	}
 }
 
 // HTTP handler for /admin
 func adminHomeHandler(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	entries, _ := GetEntries(c, EntryQuery{IncludeHidden: true, IsPage: false})
-	context, _ := GetTemplateContext(entries, nil, "Home", "admin_home", r)
+	context := getAdminListContext(r, false, "Home", "admin_home")
 	renderTemplate(w, *adminHomeTpl, context)
 }
 
 // HTTP handler for /admin/pages
 func adminPagesHandler(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	entries, _ := GetEntries(c, EntryQuery{IncludeHidden: true, IsPage: true})
-	context, _ := GetTemplateContext(entries, nil, "Pages", "admin_pages", r)
+	context := getAdminListContext(r, true, "Pages", "admin_pages")
 	renderTemplate(w, *adminPagesTpl, context)
 }
 
+// getAdminListContext lists the posts or pages in the workflow status given by
+// the "status" parameter, or all of them if there is none.
+func getAdminListContext(r *http.Request, is_page bool, title string, pageId string) GlobalTemplateContext {
+	c := contextFor(r)
+	status := r.FormValue("status")
+	entries, _, _ := GetEntries(c, EntryQuery{IncludeHidden: true, IncludeScheduled: true, IsPage: is_page, Status: status})
+	context, _ := GetTemplateContext(entries, nil, title, pageId, r)
+	context.StatusFilter = status
+	context.Statuses = statuses
+	if status == "" {
+		context.UnmigratedCount = countUnmigrated(entries)
+	}
+	return context
+}
+
 // HTTP handler for /edit
 func adminEditEntryHandler(w http.ResponseWriter, r *http.Request) {
 	slug := strings.TrimSpace(r.FormValue("slug"))
 	is_page := strings.TrimSpace(r.FormValue("is_page"))
 	log.Printf("Edit: %s (%s)", slug, is_page)
 
-	c := appengine.NewContext(r)
+	c := contextFor(r)
 
 	// just defaults.
 	title := "New"
@@ -183,15 +524,22 @@ func adminEditEntryHandler(w http.ResponseWriter, r *http.Request) {
 
 	if slug != "" {
 		entry, err := GetSingleEntry(c, slug)
-		log.Printf("GetSingleEntry: %s / %s", entry, err)
+		log.Printf("GetSingleEntry: %v / %v", entry, err)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !mayTouch(c, &entry) {
+			http.Error(w, "Contributors can only edit their own entries.", http.StatusForbidden)
+			return
+		}
 		entries = append(entries, entry)
 		title = entry.Title
 	} else {
-		entry := SavedEntry{}
+		entry := SavedEntry{Status: STATUS_DRAFT}
+		if isPublisher(c) {
+			entry.Status = STATUS_PUBLISHED
+		}
 		if is_page != "" {
 			entry.IsPage = true
 			entry.AllowComments = false
@@ -204,6 +552,20 @@ func adminEditEntryHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Entries: %v", entries)
 	context, _ := GetTemplateContext(entries, nil, title, "admin_edit", r)
+	context.OriginalSlug = slug
+	context.CanPublish = isPublisher(c)
+	context.Draft = getRecoverableDraft(c, &entries[0])
+	renderTemplate(w, *adminEditTpl, context)
+}
+
+// renderEditProblem shows the edit form again with what was submitted, and why
+// it could not be saved.
+func renderEditProblem(w http.ResponseWriter, r *http.Request, status int, entry *SavedEntry, original_slug string, problem string) {
+	context, _ := GetTemplateContext([]SavedEntry{*entry}, nil, entry.Title, "admin_edit", r)
+	context.OriginalSlug = original_slug
+	context.CanPublish = isPublisher(contextFor(r))
+	context.Problem = problem
+	w.WriteHeader(status)
 	renderTemplate(w, *adminEditTpl, context)
 }
 
@@ -213,6 +575,7 @@ func adminSubmitEntryHandler(w http.ResponseWriter, r *http.Request) {
 	title := strings.TrimSpace(r.FormValue("title"))
 	slug := strings.TrimSpace(r.FormValue("slug"))
 	entry := SavedEntry{}
+	var previous *SavedEntry
 
 	if len(content) == 0 {
 		http.Error(w, "No content", http.StatusInternalServerError)
@@ -223,20 +586,55 @@ func adminSubmitEntryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c := appengine.NewContext(r)
+	c := contextFor(r)
+	is_new := r.FormValue("is_new_post") == "1"
+	original_slug := strings.TrimSpace(r.FormValue("original_slug"))
+	version, _ := strconv.ParseInt(r.FormValue("version"), 10, 64)
 
-	if r.FormValue("is_new_post") == "1" {
-		if u := user.Current(c); u != nil {
-			entry.Author = u.String()
-		}
+	if is_new {
+		entry.Author = c.CurrentUser()
+		original_slug = ""
 	} else {
-		entry, _ = GetSingleEntry(c, slug)
+		if original_slug == "" {
+			original_slug = slug
+		}
+		existing, err := GetSingleEntry(c, original_slug)
+		if err == ErrNoSuchEntity {
+			http.Error(w, fmt.Sprintf("There is no entry named %q any more: it may have been deleted or renamed while you were editing it.", original_slug), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !mayTouch(c, &existing) {
+			http.Error(w, "Contributors can only edit their own entries.", http.StatusForbidden)
+			return
+		}
+		previous = &existing
+		entry = existing
+		// The form is based on the version it was loaded with, and is shown
+		// again with it if there's a problem, so that a save made meanwhile
+		// is still noticed when the form is saved again.
+		entry.Version = version
 	}
-
-	if r.FormValue("hidden") == "on" {
-		entry.IsHidden = true
-	} else {
-		entry.IsHidden = false
+	renamed := previous != nil && previous.Slug != slug
+
+	status := r.FormValue("status")
+	if status == "" {
+		// The edit form had a "hidden" checkbox before it had a status.
+		status = STATUS_PUBLISHED
+		if r.FormValue("hidden") == "on" {
+			status = STATUS_DRAFT
+		}
+	}
+	if StatusLabel(status) == "" {
+		http.Error(w, fmt.Sprintf("Unknown status: %q", status), http.StatusBadRequest)
+		return
+	}
+	format := r.FormValue("format")
+	if format != "" && format != FORMAT_HTML && format != FORMAT_MARKDOWN {
+		http.Error(w, fmt.Sprintf("Unknown format: %q", format), http.StatusBadRequest)
+		return
 	}
 	if r.FormValue("allow_comments") == "on" {
 		entry.AllowComments = true
@@ -245,26 +643,122 @@ func adminSubmitEntryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	entry.IsPage, _ = strconv.ParseBool(r.FormValue("is_page"))
 	entry.Content = []byte(content)
+	if format != "" {
+		entry.Format = format
+	}
 	entry.Title = title
 	entry.Slug = slug
-	log.Printf("Comments: %s (real=%s)", entry.AllowComments, r.FormValue("allow_comments"))
-	if entry.PublishDate.IsZero() {
-		entry.PublishDate = time.Now()
+	entry.Tags = parseTags(r.FormValue("tags"))
+	entry.IsPinned = r.FormValue("is_pinned") == "on" && !entry.IsPage
+	pinned_until, err := parsePublishDate(strings.TrimSpace(r.FormValue("pinned_until")))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid pinned until date: %v", err), http.StatusBadRequest)
+		return
+	}
+	entry.PinnedUntil = time.Time{}
+	if entry.IsPinned {
+		entry.PinnedUntil = pinned_until
+	}
+	entry.Metadata = parseMetadataForm(r.Form["metadata_key"], r.Form["metadata_value"])
+	entry.Series = normalizeTag(r.FormValue("series"))
+	entry.SeriesOrder, _ = strconv.Atoi(r.FormValue("series_order"))
+	if entry.Series == "" || entry.IsPage {
+		entry.Series, entry.SeriesOrder = "", 0
+	} else if entry.SeriesOrder < 1 || (previous != nil && previous.Series != entry.Series && entry.SeriesOrder == previous.SeriesOrder) {
+		// Entries added to a series without a place go at the end of it.
+		next, err := nextSeriesOrder(c, entry.Series)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entry.SeriesOrder = next
+	}
+	log.Printf("Comments: %t (real=%s)", entry.AllowComments, r.FormValue("allow_comments"))
+	publish_date, err := parsePublishDate(strings.TrimSpace(r.FormValue("publish_date")))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid publish date: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !publish_date.IsZero() {
+		entry.PublishDate = publish_date
+	}
+	entry.setStatus(status)
+	// Readers first see an entry when it is published, so it has only been
+	// updated if it was already published before this save.
+	if previous != nil && previous.CurrentStatus() == STATUS_PUBLISHED {
+		entry.UpdatedDate = time.Now()
+	} else {
+		entry.UpdatedDate = entry.PublishDate
+	}
+	if problem := validateMetadata(entry.Metadata); problem != "" {
+		renderEditProblem(w, r, http.StatusBadRequest, &entry, original_slug, problem)
+		return
+	}
+	from := ""
+	if previous != nil {
+		from = previous.CurrentStatus()
+	}
+	if !canChangeStatus(c, from, entry.Status) {
+		problem := "Only editors can publish, schedule or unpublish entries, or change ones that are already published."
+		renderEditProblem(w, r, http.StatusForbidden, &entry, original_slug, problem)
+		return
 	}
 
-	if entry.IsPage {
-		entry.RelativeURL = entry.Slug
+	entry.setRelativeURL()
+
+	// New slugs are checked, and claimed in a way that can't overwrite another entry.
+	if is_new || renamed {
+		if problem := validateSlug(slug); problem != "" {
+			renderEditProblem(w, r, http.StatusBadRequest, &entry, original_slug, problem)
+			return
+		}
+		if renamed && previous.Version != version {
+			renderEditConflict(w, r, &entry, previous, original_slug)
+			return
+		}
+		entry.Version = version + 1
+		err = CreateEntry(c, &entry)
+		if err == ErrEntityExists {
+			entry.Version = version
+			problem := fmt.Sprintf("There is already an entry named %q, so this one wasn't saved.", slug)
+			if suggestion := availableSlug(c, slug); suggestion != "" {
+				problem += fmt.Sprintf(" Save again to use %q instead.", suggestion)
+				entry.Slug = suggestion
+			}
+			renderEditProblem(w, r, http.StatusConflict, &entry, original_slug, problem)
+			return
+		}
 	} else {
-		entry.RelativeURL = fmt.Sprintf("%d/%02d/%s", entry.PublishDate.Year(),
-			entry.PublishDate.Month(), entry.Slug)
+		err = UpdateEntry(c, &entry, version)
+		if err == ErrVersionConflict {
+			if current, current_err := GetSingleEntry(c, entry.Slug); current_err == nil {
+				renderEditConflict(w, r, &entry, &current, original_slug)
+				return
+			}
+		}
 	}
-	_, err := datastore.Put(c, entry.Key(c), &entry)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if renamed {
+		if err := MoveEntry(c, previous, &entry); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := saveEntryRevision(c, &entry, previous); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	log.Printf("Saved entry: %v", entry)
-	memcache.Flush(c)
+	if err := IndexEntry(c, &entry); err != nil {
+		c.Errorf("error indexing %s: %v", entry.Slug, err)
+	}
+	if err := DeleteDraft(c, c.CurrentUser(), original_slug, entry.IsPage); err != nil {
+		c.Errorf("error deleting draft: %v", err)
+	}
+	c.Cache().Flush()
 	if entry.IsPage {
 		http.Redirect(w, r, fmt.Sprintf("/admin/pages?added=%s", slug), http.StatusFound)
 	} else {
@@ -274,7 +768,7 @@ func adminSubmitEntryHandler(w http.ResponseWriter, r *http.Request) {
 
 // handler for /admin/links
 func adminLinksHandler(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := contextFor(r)
 	links, _ := GetLinks(c)
 	context, _ := GetTemplateContext(nil, links, "Links", "admin_links", r)
 	renderTemplate(w, *adminLinksTpl, context)
@@ -282,20 +776,20 @@ func adminLinksHandler(w http.ResponseWriter, r *http.Request) {
 
 // handler for /admin/submit_links
 func adminSubmitLinksHandler(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	c := contextFor(r)
 	order, _ := strconv.Atoi(strings.TrimSpace(r.FormValue("new_order")))
 	link := SavedLink{
 		Order: int64(order),
 		Title: strings.TrimSpace(r.FormValue("new_title")),
 		URL:   strings.TrimSpace(r.FormValue("new_url")),
 	}
-	_, err := datastore.Put(c, link.Key(c), &link)
+	err := PutLink(c, &link)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	log.Printf("Saved entry: %v", link)
-	memcache.Flush(c)
+	c.Cache().Flush()
 	http.Redirect(w, r, fmt.Sprintf("/admin/links?added=%s", link.URL), http.StatusFound)
 }
 
@@ -0,0 +1,88 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveSince serves a GET request for target, made if it has changed since since.
+func serveSince(handler http.HandlerFunc, target string, since string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := testRequest("GET", target, nil, "")
+	if since != "" {
+		r.Header.Set("If-Modified-Since", since)
+	}
+	handler(w, r)
+	return w
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2014, 5, 6, 7, 8, 9, 500, time.UTC)
+	at := modified.Format(http.TimeFormat)
+	tests := []struct {
+		method   string
+		since    string
+		modified time.Time
+		want     bool
+	}{
+		{"GET", at, modified, true},
+		{"HEAD", modified.Add(time.Hour).Format(http.TimeFormat), modified, true},
+		{"GET", modified.Add(-time.Second).Format(http.TimeFormat), modified, false},
+		{"GET", "", modified, false},
+		{"GET", "yesterday", modified, false},
+		{"GET", at, time.Time{}, false},
+		{"POST", at, modified, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := testRequest(tt.method, "/", nil, "")
+		r.Header.Set("If-Modified-Since", tt.since)
+		if got := notModified(w, r, tt.modified); got != tt.want || (w.Code == http.StatusNotModified) != tt.want {
+			t.Errorf("%s since %q, modified %s: notModified = %t with %d, want %t", tt.method, tt.since, tt.modified, got, w.Code, tt.want)
+		}
+	}
+}
+
+func TestSetLastModifiedReplacesTheRememberedTime(t *testing.T) {
+	c := newTestContext("")
+	old := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+	c.Cache().Set(lastModifiedCacheKey("/@1"), []byte(old), 0)
+	generated := setLastModified(c, httptest.NewRecorder(), "/@1")
+
+	w := httptest.NewRecorder()
+	if got := setCachedLastModified(c, w, "/@1"); !got.Equal(generated.Truncate(time.Second)) {
+		t.Errorf("setCachedLastModified = %s, want %s", got, generated)
+	}
+	if got := w.Header().Get("Last-Modified"); got != generated.UTC().Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", got, generated.UTC().Format(http.TimeFormat))
+	}
+}
+
+func TestSitemapAnswersConditionalRequests(t *testing.T) {
+	c := newTestContext("")
+	newest := testEntry("newest", 1)
+	putEntries(t, c, testEntry("post", 2), newest)
+
+	w := serveSince(sitemapHandler, "/sitemap.xml", "")
+	modified := w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || modified == "" {
+		t.Fatalf("sitemap gave %d, Last-Modified %q", w.Code, modified)
+	}
+	// Served again from the cache.
+	if w := serveSince(sitemapHandler, "/sitemap.xml", modified); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("sitemap since it last changed gave %d with %d bytes, want 304", w.Code, w.Body.Len())
+	}
+
+	// Taking away the newest entry leaves the rest older than a copy made
+	// when it was there, but the sitemap has still changed since.
+	since := newest.LastModified().UTC().Format(http.TimeFormat)
+	if err := TrashEntry(c, "newest"); err != nil {
+		t.Fatalf("TrashEntry: %v", err)
+	}
+	c.Cache().Flush()
+	if w := serveSince(sitemapHandler, "/sitemap.xml", since); w.Code != http.StatusOK {
+		t.Errorf("sitemap after its newest entry was trashed gave %d, want %d", w.Code, http.StatusOK)
+	}
+}
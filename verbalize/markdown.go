@@ -0,0 +1,375 @@
+// Markdown as an alternative to HTML for entry content: a small renderer for
+// the common subset of Markdown, with HTML passed through as-is.
+package blog
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Formats entry content can be written in.
+	FORMAT_HTML     = "html"
+	FORMAT_MARKDOWN = "markdown"
+
+	// Marks where text set aside while rendering inline Markdown goes back.
+	MARKDOWN_PLACEHOLDER = "\x00"
+)
+
+var (
+	// Block level Markdown.
+	md_heading_re    = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$`)
+	md_setext_re     = regexp.MustCompile(`^ {0,3}(=+|-+)\s*$`)
+	md_rule_re       = regexp.MustCompile(`^ {0,3}(?:(?:\*\s*){3,}|(?:-\s*){3,}|(?:_\s*){3,})$`)
+	md_fence_re      = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([\\w+-]*)")
+	md_quote_re      = regexp.MustCompile(`^ {0,3}> ?`)
+	md_list_item_re  = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])(\s+|$)`)
+	md_html_block_re = regexp.MustCompile(`^ {0,3}<(?:/?[a-zA-Z][a-zA-Z0-9]*(?:\s|/?>|$)|!--)`)
+	md_comment_re    = regexp.MustCompile(`^ {0,3}<!--.*-->\s*$`)
+	md_reference_re  = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:\s*<?(\S+?)>?(?:\s+["'(](.*)["')])?\s*$`)
+
+	// Inline Markdown.
+	md_code_span_re   = regexp.MustCompile("(`+)(.+?)(`+)")
+	md_autolink_re    = regexp.MustCompile(`<((?:https?|ftp)://[^\s>]+|[^\s@<>]+@[^\s@<>]+\.[a-zA-Z]+)>`)
+	md_inline_tag_re  = regexp.MustCompile(`</?[a-zA-Z][^<>]*>|<!--.*?-->`)
+	md_escape_re      = regexp.MustCompile("\\\\([\\\\`*_{}\\[\\]()#+\\-.!<>|])")
+	md_amp_re         = regexp.MustCompile(`&(?:#[0-9]+;|#[xX][0-9a-fA-F]+;|[a-zA-Z][a-zA-Z0-9]*;)?`)
+	md_image_re       = regexp.MustCompile(`!\[([^\]]*)\]\(\s*<?([^\s)>]*)>?(?:\s+&quot;(.*?)&quot;)?\s*\)`)
+	md_link_re        = regexp.MustCompile(`\[([^\]]+)\]\(\s*<?([^\s)>]*)>?(?:\s+&quot;(.*?)&quot;)?\s*\)`)
+	md_ref_link_re    = regexp.MustCompile(`(!?)\[([^\]]+)\] ?\[([^\]]*)\]`)
+	md_strong_re      = regexp.MustCompile(`\*\*([^\s*](?:.*?[^\s])?)\*\*|\b__([^\s_](?:.*?[^\s])?)__\b`)
+	md_em_re          = regexp.MustCompile(`\*([^\s*](?:[^*]*?[^\s*])?)\*|\b_([^\s_](?:[^_]*?[^\s_])?)_\b`)
+	md_strike_re      = regexp.MustCompile(`~~([^\s~](?:.*?[^\s~])?)~~`)
+	md_break_re       = regexp.MustCompile(` {2,}\n`)
+	md_placeholder_re = regexp.MustCompile(MARKDOWN_PLACEHOLDER + `(\d+)` + MARKDOWN_PLACEHOLDER)
+)
+
+// HTML returns the content of an entry as HTML, rendering it first if it was
+// written in Markdown.
+func (s *SavedEntry) HTML() []byte {
+	if s.Format == FORMAT_MARKDOWN {
+		return []byte(Markdown(string(s.Content)))
+	}
+	return s.Content
+}
+
+// Markdown renders Markdown text as HTML.
+func Markdown(text string) string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, MARKDOWN_PLACEHOLDER, "", -1)
+	text = strings.Replace(text, "\t", "    ", -1)
+	md := &markdown{references: make(map[string]markdownReference)}
+	lines := md.collectReferences(strings.Split(text, "\n"))
+	return md.blocks(lines)
+}
+
+// A link target defined with [id]: url "title".
+type markdownReference struct {
+	url, title string
+}
+
+type markdown struct {
+	references map[string]markdownReference
+	// Text set aside while rendering a span, so that it isn't rendered again.
+	held []string
+}
+
+// collectReferences removes the reference link definitions from lines, and
+// remembers them for links that refer to them.
+func (md *markdown) collectReferences(lines []string) (rest []string) {
+	in_fence := false
+	for _, line := range lines {
+		if md_fence_re.MatchString(line) {
+			in_fence = !in_fence
+		}
+		if m := md_reference_re.FindStringSubmatch(line); m != nil && !in_fence {
+			md.references[strings.ToLower(m[1])] = markdownReference{url: m[2], title: m[3]}
+			continue
+		}
+		rest = append(rest, line)
+	}
+	return rest
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentation returns how many spaces a line starts with.
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// startsBlock returns true if a line starts a block that interrupts a paragraph.
+func startsBlock(line string) bool {
+	return md_heading_re.MatchString(line) || md_fence_re.MatchString(line) ||
+		md_rule_re.MatchString(line) || md_quote_re.MatchString(line) ||
+		md_html_block_re.MatchString(line) || md_list_item_re.MatchString(line)
+}
+
+// blocks renders lines of Markdown as a series of HTML blocks.
+func (md *markdown) blocks(lines []string) string {
+	var out []string
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+
+		case md_fence_re.MatchString(line):
+			m := md_fence_re.FindStringSubmatch(line)
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			i++
+			class := ""
+			if m[2] != "" {
+				class = fmt.Sprintf(` class="language-%s"`, m[2])
+			}
+			out = append(out, fmt.Sprintf("<pre><code%s>%s\n</code></pre>", class, html.EscapeString(strings.Join(code, "\n"))))
+
+		case indentation(line) >= 4:
+			var code []string
+			for ; i < len(lines) && (indentation(lines[i]) >= 4 || isBlank(lines[i])); i++ {
+				if isBlank(lines[i]) {
+					code = append(code, "")
+				} else {
+					code = append(code, lines[i][4:])
+				}
+			}
+			for len(code) > 0 && code[len(code)-1] == "" {
+				code = code[:len(code)-1]
+			}
+			out = append(out, fmt.Sprintf("<pre><code>%s\n</code></pre>", html.EscapeString(strings.Join(code, "\n"))))
+
+		case md_heading_re.MatchString(line):
+			m := md_heading_re.FindStringSubmatch(line)
+			out = append(out, fmt.Sprintf("<h%d>%s</h%d>", len(m[1]), md.inline(m[2]), len(m[1])))
+			i++
+
+		case md_rule_re.MatchString(line):
+			out = append(out, "<hr>")
+			i++
+
+		case md_quote_re.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				quoted = append(quoted, md_quote_re.ReplaceAllString(lines[i], ""))
+			}
+			out = append(out, "<blockquote>\n"+md.blocks(quoted)+"\n</blockquote>")
+
+		case md_list_item_re.MatchString(line):
+			var list string
+			list, i = md.list(lines, i)
+			out = append(out, list)
+
+		case md_html_block_re.MatchString(line):
+			// HTML, including the more_tag, is passed through untouched. A
+			// comment on a line of its own is a block by itself.
+			var block []string
+			for ; i < len(lines) && !isBlank(lines[i]); i++ {
+				block = append(block, lines[i])
+				if md_comment_re.MatchString(lines[i]) {
+					i++
+					break
+				}
+			}
+			out = append(out, strings.Join(block, "\n"))
+
+		default:
+			para := []string{strings.TrimLeft(line, " ")}
+			level := 0
+			for i++; i < len(lines) && !isBlank(lines[i]); i++ {
+				if m := md_setext_re.FindStringSubmatch(lines[i]); m != nil {
+					level = 2
+					if m[1][0] == '=' {
+						level = 1
+					}
+					i++
+					break
+				}
+				if startsBlock(lines[i]) {
+					break
+				}
+				para = append(para, strings.TrimLeft(lines[i], " "))
+			}
+			text := md.inline(strings.TrimRight(strings.Join(para, "\n"), " "))
+			if level > 0 {
+				out = append(out, fmt.Sprintf("<h%d>%s</h%d>", level, text, level))
+			} else {
+				out = append(out, "<p>"+text+"</p>")
+			}
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// list renders the list starting at lines[start], and returns it along with
+// the index of the first line after it.
+func (md *markdown) list(lines []string, start int) (string, int) {
+	first := md_list_item_re.FindStringSubmatch(lines[start])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	var items [][]string
+	loose := false
+	blank := false
+	i := start
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if isBlank(line) {
+			blank = true
+			if len(items) > 0 {
+				items[len(items)-1] = append(items[len(items)-1], "")
+			}
+			continue
+		}
+		m := md_list_item_re.FindStringSubmatch(line)
+		if m != nil && len(m[1]) <= len(first[1])+1 {
+			if (m[2][0] >= '0' && m[2][0] <= '9') != ordered {
+				break
+			}
+			if blank && len(items) > 0 {
+				loose = true
+			}
+			items = append(items, []string{line[len(m[0]):]})
+			blank = false
+			continue
+		}
+		indent := indentation(line)
+		if blank && indent < 2 {
+			break
+		}
+		if !blank && indent < 2 && startsBlock(line) {
+			break
+		}
+		// Continuation lines belong to the item, less the indentation that
+		// put them there.
+		if indent > 4 {
+			indent = 4
+		}
+		if blank {
+			loose = true
+		}
+		items[len(items)-1] = append(items[len(items)-1], line[indent:])
+		blank = false
+	}
+
+	tag := "ul"
+	start_attr := ""
+	if ordered {
+		tag = "ol"
+		if n := strings.TrimRight(first[2], ".)"); n != "1" {
+			start_attr = fmt.Sprintf(` start="%s"`, strings.TrimLeft(n, "0"))
+		}
+	}
+	out := []string{fmt.Sprintf("<%s%s>", tag, start_attr)}
+	for _, item := range items {
+		content := md.blocks(item)
+		if !loose {
+			// Tight lists don't wrap their items in paragraphs.
+			content = strings.Replace(strings.Replace(content, "<p>", "", -1), "</p>", "", -1)
+		}
+		out = append(out, "<li>"+content+"</li>")
+	}
+	out = append(out, fmt.Sprintf("</%s>", tag))
+	return strings.Join(out, "\n"), i
+}
+
+// hold sets text aside, returning a placeholder for where it goes back.
+func (md *markdown) hold(text string) string {
+	md.held = append(md.held, text)
+	return fmt.Sprintf("%s%d%s", MARKDOWN_PLACEHOLDER, len(md.held)-1, MARKDOWN_PLACEHOLDER)
+}
+
+// escapeText escapes text as HTML, leaving entities that are already there.
+func escapeText(text string) string {
+	text = md_amp_re.ReplaceAllStringFunc(text, func(amp string) string {
+		if amp == "&" {
+			return "&amp;"
+		}
+		return amp
+	})
+	text = strings.Replace(text, "<", "&lt;", -1)
+	text = strings.Replace(text, ">", "&gt;", -1)
+	return strings.Replace(text, `"`, "&quot;", -1)
+}
+
+// linkTag returns the opening tag of a link or an image. The URL and title
+// have already been escaped.
+func (md *markdown) linkTag(image bool, url string, title string, alt string) string {
+	title_attr := ""
+	if title != "" {
+		title_attr = fmt.Sprintf(` title="%s"`, title)
+	}
+	if image {
+		return md.hold(fmt.Sprintf(`<img src="%s" alt="%s"%s>`, url, alt, title_attr))
+	}
+	return md.hold(fmt.Sprintf(`<a href="%s"%s>`, url, title_attr))
+}
+
+// inline renders the Markdown within a block, such as emphasis and links.
+func (md *markdown) inline(text string) string {
+	// Code, HTML and escaped characters are set aside first, so that nothing
+	// inside them is mistaken for Markdown.
+	text = md_code_span_re.ReplaceAllStringFunc(text, func(span string) string {
+		m := md_code_span_re.FindStringSubmatch(span)
+		if m[1] != m[3] {
+			return span
+		}
+		return md.hold("<code>" + html.EscapeString(strings.TrimSpace(m[2])) + "</code>")
+	})
+	text = md_autolink_re.ReplaceAllStringFunc(text, func(link string) string {
+		target := link[1 : len(link)-1]
+		href := target
+		if !strings.Contains(target, "://") {
+			href = "mailto:" + target
+		}
+		return md.hold(fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(href), html.EscapeString(target)))
+	})
+	text = md_inline_tag_re.ReplaceAllStringFunc(text, md.hold)
+	text = md_escape_re.ReplaceAllStringFunc(text, func(escaped string) string {
+		return md.hold(html.EscapeString(escaped[1:]))
+	})
+	text = escapeText(text)
+
+	text = md_image_re.ReplaceAllStringFunc(text, func(image string) string {
+		m := md_image_re.FindStringSubmatch(image)
+		return md.linkTag(true, m[2], m[3], m[1])
+	})
+	text = md_link_re.ReplaceAllStringFunc(text, func(link string) string {
+		m := md_link_re.FindStringSubmatch(link)
+		return md.linkTag(false, m[2], m[3], "") + m[1] + "</a>"
+	})
+	text = md_ref_link_re.ReplaceAllStringFunc(text, func(link string) string {
+		m := md_ref_link_re.FindStringSubmatch(link)
+		id := m[3]
+		if id == "" {
+			id = m[2]
+		}
+		ref, ok := md.references[strings.ToLower(html.UnescapeString(id))]
+		if !ok {
+			return link
+		}
+		if m[1] == "!" {
+			return md.linkTag(true, escapeText(ref.url), escapeText(ref.title), m[2])
+		}
+		return md.linkTag(false, escapeText(ref.url), escapeText(ref.title), "") + m[2] + "</a>"
+	})
+
+	text = md_strong_re.ReplaceAllString(text, "<strong>$1$2</strong>")
+	text = md_em_re.ReplaceAllString(text, "<em>$1$2</em>")
+	text = md_strike_re.ReplaceAllString(text, "<del>$1</del>")
+	text = md_break_re.ReplaceAllString(text, "<br>\n")
+
+	// Put back what was set aside, which may itself have been set aside.
+	for md_placeholder_re.MatchString(text) {
+		text = md_placeholder_re.ReplaceAllStringFunc(text, func(placeholder string) string {
+			var n int
+			fmt.Sscanf(strings.Trim(placeholder, MARKDOWN_PLACEHOLDER), "%d", &n)
+			return md.held[n]
+		})
+	}
+	return text
+}
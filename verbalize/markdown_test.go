@@ -0,0 +1,76 @@
+package blog
+
+import (
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		// Emphasis
+		{"emphasis", "*em* and _em_", "<p><em>em</em> and <em>em</em></p>"},
+		{"strong", "**strong** and __strong__", "<p><strong>strong</strong> and <strong>strong</strong></p>"},
+		{"strikethrough", "~~gone~~", "<p><del>gone</del></p>"},
+		{"underscores in words", "snake_case_name", "<p>snake_case_name</p>"},
+		{"escaped", `\*not em\*`, "<p>*not em*</p>"},
+		{"line break", "line  \nbreak", "<p>line<br>\nbreak</p>"},
+
+		// Links and images
+		{"link", `[a link](http://example.com/ "Title")`, `<p><a href="http://example.com/" title="Title">a link</a></p>`},
+		{"link query", "[q](http://example.com/?a=1&b=2)", `<p><a href="http://example.com/?a=1&amp;b=2">q</a></p>`},
+		{"autolink", "<http://example.com/>", `<p><a href="http://example.com/">http://example.com/</a></p>`},
+		{"email autolink", "<bob@example.com>", `<p><a href="mailto:bob@example.com">bob@example.com</a></p>`},
+		{"image", "![alt](/a.png)", `<p><img src="/a.png" alt="alt"></p>`},
+		{"references", "[ref][1] and ![img][1]\n\n[1]: http://example.com/x.png \"T\"",
+			`<p><a href="http://example.com/x.png" title="T">ref</a> and <img src="http://example.com/x.png" alt="img" title="T"></p>`},
+		{"unknown reference", "[ref][nope]", "<p>[ref][nope]</p>"},
+
+		// Lists
+		{"bullets", "- one\n- two\n* three", "<ul>\n<li>one</li>\n<li>two</li>\n<li>three</li>\n</ul>"},
+		{"numbers", "1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>"},
+		{"numbers from three", "3. three\n4. four", "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>"},
+		{"loose", "- one\n\n- two", "<ul>\n<li><p>one</p></li>\n<li><p>two</p></li>\n</ul>"},
+		{"nested", "- one\n  more\n- two\n    - nested",
+			"<ul>\n<li>one\nmore</li>\n<li>two\n<ul>\n<li>nested</li>\n</ul></li>\n</ul>"},
+
+		// Code
+		{"fenced", "```go\nif a < b && c {\n}\n```", "<pre><code class=\"language-go\">if a &lt; b &amp;&amp; c {\n}\n</code></pre>"},
+		{"fenced markdown", "~~~\n*not em*\n~~~", "<pre><code>*not em*\n</code></pre>"},
+		{"indented", "    indented <code>\n\n    more", "<pre><code>indented &lt;code&gt;\n\nmore\n</code></pre>"},
+		{"span", "Use `a <b> & *c*` here", "<p>Use <code>a &lt;b&gt; &amp; *c*</code> here</p>"},
+
+		// HTML escaping, and HTML passed through
+		{"escaping", `5 < 6 & "quotes"`, "<p>5 &lt; 6 &amp; &quot;quotes&quot;</p>"},
+		{"entities", "&amp; &copy; &#169;", "<p>&amp; &copy; &#169;</p>"},
+		{"inline html", "a <em>b</em> c", "<p>a <em>b</em> c</p>"},
+		{"html block", "<div class=\"x\">\n*raw*\n</div>", "<div class=\"x\">\n*raw*\n</div>"},
+		{"more tag", "intro\n\n<!--more-->\n\nrest", "<p>intro</p>\n<!--more-->\n<p>rest</p>"},
+
+		// Other blocks
+		{"headings", "# Heading #\n\nSetext\n===\n\nSub\n---", "<h1>Heading</h1>\n<h1>Setext</h1>\n<h2>Sub</h2>"},
+		{"quote", "> quote\n> *more*", "<blockquote>\n<p>quote\n<em>more</em></p>\n</blockquote>"},
+		{"rule", "***", "<hr>"},
+		{"windows line endings", "one\r\n\r\ntwo", "<p>one</p>\n<p>two</p>"},
+	}
+	for _, tt := range tests {
+		if got := Markdown(tt.in); got != tt.want {
+			t.Errorf("%s: Markdown(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEntryHTML(t *testing.T) {
+	for _, format := range []string{"", FORMAT_HTML} {
+		e := SavedEntry{Format: format, Content: []byte("*as is*")}
+		if got := string(e.HTML()); got != "*as is*" {
+			t.Errorf("HTML() of a %q entry = %q, want its content as is", format, got)
+		}
+	}
+	e := SavedEntry{Format: FORMAT_MARKDOWN, Content: []byte("*rendered*")}
+	if got := string(e.HTML()); got != "<p><em>rendered</em></p>" {
+		t.Errorf("HTML() of a Markdown entry = %q", got)
+	}
+}
@@ -0,0 +1,460 @@
+// Uploaded images, kept in a BlobStore along with resized variants of them,
+// and the media library that browses them.
+package blog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Largest file that may be uploaded, in bytes.
+	MAX_MEDIA_SIZE = 10 << 20
+	// Largest image that is resized, in pixels, so that a small file can't
+	// decode into more memory than we have.
+	MAX_MEDIA_PIXELS = 50 * 1000 * 1000
+	// Quality of resized JPEG variants.
+	MEDIA_JPEG_QUALITY = 85
+
+	// The variant that is the file as it was uploaded.
+	MEDIA_ORIGINAL = "original"
+	// The variant inserted into entries by the editor.
+	MEDIA_INSERT_VARIANT = "medium"
+)
+
+var (
+	// Resized variants made of every uploaded image, by their widest size.
+	// Images no wider than a variant are not resized for it.
+	media_variants = []struct {
+		Name  string
+		Width int
+	}{
+		{"thumbnail", 150},
+		{"medium", 640},
+		{"large", 1280},
+	}
+
+	// Image types that may be uploaded, by the extension they are stored with.
+	media_extensions = map[string]string{
+		"jpeg": ".jpg",
+		"png":  ".png",
+		"gif":  ".gif",
+	}
+
+	// regexp matching the name of a blob under /media/
+	media_blob_re = regexp.MustCompile(`^[0-9a-z]+/[0-9a-z_-]+\.(jpg|png|gif)$`)
+
+	// ErrInvalidBlobName is returned by a BlobStore for names it can't store.
+	ErrInvalidBlobName = errors.New("blog: invalid blob name")
+)
+
+// BlobStore keeps the contents of uploaded files, by name. Names are made of
+// path segments separated by "/".
+type BlobStore interface {
+	GetBlob(name string) ([]byte, error)
+	PutBlob(name string, data []byte) error
+	DeleteBlob(name string) error
+}
+
+// DiskBlobStore is a BlobStore that keeps each blob as a file under a directory.
+type DiskBlobStore struct {
+	dir string
+}
+
+// NewDiskBlobStore returns a DiskBlobStore keeping its files under dir.
+func NewDiskBlobStore(dir string) *DiskBlobStore {
+	return &DiskBlobStore{dir: dir}
+}
+
+// path returns the file a blob is kept in.
+func (d *DiskBlobStore) path(name string) (string, error) {
+	if name == "" || path.Clean(name) != name || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "..") {
+		return "", ErrInvalidBlobName
+	}
+	return filepath.Join(d.dir, filepath.FromSlash(name)), nil
+}
+
+// GetBlob returns the contents of a blob.
+func (d *DiskBlobStore) GetBlob(name string) ([]byte, error) {
+	p, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		err = ErrNoSuchEntity
+	}
+	return data, err
+}
+
+// PutBlob writes a blob, creating the directories it is in.
+func (d *DiskBlobStore) PutBlob(name string, data []byte) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Write to the side and rename, so that a crash never leaves half a file.
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// DeleteBlob removes a blob, and the directory it was in if that is now empty.
+func (d *DiskBlobStore) DeleteBlob(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrNoSuchEntity
+	} else if err != nil {
+		return err
+	}
+	// Fails harmlessly if other blobs are still there.
+	os.Remove(filepath.Dir(p))
+	return nil
+}
+
+// One size of an uploaded image.
+type MediaVariant struct {
+	Name   string
+	Width  int
+	Height int
+	// Name of the blob it is kept in.
+	Blob string
+}
+
+// URL returns where the variant is served from.
+func (v *MediaVariant) URL() string {
+	return config.Require("subdirectory") + "media/" + v.Blob
+}
+
+// Uploaded image, stored in Datastore and keyed by ID. The file itself, and
+// its variants, are in the BlobStore.
+type SavedMediaFile struct {
+	ID string
+	// Name of the file that was uploaded.
+	Filename    string
+	ContentType string
+	Size        int
+	Uploaded    time.Time
+	// User who uploaded it.
+	Uploader string
+	// The original, followed by each resized variant, smallest first.
+	Variants []MediaVariant
+}
+
+// Variant returns a variant by name, or the original if there is no such one.
+func (f *SavedMediaFile) Variant(name string) *MediaVariant {
+	for i, v := range f.Variants {
+		if v.Name == name {
+			return &f.Variants[i]
+		}
+	}
+	return f.Original()
+}
+
+// Original returns the file as it was uploaded.
+func (f *SavedMediaFile) Original() *MediaVariant {
+	for i, v := range f.Variants {
+		if v.Name == MEDIA_ORIGINAL {
+			return &f.Variants[i]
+		}
+	}
+	return &MediaVariant{Name: MEDIA_ORIGINAL}
+}
+
+// GetMediaFiles retrieves every uploaded file, newest first
+func GetMediaFiles(c Context) ([]SavedMediaFile, error) {
+	return c.Store().GetMediaFiles()
+}
+
+// GetMediaFile retrieves an uploaded file by ID
+func GetMediaFile(c Context, id string) (SavedMediaFile, error) {
+	return c.Store().GetMediaFile(id)
+}
+
+// mayDeleteMedia returns true if the current user may delete an uploaded file.
+func mayDeleteMedia(c Context, f *SavedMediaFile) bool {
+	return hasRole(c, ROLE_EDITOR) || f.Uploader == c.CurrentUser()
+}
+
+// scaleImage shrinks an image to width by height, averaging the pixels each
+// one covers.
+func scaleImage(src image.Image, width int, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// encodeVariant encodes a resized image in the format of the original. GIFs
+// are resized to PNG, which keeps their transparency without a palette.
+func encodeVariant(img image.Image, format string) (data []byte, ext string, err error) {
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: MEDIA_JPEG_QUALITY})
+		return buf.Bytes(), ".jpg", err
+	}
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), ".png", err
+}
+
+// newMediaID returns an ID for a new upload, which is also the directory its
+// blobs are kept in.
+func newMediaID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// SaveMedia stores an uploaded image and its resized variants. If any of them
+// can't be stored, the ones that were are deleted again.
+func SaveMedia(c Context, filename string, data []byte) (f SavedMediaFile, err error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return f, fmt.Errorf("%s is not a JPEG, PNG or GIF image", filename)
+	}
+	ext, ok := media_extensions[format]
+	if !ok {
+		return f, fmt.Errorf("%s images can't be uploaded", format)
+	}
+	if cfg.Width*cfg.Height > MAX_MEDIA_PIXELS {
+		return f, fmt.Errorf("%s is too large to resize, at %dx%d", filename, cfg.Width, cfg.Height)
+	}
+
+	f = SavedMediaFile{
+		ID:          newMediaID(),
+		Filename:    filename,
+		ContentType: mime.TypeByExtension(ext),
+		Size:        len(data),
+		Uploaded:    time.Now(),
+		Uploader:    c.CurrentUser(),
+	}
+	original := MediaVariant{Name: MEDIA_ORIGINAL, Width: cfg.Width, Height: cfg.Height, Blob: f.ID + "/" + MEDIA_ORIGINAL + ext}
+	if err := c.Blobs().PutBlob(original.Blob, data); err != nil {
+		return f, err
+	}
+	written := []string{original.Blob}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, blob := range written {
+			if delete_err := c.Blobs().DeleteBlob(blob); delete_err != nil {
+				c.Errorf("Unable to delete %s after failing to save %s: %v", blob, filename, delete_err)
+			}
+		}
+	}()
+
+	var img image.Image
+	for _, size := range media_variants {
+		if cfg.Width <= size.Width {
+			continue
+		}
+		if img == nil {
+			if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+				return f, fmt.Errorf("Unable to read %s: %v", filename, err)
+			}
+		}
+		variant := MediaVariant{Name: size.Name, Width: size.Width, Height: cfg.Height * size.Width / cfg.Width}
+		if variant.Height < 1 {
+			variant.Height = 1
+		}
+		resized, variant_ext, err := encodeVariant(scaleImage(img, variant.Width, variant.Height), format)
+		if err != nil {
+			return f, err
+		}
+		variant.Blob = f.ID + "/" + size.Name + variant_ext
+		if err := c.Blobs().PutBlob(variant.Blob, resized); err != nil {
+			return f, err
+		}
+		written = append(written, variant.Blob)
+		f.Variants = append(f.Variants, variant)
+	}
+	f.Variants = append(f.Variants, original)
+	return f, c.Store().PutMediaFile(&f)
+}
+
+// DeleteMedia removes an uploaded file and all of its variants.
+func DeleteMedia(c Context, f *SavedMediaFile) error {
+	for _, v := range f.Variants {
+		if err := c.Blobs().DeleteBlob(v.Blob); err != nil && err != ErrNoSuchEntity {
+			return err
+		}
+	}
+	return c.Store().DeleteMediaFile(f.ID)
+}
+
+// readUpload reads an uploaded file from a form field.
+func readUpload(r *http.Request, field string) (filename string, data []byte, err error) {
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		return "", nil, fmt.Errorf("No file was uploaded: %v", err)
+	}
+	defer file.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(file, MAX_MEDIA_SIZE+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) > MAX_MEDIA_SIZE {
+		return "", nil, fmt.Errorf("%s is larger than %d MB", header.Filename, MAX_MEDIA_SIZE>>20)
+	}
+	return path.Base(strings.Replace(header.Filename, "\\", "/", -1)), data, nil
+}
+
+// handler for /media/ - serves uploaded files and their variants
+func mediaHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	name := strings.TrimPrefix(r.URL.Path, "/media/")
+	if !media_blob_re.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := c.Blobs().GetBlob(name)
+	if err == ErrNoSuchEntity {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	// Blobs are never changed once uploaded, only deleted.
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	w.Write(data)
+}
+
+// handler for /admin/media - lists uploaded files, and picks one for the
+// editor when opened by CKEditor with a CKEditorFuncNum
+func adminMediaHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	context, _ := GetTemplateContext(nil, nil, "Media", "admin_media", r)
+	files, err := GetMediaFiles(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	context.MediaFiles = files
+	context.CKEditorFuncNum = r.FormValue("CKEditorFuncNum")
+	context.MediaVariant = MEDIA_INSERT_VARIANT
+	renderTemplate(w, *adminMediaTpl, context)
+}
+
+// ckeditorCallback answers an upload from a CKEditor dialog, which reads the
+// response in a hidden frame.
+func ckeditorCallback(w http.ResponseWriter, funcNum int, url string, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<script>window.parent.CKEDITOR.tools.callFunction(%d, '%s', '%s');</script>",
+		funcNum, template.JSEscapeString(url), template.JSEscapeString(message))
+}
+
+// handler for /admin/upload_media - stores an uploaded image, for the media
+// page or a CKEditor image dialog
+func adminUploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Uploading requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	// CKEditor names its file field "upload", so the media page does too.
+	func_num, from_editor := 0, r.FormValue("CKEditorFuncNum") != ""
+	if from_editor {
+		var err error
+		if func_num, err = strconv.Atoi(r.FormValue("CKEditorFuncNum")); err != nil {
+			http.Error(w, "Invalid CKEditorFuncNum", http.StatusBadRequest)
+			return
+		}
+	}
+	filename, data, err := readUpload(r, "upload")
+	var f SavedMediaFile
+	if err == nil {
+		f, err = SaveMedia(c, filename, data)
+	}
+	if err != nil {
+		c.Errorf("Unable to save upload: %v", err)
+		if from_editor {
+			ckeditorCallback(w, func_num, "", err.Error())
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	c.Infof("Uploaded %s as %s", filename, f.ID)
+	if from_editor {
+		ckeditorCallback(w, func_num, f.Variant(MEDIA_INSERT_VARIANT).URL(), "")
+		return
+	}
+	http.Redirect(w, r, "/admin/media", http.StatusFound)
+}
+
+// handler for /admin/delete_media - deletes an uploaded file and its variants
+func adminDeleteMediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Deleting requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	f, err := GetMediaFile(c, r.FormValue("id"))
+	if err == ErrNoSuchEntity {
+		http.Error(w, "No such file", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !mayDeleteMedia(c, &f) {
+		http.Error(w, "Only editors may delete files uploaded by someone else.", http.StatusForbidden)
+		return
+	}
+	if err := DeleteMedia(c, &f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Deleted %s (%s)", f.ID, f.Filename)
+	http.Redirect(w, r, "/admin/media", http.StatusFound)
+}
@@ -0,0 +1,124 @@
+package blog
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingBlobStore is a BlobStore that refuses to put blobs whose names
+// contain fail.
+type failingBlobStore struct {
+	BlobStore
+	fail string
+}
+
+func (f *failingBlobStore) PutBlob(name string, data []byte) error {
+	if strings.Contains(name, f.fail) {
+		return errors.New("no room for " + name)
+	}
+	return f.BlobStore.PutBlob(name, data)
+}
+
+// countFiles returns how many files there are under dir.
+func countFiles(t *testing.T, dir string) (count int) {
+	t.Helper()
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
+
+func TestDiskBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	blobs := NewDiskBlobStore(dir)
+	if err := blobs.PutBlob("abc/original.png", []byte("data")); err != nil {
+		t.Fatalf("PutBlob: %v", err)
+	}
+	if data, err := blobs.GetBlob("abc/original.png"); err != nil || string(data) != "data" {
+		t.Errorf("GetBlob = %q, %v", data, err)
+	}
+	if err := blobs.DeleteBlob("abc/original.png"); err != nil {
+		t.Errorf("DeleteBlob: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abc")); !os.IsNotExist(err) {
+		t.Errorf("the emptied directory is still there: %v", err)
+	}
+	if _, err := blobs.GetBlob("abc/original.png"); err != ErrNoSuchEntity {
+		t.Errorf("GetBlob after DeleteBlob error = %v, want ErrNoSuchEntity", err)
+	}
+	if err := blobs.DeleteBlob("abc/original.png"); err != ErrNoSuchEntity {
+		t.Errorf("DeleteBlob again error = %v, want ErrNoSuchEntity", err)
+	}
+	for _, name := range []string{"", "../outside", "/etc/passwd", "a/../b", "a//b"} {
+		if err := blobs.PutBlob(name, nil); err != ErrInvalidBlobName {
+			t.Errorf("PutBlob(%q) error = %v, want ErrInvalidBlobName", name, err)
+		}
+	}
+}
+
+func TestSaveMedia(t *testing.T) {
+	c := newTestContextWithBlobs(t, "alice")
+	f, err := SaveMedia(c, "wide.png", testPNG(t, 1300, 13))
+	if err != nil {
+		t.Fatalf("SaveMedia: %v", err)
+	}
+	var names []string
+	for _, v := range f.Variants {
+		names = append(names, v.Name)
+		if _, err := c.Blobs().GetBlob(v.Blob); err != nil {
+			t.Errorf("GetBlob(%s): %v", v.Blob, err)
+		}
+	}
+	if want := []string{"thumbnail", "medium", "large", MEDIA_ORIGINAL}; !equalStrings(names, want) {
+		t.Errorf("variants %v, want %v", names, want)
+	}
+	if medium := f.Variant("medium"); medium.Width != 640 || medium.Height != 6 {
+		t.Errorf("medium variant is %dx%d, want 640x6", medium.Width, medium.Height)
+	}
+	if f.Uploader != "alice" || f.ContentType != "image/png" {
+		t.Errorf("uploaded by %q as %q", f.Uploader, f.ContentType)
+	}
+	if _, err := GetMediaFile(c, f.ID); err != nil {
+		t.Errorf("GetMediaFile: %v", err)
+	}
+
+	small, err := SaveMedia(c, "small.png", testPNG(t, 100, 100))
+	if err != nil {
+		t.Fatalf("SaveMedia: %v", err)
+	}
+	if len(small.Variants) != 1 || small.Variant("medium") != small.Original() {
+		t.Errorf("a small image has variants %+v, want just the original", small.Variants)
+	}
+	if _, err := SaveMedia(c, "notes.txt", []byte("not an image")); err == nil {
+		t.Errorf("SaveMedia saved a text file")
+	}
+}
+
+func TestSaveMediaCleansUpAfterAFailure(t *testing.T) {
+	dir := t.TempDir()
+	local := &Local{
+		Store:  NewMemoryStore(),
+		Blobs:  &failingBlobStore{BlobStore: NewDiskBlobStore(dir), fail: "large"},
+		Cache:  NewMemoryCache(),
+		Client: http.DefaultClient,
+	}
+	contextFor = local.NewContext
+	c := local.NewContext(testRequest("GET", "/", nil, "alice"))
+
+	if _, err := SaveMedia(c, "wide.png", testPNG(t, 1300, 13)); err == nil {
+		t.Fatalf("SaveMedia succeeded with a blob store that fails")
+	}
+	if n := countFiles(t, dir); n != 0 {
+		t.Errorf("%d blobs were left behind", n)
+	}
+	if files, _ := GetMediaFiles(c); len(files) != 0 {
+		t.Errorf("got media files %+v, want none", files)
+	}
+}
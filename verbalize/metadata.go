@@ -0,0 +1,179 @@
+// Custom metadata fields of entries, such as a day's mileage or a hero
+// image, for themes to show.
+package blog
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Types a metadata field may be declared with in verbalize.yml.
+	METADATA_STRING = "string"
+	METADATA_NUMBER = "number"
+	METADATA_DATE   = "date"
+	METADATA_URL    = "url"
+
+	// Layout of date fields, as a date input submits them.
+	METADATA_DATE_LAYOUT = "2006-01-02"
+)
+
+var (
+	// regexp matching a metadata key, which must be usable in a template as .Metadata.key
+	metadata_key_re = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+	// The metadata fields declared in verbalize.yml.
+	metadata_fields = loadMetadataFields()
+)
+
+// A metadata field declared in verbalize.yml. Entries may have other fields
+// too, which are strings.
+type MetadataField struct {
+	Name  string
+	Type  string
+	Label string
+}
+
+// One metadata field of an entry, as it was written.
+type MetadataValue struct {
+	Key   string
+	Value string
+}
+
+// loadMetadataFields reads the metadata fields declared in verbalize.yml:
+//
+//	metadata:
+//	  - name: miles
+//	    type: number
+//	    label: Miles ridden
+func loadMetadataFields() (fields []MetadataField) {
+	count, err := config.Count("metadata")
+	if err != nil {
+		return nil
+	}
+	for i := 0; i < count; i++ {
+		name, _ := config.Get(fmt.Sprintf("metadata[%d].name", i))
+		field_type, _ := config.Get(fmt.Sprintf("metadata[%d].type", i))
+		label, _ := config.Get(fmt.Sprintf("metadata[%d].label", i))
+		if !metadata_key_re.MatchString(name) {
+			log.Printf("Ignoring metadata field %d: %q is not a valid name", i, name)
+			continue
+		}
+		switch field_type {
+		case "":
+			field_type = METADATA_STRING
+		case METADATA_STRING, METADATA_NUMBER, METADATA_DATE, METADATA_URL:
+		default:
+			log.Printf("Metadata field %s has unknown type %q, treating it as a string", name, field_type)
+			field_type = METADATA_STRING
+		}
+		if label == "" {
+			label = strings.Replace(name, "_", " ", -1)
+		}
+		fields = append(fields, MetadataField{Name: name, Type: field_type, Label: label})
+	}
+	return fields
+}
+
+// metadataField returns the declared field named key, or nil if it wasn't.
+func metadataField(key string) *MetadataField {
+	for i, f := range metadata_fields {
+		if f.Name == key {
+			return &metadata_fields[i]
+		}
+	}
+	return nil
+}
+
+// parseMetadataValue returns a metadata value as its declared type: float64
+// for numbers, time.Time for dates, and a string otherwise.
+func parseMetadataValue(field_type string, value string) (interface{}, error) {
+	switch field_type {
+	case METADATA_NUMBER:
+		return strconv.ParseFloat(value, 64)
+	case METADATA_DATE:
+		return time.ParseInLocation(METADATA_DATE_LAYOUT, value, location)
+	case METADATA_URL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		// Relative URLs must start at the root, as media library ones do.
+		if u.Scheme != "http" && u.Scheme != "https" && !strings.HasPrefix(value, "/") {
+			return nil, fmt.Errorf("not an http, https or /path URL")
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+// validateMetadata returns why metadata can't be saved, or "" if it can.
+func validateMetadata(metadata []MetadataValue) string {
+	seen := make(map[string]bool)
+	for _, m := range metadata {
+		if !metadata_key_re.MatchString(m.Key) {
+			return fmt.Sprintf("The metadata field %q needs a name of lower case letters, digits and underscores.", m.Key)
+		}
+		if seen[m.Key] {
+			return fmt.Sprintf("The metadata field %q is given twice.", m.Key)
+		}
+		seen[m.Key] = true
+		if f := metadataField(m.Key); f != nil {
+			if _, err := parseMetadataValue(f.Type, m.Value); err != nil {
+				return fmt.Sprintf("%s must be a %s: %v", f.Label, f.Type, err)
+			}
+		}
+	}
+	return ""
+}
+
+// parseMetadataForm reads the metadata fields of the edit form, which come as
+// matching lists of keys and values. Fields without a value are dropped.
+func parseMetadataForm(keys []string, values []string) (metadata []MetadataValue) {
+	for i, key := range keys {
+		if i >= len(values) {
+			break
+		}
+		key = strings.TrimSpace(key)
+		value := strings.TrimSpace(values[i])
+		if key == "" || value == "" {
+			continue
+		}
+		metadata = append(metadata, MetadataValue{Key: key, Value: value})
+	}
+	return metadata
+}
+
+// metadataMaps returns an entry's metadata for themes, as its declared types,
+// and as written for the editor. Values that no longer parse as their type,
+// as when a field is declared after entries were given it, stay strings.
+func metadataMaps(metadata []MetadataValue) (typed map[string]interface{}, source map[string]string) {
+	typed = make(map[string]interface{})
+	source = make(map[string]string)
+	for _, m := range metadata {
+		source[m.Key] = m.Value
+		typed[m.Key] = m.Value
+		if f := metadataField(m.Key); f != nil {
+			if value, err := parseMetadataValue(f.Type, m.Value); err == nil {
+				typed[m.Key] = value
+			}
+		}
+	}
+	return typed, source
+}
+
+// undeclaredMetadata returns the metadata of an entry that isn't declared in
+// verbalize.yml, for the editor to show alongside the declared fields.
+func undeclaredMetadata(metadata []MetadataValue) (undeclared []MetadataValue) {
+	for _, m := range metadata {
+		if metadataField(m.Key) == nil {
+			undeclared = append(undeclared, m)
+		}
+	}
+	return undeclared
+}
@@ -0,0 +1,134 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// withMetadataFields runs a test with fields declared in place of verbalize.yml's.
+func withMetadataFields(t *testing.T, fields []MetadataField) {
+	t.Helper()
+	real := metadata_fields
+	metadata_fields = fields
+	t.Cleanup(func() { metadata_fields = real })
+}
+
+var test_metadata_fields = []MetadataField{
+	{Name: "miles", Type: METADATA_NUMBER, Label: "Miles ridden"},
+	{Name: "ridden_on", Type: METADATA_DATE, Label: "Ridden on"},
+	{Name: "hero", Type: METADATA_URL, Label: "Hero image"},
+	{Name: "weather", Type: METADATA_STRING, Label: "Weather"},
+}
+
+func TestParseMetadataValue(t *testing.T) {
+	tests := []struct {
+		field_type string
+		value      string
+		want       interface{}
+	}{
+		{METADATA_NUMBER, "42.5", 42.5},
+		{METADATA_NUMBER, "-3", -3.0},
+		{METADATA_NUMBER, "lots", nil},
+		{METADATA_DATE, "2014-05-06", time.Date(2014, 5, 6, 0, 0, 0, 0, location)},
+		{METADATA_DATE, "May 6th", nil},
+		{METADATA_URL, "https://example.com/a.png", "https://example.com/a.png"},
+		{METADATA_URL, "/media/1/original.png", "/media/1/original.png"},
+		{METADATA_URL, "javascript:alert(1)", nil},
+		{METADATA_URL, "a.png", nil},
+		{METADATA_STRING, "sunny", "sunny"},
+	}
+	for _, tt := range tests {
+		got, err := parseMetadataValue(tt.field_type, tt.value)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseMetadataValue(%s, %q) = %v, want an error", tt.field_type, tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMetadataValue(%s, %q): %v", tt.field_type, tt.value, err)
+		} else if when, ok := tt.want.(time.Time); ok {
+			if !when.Equal(got.(time.Time)) {
+				t.Errorf("parseMetadataValue(%s, %q) = %v, want %v", tt.field_type, tt.value, got, tt.want)
+			}
+		} else if got != tt.want {
+			t.Errorf("parseMetadataValue(%s, %q) = %#v, want %#v", tt.field_type, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	withMetadataFields(t, test_metadata_fields)
+	tests := []struct {
+		metadata []MetadataValue
+		valid    bool
+	}{
+		{[]MetadataValue{{"miles", "12"}, {"ridden_on", "2014-05-06"}, {"mood", "anything"}}, true},
+		{[]MetadataValue{{"miles", "twelve"}}, false},
+		{[]MetadataValue{{"ridden_on", "06/05/2014"}}, false},
+		{[]MetadataValue{{"hero", "ftp://example.com/a.png"}}, false},
+		{[]MetadataValue{{"Miles", "12"}}, false},
+		{[]MetadataValue{{"my-field", "x"}}, false},
+		{[]MetadataValue{{"mood", "a"}, {"mood", "b"}}, false},
+	}
+	for _, tt := range tests {
+		if problem := validateMetadata(tt.metadata); (problem == "") != tt.valid {
+			t.Errorf("validateMetadata(%v) = %q, want valid %t", tt.metadata, problem, tt.valid)
+		}
+	}
+}
+
+func TestParseMetadataForm(t *testing.T) {
+	got := parseMetadataForm([]string{" miles ", "", "empty", "weather", "extra"}, []string{"12", "dropped", " ", " sunny "})
+	want := []MetadataValue{{"miles", "12"}, {"weather", "sunny"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("parseMetadataForm = %v, want %v", got, want)
+	}
+}
+
+func TestMetadataMaps(t *testing.T) {
+	withMetadataFields(t, test_metadata_fields)
+	metadata := []MetadataValue{{"miles", "12.5"}, {"ridden_on", "not a date"}, {"mood", "happy"}}
+	typed, source := metadataMaps(metadata)
+	if typed["miles"] != 12.5 {
+		t.Errorf("typed miles = %#v, want 12.5", typed["miles"])
+	}
+	// Declared after it was written, so it stays as it was.
+	if typed["ridden_on"] != "not a date" || typed["mood"] != "happy" {
+		t.Errorf("typed = %v, want ridden_on and mood as strings", typed)
+	}
+	if source["miles"] != "12.5" || len(source) != 3 {
+		t.Errorf("source = %v", source)
+	}
+	if undeclared := undeclaredMetadata(metadata); len(undeclared) != 1 || undeclared[0].Key != "mood" {
+		t.Errorf("undeclaredMetadata = %v, want just mood", undeclared)
+	}
+}
+
+func TestSubmittingAnEntryChecksItsMetadata(t *testing.T) {
+	withMetadataFields(t, test_metadata_fields)
+	c := newTestContext("editor")
+	form := url.Values{
+		"title":          {"A ride"},
+		"slug":           {"a-ride"},
+		"content":        {"Went for a ride."},
+		"status":         {STATUS_PUBLISHED},
+		"is_new_post":    {"1"},
+		"metadata_key":   {"miles", "weather"},
+		"metadata_value": {"lots", "sunny"},
+	}
+	if w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor"); w.Code != http.StatusBadRequest {
+		t.Errorf("submitting miles of %q gave %d, want %d", "lots", w.Code, http.StatusBadRequest)
+	}
+	if _, err := c.Store().GetSingleEntry("a-ride"); err != ErrNoSuchEntity {
+		t.Errorf("GetSingleEntry after a bad submit error = %v, want ErrNoSuchEntity", err)
+	}
+
+	form["metadata_value"] = []string{"12", "sunny"}
+	entry := submitEntry(t, c, "editor", form)
+	if want := []MetadataValue{{"miles", "12"}, {"weather", "sunny"}}; len(entry.Metadata) != 2 || entry.Metadata[0] != want[0] || entry.Metadata[1] != want[1] {
+		t.Errorf("saved metadata %v, want %v", entry.Metadata, want)
+	}
+}
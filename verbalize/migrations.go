@@ -0,0 +1,251 @@
+// Migrations: changes to entries and links that were stored before the code
+// that reads them changed, run once each, in batches, and recorded.
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// How many entries a migration changes before recording its progress.
+	MIGRATION_BATCH_SIZE = 100
+	// How long the admin page lets migrations run before coming back.
+	MIGRATION_REQUEST_TIME = 30 * time.Second
+
+	// What a migration is working through, in order.
+	MIGRATION_STAGE_POSTS = "posts"
+	MIGRATION_STAGE_PAGES = "pages"
+	MIGRATION_STAGE_LINKS = "links"
+)
+
+// A Migration changes stored entries and links. Entry and Link are called for
+// each one, and return true if it needs to be saved again; either may be nil.
+// Migrations must not change PublishDate, which batches are paged by, and
+// must be safe to run over an entity more than once.
+type Migration struct {
+	// Unique and never changed, so start it with the date it was written.
+	ID          string
+	Description string
+	Entry       func(e *SavedEntry) bool
+	Link        func(l *SavedLink) bool
+}
+
+// Every migration, in the order they run. Never remove or reorder them: a
+// blog that is several versions behind runs all the ones it hasn't yet.
+var migrations = []Migration{
+	{
+		ID:          "2014-05-entry-status",
+		Description: "Give entries saved before there was a workflow status the one their hidden and scheduled flags stand for.",
+		Entry: func(e *SavedEntry) bool {
+			if e.Status != "" {
+				return false
+			}
+			e.Status = e.CurrentStatus()
+			return true
+		},
+	},
+	{
+		ID:          "2014-06-drop-relative-url",
+		Description: "Remove the unused RelativeUrl field from entries, by saving them without it.",
+		Entry: func(e *SavedEntry) bool {
+			return true
+		},
+	},
+}
+
+// Record of a migration, stored in Datastore and keyed by ID. It is saved
+// after every batch, so that a migration can carry on where it left off.
+type SavedMigration struct {
+	ID      string
+	Started time.Time
+	// When it finished, or zero if it hasn't.
+	Finished time.Time
+	Stage    string
+	// Where in Stage the next batch starts.
+	Cursor string `datastore:",noindex"`
+	// How many entries and links have been looked at, and how many changed.
+	Processed int
+	Changed   int
+}
+
+// Done returns true if a migration has finished.
+func (m *SavedMigration) Done() bool {
+	return !m.Finished.IsZero()
+}
+
+// MigrationStatus is a migration along with its record, if it has started.
+type MigrationStatus struct {
+	Migration
+	Record *SavedMigration
+}
+
+// GetMigrationStatus returns every migration, with what has been done of it.
+func GetMigrationStatus(c Context) (status []MigrationStatus, err error) {
+	records, err := c.Store().GetMigrations()
+	if err != nil {
+		return nil, err
+	}
+	by_id := make(map[string]SavedMigration)
+	for _, record := range records {
+		by_id[record.ID] = record
+	}
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		if record, ok := by_id[m.ID]; ok {
+			s.Record = &record
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// nextStage returns the stage that follows stage for a migration, or "" if
+// there is nothing left for it to do.
+func (m *Migration) nextStage(stage string) string {
+	switch stage {
+	case "":
+		if m.Entry != nil {
+			return MIGRATION_STAGE_POSTS
+		}
+		fallthrough
+	case MIGRATION_STAGE_POSTS:
+		if m.Entry != nil {
+			return MIGRATION_STAGE_PAGES
+		}
+		fallthrough
+	case MIGRATION_STAGE_PAGES:
+		if m.Link != nil {
+			return MIGRATION_STAGE_LINKS
+		}
+	}
+	return ""
+}
+
+// runMigrationBatch runs the next batch of a migration, and records how far it got.
+func runMigrationBatch(c Context, m *Migration, record *SavedMigration) error {
+	switch record.Stage {
+	case MIGRATION_STAGE_POSTS, MIGRATION_STAGE_PAGES:
+		entries, cursor, err := GetEntries(c, EntryQuery{
+			IsPage:           record.Stage == MIGRATION_STAGE_PAGES,
+			IncludeHidden:    true,
+			IncludeScheduled: true,
+			Count:            MIGRATION_BATCH_SIZE,
+			Cursor:           record.Cursor,
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			record.Processed++
+			if m.Entry(&e) {
+				if err := PutEntry(c, &e); err != nil {
+					return err
+				}
+				record.Changed++
+			}
+		}
+		record.Cursor = cursor
+
+	case MIGRATION_STAGE_LINKS:
+		// There are never many links, so they are a single batch.
+		links, err := GetLinks(c)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			record.Processed++
+			if m.Link(&l) {
+				if err := PutLink(c, &l); err != nil {
+					return err
+				}
+				record.Changed++
+			}
+		}
+	}
+
+	if record.Cursor == "" {
+		record.Stage = m.nextStage(record.Stage)
+		if record.Stage == "" {
+			record.Finished = time.Now()
+			c.Infof("Migration %s finished: %d of %d changed", m.ID, record.Changed, record.Processed)
+		}
+	}
+	return c.Store().PutMigration(record)
+}
+
+// RunMigrations runs the migrations that haven't finished, in order, until
+// they all have or deadline passes. A zero deadline never passes. It returns
+// how many are left to finish.
+func RunMigrations(c Context, deadline time.Time) (pending int, err error) {
+	status, err := GetMigrationStatus(c)
+	if err != nil {
+		return 0, err
+	}
+	for i, s := range status {
+		record := s.Record
+		if record == nil {
+			record = &SavedMigration{ID: s.ID, Started: time.Now(), Stage: s.nextStage("")}
+			c.Infof("Starting migration %s", s.ID)
+		}
+		for !record.Done() {
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return len(status) - i, nil
+			}
+			if err := runMigrationBatch(c, &status[i].Migration, record); err != nil {
+				return len(status) - i, fmt.Errorf("migration %s: %v", s.ID, err)
+			}
+		}
+	}
+	return 0, nil
+}
+
+// MigrateOnStartup runs the migrations that haven't finished, if
+// migrate_on_startup is set in verbalize.yml.
+func MigrateOnStartup(c Context, deadline time.Time) {
+	if enabled, _ := config.Get("migrate_on_startup"); enabled != "true" {
+		return
+	}
+	if pending, err := RunMigrations(c, deadline); err != nil {
+		c.Errorf("Unable to migrate: %v", err)
+	} else if pending > 0 {
+		c.Infof("%d migrations are still to finish", pending)
+	}
+	c.Cache().Flush()
+}
+
+// handler for /_ah/warmup - App Engine's signal that an instance has started
+func warmupHandler(w http.ResponseWriter, r *http.Request) {
+	MigrateOnStartup(contextFor(r), time.Now().Add(MIGRATION_REQUEST_TIME))
+}
+
+// handler for /admin/migrations - lists migrations and how far they got
+func adminMigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	context, _ := GetTemplateContext(nil, nil, "Migrations", "admin_migrations", r)
+	status, err := GetMigrationStatus(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	context.Migrations = status
+	renderTemplate(w, *adminMigrationsTpl, context)
+}
+
+// handler for /admin/run_migrations - runs migrations for as long as a request may
+func adminRunMigrationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Migrating requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	pending, err := RunMigrations(c, time.Now().Add(MIGRATION_REQUEST_TIME))
+	c.Cache().Flush()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Ran migrations, %d still to finish", pending)
+	http.Redirect(w, r, "/admin/migrations", http.StatusFound)
+}
@@ -0,0 +1,172 @@
+package blog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// withMigrations runs a test with migrations in place of the real ones.
+func withMigrations(t *testing.T, replacement []Migration) {
+	t.Helper()
+	real := migrations
+	migrations = replacement
+	t.Cleanup(func() { migrations = real })
+}
+
+// countingMigration returns a migration that records every entry and link
+// it sees in seen, and changes the ones whose title starts with "change".
+func countingMigration(id string, seen map[string]int) Migration {
+	return Migration{
+		ID: id,
+		Entry: func(e *SavedEntry) bool {
+			seen[e.Slug]++
+			return len(e.Title) >= 6 && e.Title[:6] == "change"
+		},
+		Link: func(l *SavedLink) bool {
+			seen[l.URL]++
+			return false
+		},
+	}
+}
+
+// putManyEntries saves count posts, and a page and an undated draft, every
+// other one of them titled to be changed.
+func putManyEntries(t *testing.T, c Context, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		e := testEntry(fmt.Sprintf("post-%03d", i), i%7)
+		if i%2 == 0 {
+			e.Title = "change " + e.Slug
+		}
+		putEntries(t, c, e)
+	}
+	page := testEntry("page", 1)
+	page.IsPage = true
+	draft := testEntry("draft", 0)
+	draft.PublishDate = time.Time{}
+	draft.setStatus(STATUS_DRAFT)
+	putEntries(t, c, page, draft)
+}
+
+func TestRunMigrationsVisitsEverythingOnce(t *testing.T) {
+	c := newTestContext("admin")
+	count := MIGRATION_BATCH_SIZE*2 + 10
+	putManyEntries(t, c, count)
+	if err := PutLink(c, &SavedLink{Title: "Example", URL: "http://example.com/"}); err != nil {
+		t.Fatalf("PutLink: %v", err)
+	}
+	seen := make(map[string]int)
+	withMigrations(t, []Migration{countingMigration("2099-01-test", seen)})
+
+	pending, err := RunMigrations(c, time.Time{})
+	if err != nil || pending != 0 {
+		t.Fatalf("RunMigrations = %d, %v; want 0 pending", pending, err)
+	}
+	if len(seen) != count+3 {
+		t.Errorf("saw %d entries and links, want %d", len(seen), count+3)
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("saw %s %d times, want once", key, n)
+		}
+	}
+	status, _ := GetMigrationStatus(c)
+	record := status[0].Record
+	if record == nil || !record.Done() || record.Processed != count+3 || record.Changed != count/2 {
+		t.Errorf("record = %+v, want done, with %d processed and %d changed", record, count+3, count/2)
+	}
+
+	// Finished migrations aren't run again.
+	if _, err := RunMigrations(c, time.Time{}); err != nil {
+		t.Fatalf("RunMigrations again: %v", err)
+	}
+	if n := seen["post-000"]; n != 1 {
+		t.Errorf("saw post-000 %d times after running again, want once", n)
+	}
+}
+
+func TestRunMigrationsCarriesOnWhereItLeftOff(t *testing.T) {
+	c := newTestContext("admin")
+	count := MIGRATION_BATCH_SIZE + 10
+	putManyEntries(t, c, count)
+	first, second := make(map[string]int), make(map[string]int)
+	withMigrations(t, []Migration{countingMigration("2099-01-first", first), countingMigration("2099-02-second", second)})
+
+	// A deadline that has passed stops it before the first batch.
+	if pending, err := RunMigrations(c, time.Now().Add(-time.Second)); err != nil || pending != 2 {
+		t.Fatalf("RunMigrations with a passed deadline = %d, %v; want 2 pending", pending, err)
+	}
+
+	// Run one batch by hand, as a request that ran out of time would have.
+	record := &SavedMigration{ID: migrations[0].ID, Started: time.Now(), Stage: migrations[0].nextStage("")}
+	if err := runMigrationBatch(c, &migrations[0], record); err != nil {
+		t.Fatalf("runMigrationBatch: %v", err)
+	}
+	if record.Done() || record.Stage != MIGRATION_STAGE_POSTS || record.Cursor == "" || record.Processed != MIGRATION_BATCH_SIZE {
+		t.Errorf("record after one batch = %+v", record)
+	}
+
+	if pending, err := RunMigrations(c, time.Time{}); err != nil || pending != 0 {
+		t.Fatalf("RunMigrations = %d, %v; want 0 pending", pending, err)
+	}
+	for name, seen := range map[string]map[string]int{"first": first, "second": second} {
+		if len(seen) != count+2 {
+			t.Errorf("%s migration saw %d entries, want %d", name, len(seen), count+2)
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("%s migration saw %s %d times, want once", name, key, n)
+			}
+		}
+	}
+}
+
+func TestMigrationStages(t *testing.T) {
+	entry := func(e *SavedEntry) bool { return false }
+	link := func(l *SavedLink) bool { return false }
+	tests := []struct {
+		name      string
+		migration Migration
+		want      []string
+	}{
+		{"entries and links", Migration{Entry: entry, Link: link}, []string{MIGRATION_STAGE_POSTS, MIGRATION_STAGE_PAGES, MIGRATION_STAGE_LINKS}},
+		{"entries", Migration{Entry: entry}, []string{MIGRATION_STAGE_POSTS, MIGRATION_STAGE_PAGES}},
+		{"links", Migration{Link: link}, []string{MIGRATION_STAGE_LINKS}},
+		{"nothing", Migration{}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for stage := tt.migration.nextStage(""); stage != ""; stage = tt.migration.nextStage(stage) {
+			got = append(got, stage)
+		}
+		if !equalStrings(got, tt.want) {
+			t.Errorf("%s: stages %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEntryStatusMigration(t *testing.T) {
+	var migration Migration
+	for _, m := range migrations {
+		if m.ID == "2014-05-entry-status" {
+			migration = m
+		}
+	}
+	tests := []struct {
+		entry   SavedEntry
+		changed bool
+		want    string
+	}{
+		{SavedEntry{IsHidden: true}, true, STATUS_DRAFT},
+		{SavedEntry{IsScheduled: true}, true, STATUS_SCHEDULED},
+		{SavedEntry{}, true, STATUS_PUBLISHED},
+		{SavedEntry{Status: STATUS_REVIEW, IsHidden: true}, false, STATUS_REVIEW},
+	}
+	for _, tt := range tests {
+		e := tt.entry
+		if changed := migration.Entry(&e); changed != tt.changed || e.Status != tt.want {
+			t.Errorf("migrating %+v gave %s, changed %t; want %s, changed %t", tt.entry, e.Status, changed, tt.want, tt.changed)
+		}
+	}
+}
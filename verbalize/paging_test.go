@@ -0,0 +1,127 @@
+package blog
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// followArchive fetches every page of an archive of query in turn, by the
+// cursors in their next links, and returns the entries on each.
+func followArchive(t *testing.T, c Context, query EntryQuery) (pages [][]SavedEntry) {
+	t.Helper()
+	urlFor := func(page int) string { return pageURL("/", page) }
+	cursor := ""
+	for page := 1; page < 100; page++ {
+		entries, _, nextURL := getArchivePage(c, query, page, cursor, urlFor)
+		pages = append(pages, entries)
+		if nextURL == "" {
+			return pages
+		}
+		u, err := url.Parse(nextURL)
+		if err != nil {
+			t.Fatalf("page %d links to %q: %v", page, nextURL, err)
+		}
+		cursor = u.Query().Get("cursor")
+		if cursor == "" {
+			t.Fatalf("page %d links to %q, which has no cursor", page, nextURL)
+		}
+	}
+	t.Fatalf("archive never ended")
+	return nil
+}
+
+func TestArchivePagesFollowCursors(t *testing.T) {
+	c := newTestContext("")
+	per_page, _ := config.GetInt("entries_per_page")
+	total := int(per_page)*2 + 1
+	for i := 0; i < total; i++ {
+		putEntries(t, c, testEntry(fmt.Sprintf("post%02d", i), i))
+	}
+
+	pages := followArchive(t, c, EntryQuery{IsPage: false})
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want 3", len(pages))
+	}
+	seen := 0
+	for i, page := range pages {
+		if i < 2 && len(page) != int(per_page) {
+			t.Errorf("page %d has %d entries, want %d", i+1, len(page), per_page)
+		}
+		for _, e := range page {
+			if want := fmt.Sprintf("post%02d", seen); e.Slug != want {
+				t.Errorf("entry %d is %s, want %s", seen, e.Slug, want)
+			}
+			seen++
+		}
+	}
+	if seen != total {
+		t.Errorf("saw %d entries, want %d", seen, total)
+	}
+}
+
+func TestArchiveCursorKeepsItsQuery(t *testing.T) {
+	c := newTestContext("")
+	for i := 1; i <= 6; i++ {
+		putEntries(t, c, testEntry(fmt.Sprintf("post%d", i), i))
+	}
+	query := EntryQuery{IsPage: false, Count: 3}
+	query.End = query.end()
+	_, next, err := GetEntries(c, query)
+	if err != nil || next == "" {
+		t.Fatalf("GetEntries = %q, %v", next, err)
+	}
+	cursor := archiveCursor(query.End, next)
+
+	// A post dated before the first page was fetched, but after the
+	// archive's end, doesn't turn up on the page after it.
+	late := testEntry("late", 0)
+	late.PublishDate = query.End.Add(time.Nanosecond)
+	putEntries(t, c, late)
+
+	end, store_cursor, err := parseArchiveCursor(cursor)
+	if err != nil {
+		t.Fatalf("parseArchiveCursor(%q): %v", cursor, err)
+	}
+	if !end.Equal(query.End) || store_cursor != next {
+		t.Errorf("parseArchiveCursor(%q) = %v, %q, want %v, %q", cursor, end, store_cursor, query.End, next)
+	}
+	entries, _, err := GetEntries(c, EntryQuery{IsPage: false, Count: 3, End: end, Cursor: store_cursor})
+	if err != nil {
+		t.Fatalf("GetEntries from cursor: %v", err)
+	}
+	if got, want := slugs(entries), []string{"post4", "post5", "post6"}; !equalStrings(got, want) {
+		t.Errorf("second page is %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"", "nodot", "x.cursor", "123."} {
+		if _, _, err := parseArchiveCursor(bad); err == nil {
+			t.Errorf("parseArchiveCursor(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestPageCacheKeyOnlyTrustsLinkedCursors(t *testing.T) {
+	c := newTestContext("")
+	per_page, _ := config.GetInt("entries_per_page")
+	for i := 0; i < int(per_page)+1; i++ {
+		putEntries(t, c, testEntry(fmt.Sprintf("post%02d", i), i))
+	}
+	_, _, nextURL := getArchivePage(c, EntryQuery{IsPage: false}, 1, "", func(page int) string { return pageURL("/", page) })
+	u, err := url.Parse(nextURL)
+	if err != nil || u.Query().Get("cursor") == "" {
+		t.Fatalf("the first page links to %q", nextURL)
+	}
+
+	plain := pageCacheKey(c, testRequest("GET", "/2", nil, ""))
+	linked := pageCacheKey(c, testRequest("GET", "/2", u.Query(), ""))
+	if plain == "" || linked != plain {
+		t.Errorf("page 2 is cached under %q, and by its linked cursor under %q; want the same key", plain, linked)
+	}
+	for _, cursor := range []string{"1.abc", "garbage"} {
+		if key := pageCacheKey(c, testRequest("GET", "/2", url.Values{"cursor": {cursor}}, "")); key != "" {
+			t.Errorf("page 2 by the cursor %q is cached under %q, want it uncached", cursor, key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Pinned posts, which are shown before the chronological list on the first
+// page of the blog until they are unpinned or their pin expires.
+package blog
+
+import (
+	"time"
+)
+
+// IsPinnedNow returns true if an entry is pinned and its pin hasn't expired.
+func (s *SavedEntry) IsPinnedNow() bool {
+	return s.IsPinned && (s.PinnedUntil.IsZero() || s.PinnedUntil.After(time.Now()))
+}
+
+// GetPinnedEntries retrieves the published posts whose pins haven't expired,
+// newest first.
+func GetPinnedEntries(c Context) (pinned []SavedEntry, err error) {
+	entries, _, err := GetEntries(c, EntryQuery{IsPage: false, PinnedOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsPinnedNow() {
+			pinned = append(pinned, e)
+		}
+	}
+	return pinned, nil
+}
+
+// getFrontPage fetches a page of the front page, and how many pinned posts
+// lead it. Pinned posts lead the first page and take the places of as many
+// others, and are left out of the posts on every page, so none is shown twice.
+func getFrontPage(c Context, pageCount int, cursor string, urlFor func(page int) string) (entries []SavedEntry, previousURL string, nextURL string, pinned_count int) {
+	pinned, err := GetPinnedEntries(c)
+	if err != nil {
+		c.Errorf("Unable to get pinned entries: %v", err)
+	}
+	query := EntryQuery{IsPage: false}
+	for _, e := range pinned {
+		query.ExcludeSlugs = append(query.ExcludeSlugs, e.Slug)
+	}
+	entries, previousURL, nextURL = getArchivePageAfter(c, query, len(pinned), pageCount, cursor, urlFor)
+	if pageCount == 1 {
+		entries = append(pinned, entries...)
+		pinned_count = len(pinned)
+	}
+	return entries, previousURL, nextURL, pinned_count
+}
+
+// UnpinExpired unpins entries whose pins have expired, and flushes the cache
+// so that they leave the front page.
+func UnpinExpired(c Context) (unpinned []SavedEntry, err error) {
+	query := EntryQuery{IsPage: false, PinnedOnly: true, IncludeHidden: true, IncludeScheduled: true}
+	entries, _, err := GetEntries(c, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsPinnedNow() {
+			continue
+		}
+		entry.IsPinned = false
+		if err := PutEntry(c, &entry); err != nil {
+			return unpinned, err
+		}
+		c.Infof("Unpinned %s, pinned until %s", entry.Slug, entry.PinnedUntil)
+		unpinned = append(unpinned, entry)
+	}
+	if len(unpinned) > 0 {
+		c.Cache().Flush()
+	}
+	return unpinned, nil
+}
@@ -0,0 +1,93 @@
+package blog
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// followFrontPage fetches every page of the front page in turn, by the
+// cursors in their next links, or by their page numbers if byNumber.
+func followFrontPage(t *testing.T, c Context, byNumber bool) (pages [][]SavedEntry) {
+	t.Helper()
+	urlFor := func(page int) string { return pageURL("/", page) }
+	cursor := ""
+	for page := 1; page < 100; page++ {
+		if byNumber {
+			c.Cache().Flush()
+			cursor = ""
+		}
+		entries, _, nextURL, _ := getFrontPage(c, page, cursor, urlFor)
+		pages = append(pages, entries)
+		if nextURL == "" {
+			return pages
+		}
+		u, err := url.Parse(nextURL)
+		if err != nil {
+			t.Fatalf("page %d links to %q: %v", page, nextURL, err)
+		}
+		cursor = u.Query().Get("cursor")
+	}
+	t.Fatalf("front page never ended")
+	return nil
+}
+
+func TestFrontPageCountsPinnedPosts(t *testing.T) {
+	c := newTestContext("")
+	per_page, _ := config.GetInt("entries_per_page")
+	total := int(per_page)*2 + 1
+	for i := 0; i < total; i++ {
+		e := testEntry(fmt.Sprintf("post%02d", i), i)
+		// One pinned post is on the first page anyway, one is further back,
+		// and one's pin has expired.
+		if i == 1 || i == total-2 {
+			e.IsPinned = true
+		}
+		if i == 3 {
+			e.IsPinned = true
+			e.PinnedUntil = time.Now().Add(-time.Hour)
+		}
+		putEntries(t, c, e)
+	}
+
+	for _, byNumber := range []bool{false, true} {
+		pages := followFrontPage(t, c, byNumber)
+		if len(pages) != 3 {
+			t.Fatalf("by number %t: got %d pages, want 3", byNumber, len(pages))
+		}
+		first := slugs(pages[0])
+		if len(first) != int(per_page) || first[0] != "post01" || first[1] != fmt.Sprintf("post%02d", total-2) {
+			t.Errorf("by number %t: first page is %v, want %d entries led by the pinned ones", byNumber, first, per_page)
+		}
+		seen := make(map[string]bool)
+		for i, page := range pages {
+			for _, e := range page {
+				if seen[e.Slug] {
+					t.Errorf("by number %t: %s is shown again on page %d", byNumber, e.Slug, i+1)
+				}
+				seen[e.Slug] = true
+			}
+		}
+		if len(seen) != total {
+			t.Errorf("by number %t: saw %d entries, want %d", byNumber, len(seen), total)
+		}
+	}
+}
+
+func TestFrontPageShowsAPostAfterManyPins(t *testing.T) {
+	c := newTestContext("")
+	per_page, _ := config.GetInt("entries_per_page")
+	for i := 0; i < int(per_page)+2; i++ {
+		e := testEntry(fmt.Sprintf("post%02d", i), i)
+		e.IsPinned = i > 0
+		putEntries(t, c, e)
+	}
+	entries, _, nextURL, pinned_count := getFrontPage(c, 1, "", func(page int) string { return pageURL("/", page) })
+	if pinned_count != int(per_page)+1 || len(entries) != pinned_count+1 || entries[pinned_count].Slug != "post00" {
+		t.Errorf("first page is %v with %d pinned, want every pinned post and then post00", slugs(entries), pinned_count)
+	}
+	if nextURL != "" {
+		t.Errorf("first page links to %q, with nothing after it", nextURL)
+	}
+}
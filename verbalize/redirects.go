@@ -0,0 +1,74 @@
+// Renaming entries: the old URL keeps working by redirecting to the new one.
+package blog
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Most renames followed when resolving an old slug.
+	MAX_REDIRECT_HOPS = 10
+)
+
+// Redirect struct, stored in Datastore and keyed by OldSlug. It is left behind
+// when an entry is renamed.
+type SavedRedirect struct {
+	OldSlug        string
+	OldRelativeURL string
+	// Slug the entry was renamed to.
+	Slug string
+	Date time.Time
+}
+
+// GetRedirect retrieves the redirect left behind by an old slug
+func GetRedirect(c Context, oldSlug string) (SavedRedirect, error) {
+	return c.Store().GetRedirect(oldSlug)
+}
+
+// MoveEntry finishes renaming an entry, once it has been saved under its new
+// slug: the old one is removed, its history follows it, and a redirect is left
+// at the old URL.
+func MoveEntry(c Context, old *SavedEntry, entry *SavedEntry) error {
+	redirect := SavedRedirect{
+		OldSlug:        old.Slug,
+		OldRelativeURL: old.RelativeURL,
+		Slug:           entry.Slug,
+		Date:           time.Now(),
+	}
+	if err := c.Store().PutRedirect(&redirect); err != nil {
+		return err
+	}
+	// An entry that takes back an old slug no longer needs its redirect.
+	if err := c.Store().DeleteRedirect(entry.Slug); err != nil && err != ErrNoSuchEntity {
+		return err
+	}
+
+	if err := moveRevisions(c, old.Slug, entry.Slug); err != nil {
+		return err
+	}
+	if err := UnindexEntry(c, old.Slug); err != nil {
+		return err
+	}
+	c.Infof("Renamed %s to %s", old.Slug, entry.Slug)
+	return c.Store().DeleteEntry(old.Slug)
+}
+
+// resolveRedirect follows the renames of an old slug to the entry it is now,
+// if there is one.
+func resolveRedirect(c Context, oldSlug string) (entry SavedEntry, err error) {
+	slug := oldSlug
+	for i := 0; i < MAX_REDIRECT_HOPS; i++ {
+		redirect, err := GetRedirect(c, slug)
+		if err != nil {
+			return entry, err
+		}
+		entry, err = GetSingleEntry(c, redirect.Slug)
+		if err == nil {
+			return entry, nil
+		}
+		// The entry may have been renamed again since.
+		slug = redirect.Slug
+	}
+	return entry, fmt.Errorf("too many redirects from %s", oldSlug)
+}
@@ -0,0 +1,77 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// renameEntry renames the entry slug to new_slug through the edit form.
+func renameEntry(t *testing.T, c Context, slug string, new_slug string) SavedEntry {
+	t.Helper()
+	entry, err := c.Store().GetSingleEntry(slug)
+	if err != nil {
+		t.Fatalf("GetSingleEntry(%s): %v", slug, err)
+	}
+	return submitEntry(t, c, "editor", url.Values{
+		"title":         {entry.Title},
+		"slug":          {new_slug},
+		"original_slug": {slug},
+		"version":       {strconv.FormatInt(entry.Version, 10)},
+		"content":       {string(entry.Content)},
+		"status":        {STATUS_PUBLISHED},
+	})
+}
+
+func TestRenamingAnEntryLeavesARedirect(t *testing.T) {
+	c := newTestContext("editor")
+	first := submitEntry(t, c, "editor", url.Values{
+		"title":       {"Named"},
+		"slug":        {"first-name"},
+		"content":     {"Text."},
+		"status":      {STATUS_PUBLISHED},
+		"is_new_post": {"1"},
+	})
+	renameEntry(t, c, "first-name", "second-name")
+	third := renameEntry(t, c, "second-name", "third-name")
+
+	for _, slug := range []string{"first-name", "second-name"} {
+		if _, err := c.Store().GetSingleEntry(slug); err != ErrNoSuchEntity {
+			t.Errorf("GetSingleEntry(%s) after renaming error = %v, want ErrNoSuchEntity", slug, err)
+		}
+	}
+	if entry, err := resolveRedirect(c, "first-name"); err != nil || entry.Slug != "third-name" {
+		t.Errorf("resolveRedirect(first-name) = %s, %v; want third-name", entry.Slug, err)
+	}
+	if revisions, _ := GetRevisions(c, "third-name"); len(revisions) != 3 {
+		t.Errorf("third-name has %d revisions, want its history of 3", len(revisions))
+	}
+
+	w := serve(rootHandler, "GET", "/"+first.RelativeURL, nil, "")
+	if w.Code != http.StatusMovedPermanently || !strings.HasSuffix(w.Header().Get("Location"), third.RelativeURL) {
+		t.Errorf("GET of the first URL gave %d to %q, want a permanent redirect to %s", w.Code, w.Header().Get("Location"), third.RelativeURL)
+	}
+
+	// Taking back an old slug removes its redirect.
+	renameEntry(t, c, "third-name", "first-name")
+	if _, err := GetRedirect(c, "first-name"); err != ErrNoSuchEntity {
+		t.Errorf("GetRedirect(first-name) after taking it back error = %v, want ErrNoSuchEntity", err)
+	}
+	if entry, err := resolveRedirect(c, "second-name"); err != nil || entry.Slug != "first-name" {
+		t.Errorf("resolveRedirect(second-name) = %s, %v; want first-name", entry.Slug, err)
+	}
+}
+
+func TestResolveRedirectGivesUpOnLoops(t *testing.T) {
+	c := newTestContext("")
+	for _, r := range []SavedRedirect{{OldSlug: "a", Slug: "b"}, {OldSlug: "b", Slug: "a"}} {
+		if err := c.Store().PutRedirect(&r); err != nil {
+			t.Fatalf("PutRedirect: %v", err)
+		}
+	}
+	if _, err := resolveRedirect(c, "a"); err == nil {
+		t.Errorf("resolveRedirect followed a loop")
+	}
+}
@@ -0,0 +1,153 @@
+// Related posts, found by how alike entries' words are and worked out when
+// an entry is indexed, so that showing them costs no more than fetching them.
+package blog
+
+import (
+	"html/template"
+	"math"
+	"sort"
+)
+
+const (
+	// How many of an entry's most frequent terms it is compared by. Each
+	// is a query, so this is what indexing an entry costs.
+	RELATED_QUERY_TERMS = 25
+	// How many related posts are kept for each entry, and how many are shown.
+	// More are kept in case some are unpublished by the time they are shown.
+	MAX_RELATED_STORED = 10
+	MAX_RELATED_SHOWN  = 5
+)
+
+// A candidate related post, and how alike it is.
+type relatedScore struct {
+	Slug  string
+	Score float64
+}
+
+// relatedByScore sorts candidates most alike first, and then by slug so that
+// ties always come out the same way.
+type relatedByScore []relatedScore
+
+func (r relatedByScore) Len() int      { return len(r) }
+func (r relatedByScore) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r relatedByScore) Less(i, j int) bool {
+	if r[i].Score != r[j].Score {
+		return r[i].Score > r[j].Score
+	}
+	return r[i].Slug < r[j].Slug
+}
+
+// termsByWeight sorts the terms of a document most frequent first.
+type termsByWeight struct {
+	terms   []string
+	weights map[string]int
+}
+
+func (t termsByWeight) Len() int      { return len(t.terms) }
+func (t termsByWeight) Swap(i, j int) { t.terms[i], t.terms[j] = t.terms[j], t.terms[i] }
+func (t termsByWeight) Less(i, j int) bool {
+	if t.weights[t.terms[i]] != t.weights[t.terms[j]] {
+		return t.weights[t.terms[i]] > t.weights[t.terms[j]]
+	}
+	return t.terms[i] < t.terms[j]
+}
+
+// termFrequency dampens a term's weight, so that a word used twenty times
+// doesn't count for twenty times one used once.
+func termFrequency(weight int) float64 {
+	return 1 + math.Log(float64(weight))
+}
+
+// length returns the length of a document's vector of term frequencies.
+func (d *SearchDocument) length() float64 {
+	var sum float64
+	for _, w := range d.Weights {
+		tf := termFrequency(w)
+		sum += tf * tf
+	}
+	return math.Sqrt(sum)
+}
+
+// findRelated returns the slugs of the posts most alike a search document,
+// best first. Posts are compared by the cosine similarity of their TF-IDF
+// vectors over the document's most frequent terms; as the IDF of every other
+// term isn't known, the length of a post's vector is taken from its term
+// frequencies alone.
+func findRelated(c Context, doc *SearchDocument) ([]string, error) {
+	total, err := c.Store().CountSearchDocuments()
+	if err != nil || total == 0 {
+		return nil, err
+	}
+
+	weights := make(map[string]int)
+	for i, term := range doc.Terms {
+		if i < len(doc.Weights) {
+			weights[term] = doc.Weights[i]
+		}
+	}
+	query := termsByWeight{append([]string(nil), doc.Terms...), weights}
+	sort.Sort(query)
+	if len(query.terms) > RELATED_QUERY_TERMS {
+		query.terms = query.terms[:RELATED_QUERY_TERMS]
+	}
+
+	scores := make(map[string]float64)
+	candidates := make(map[string]*SearchDocument)
+	for _, term := range query.terms {
+		docs, err := c.Store().FindSearchDocuments(term)
+		if err != nil {
+			return nil, err
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		idf := math.Log(float64(total) / float64(len(docs)))
+		for i := range docs {
+			other := &docs[i]
+			if other.Slug == doc.Slug || other.IsPage {
+				continue
+			}
+			candidates[other.Slug] = other
+			scores[other.Slug] += termFrequency(weights[term]) * termFrequency(other.weight(term)) * idf * idf
+		}
+	}
+
+	var ranked []relatedScore
+	for slug, score := range scores {
+		if length := candidates[slug].length(); score > 0 && length > 0 {
+			ranked = append(ranked, relatedScore{Slug: slug, Score: score / length})
+		}
+	}
+	sort.Sort(relatedByScore(ranked))
+
+	var related []string
+	for _, r := range ranked {
+		if len(related) == MAX_RELATED_STORED {
+			break
+		}
+		related = append(related, r.Slug)
+	}
+	return related, nil
+}
+
+// RelatedEntries returns links to the published posts related to an entry,
+// as found when it was last indexed.
+func RelatedEntries(c Context, slug string) (links []EntryLink, err error) {
+	doc, err := c.Store().GetSearchDocument(slug)
+	if err == ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, related := range doc.Related {
+		if len(links) == MAX_RELATED_SHOWN {
+			break
+		}
+		entry, err := GetSingleEntry(c, related)
+		if err != nil || entry.IsPage || entry.IsHidden || !entry.IsPublished() {
+			continue
+		}
+		links = append(links, EntryLink{Title: template.HTML(entry.Title), RelativeURL: entry.RelativeURL})
+	}
+	return links, nil
+}
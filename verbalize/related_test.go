@@ -0,0 +1,121 @@
+package blog
+
+import (
+	"fmt"
+	"testing"
+)
+
+// wordyEntry returns a post about words, titled by its slug.
+func wordyEntry(slug string, days int, words string) SavedEntry {
+	e := testEntry(slug, days)
+	e.Content = []byte("<p>" + words + "</p>")
+	return e
+}
+
+// relatedTo returns the related posts stored for slug.
+func relatedTo(t *testing.T, c Context, slug string) []string {
+	t.Helper()
+	d, err := c.Store().GetSearchDocument(slug)
+	if err != nil {
+		t.Fatalf("GetSearchDocument(%s): %v", slug, err)
+	}
+	return d.Related
+}
+
+func TestFindRelatedPrefersRareTerms(t *testing.T) {
+	c := newTestContext("admin")
+	entries := []SavedEntry{
+		wordyEntry("target", 1, "bicycle commute"),
+		wordyEntry("shares-rare", 2, "bicycle"),
+		wordyEntry("shares-common", 3, "commute"),
+	}
+	for i := 0; i < 5; i++ {
+		entries = append(entries, wordyEntry(fmt.Sprintf("filler%d", i), 4, "commute weather"))
+	}
+	if err := indexEntries(c, entries); err != nil {
+		t.Fatalf("indexEntries: %v", err)
+	}
+	related := relatedTo(t, c, "target")
+	if len(related) == 0 || related[0] != "shares-rare" {
+		t.Errorf("related to target = %v, want shares-rare first", related)
+	}
+}
+
+func TestFindRelatedLeavesOutPagesAndItself(t *testing.T) {
+	c := newTestContext("admin")
+	page := wordyEntry("page", 1, "bicycle wheels")
+	page.IsPage = true
+	unlike := wordyEntry("unlike", 3, "soup recipes")
+	if err := indexEntries(c, []SavedEntry{wordyEntry("post", 1, "bicycle wheels"), page, wordyEntry("other", 2, "bicycle"), unlike}); err != nil {
+		t.Fatalf("indexEntries: %v", err)
+	}
+	if related := relatedTo(t, c, "post"); !equalStrings(related, []string{"other"}) {
+		t.Errorf("related to post = %v, want [other]", related)
+	}
+	if related := relatedTo(t, c, "page"); len(related) != 0 {
+		t.Errorf("related to a page = %v, want none", related)
+	}
+}
+
+func TestIndexEntryRelatesOlderPostsToNewerOnes(t *testing.T) {
+	c := newTestContext("admin")
+	// A term every post has tells them apart no better than none.
+	unlike := wordyEntry("unlike", 9, "soup recipes")
+	if err := IndexEntry(c, &unlike); err != nil {
+		t.Fatalf("IndexEntry(unlike): %v", err)
+	}
+	older := wordyEntry("older", 5, "bicycle wheels spokes")
+	if err := IndexEntry(c, &older); err != nil {
+		t.Fatalf("IndexEntry(older): %v", err)
+	}
+	if related := relatedTo(t, c, "older"); len(related) != 0 {
+		t.Errorf("related to older = %v before there was anything else", related)
+	}
+	newer := wordyEntry("newer", 1, "bicycle wheels")
+	if err := IndexEntry(c, &newer); err != nil {
+		t.Fatalf("IndexEntry(newer): %v", err)
+	}
+	if related := relatedTo(t, c, "older"); !equalStrings(related, []string{"newer"}) {
+		t.Errorf("related to older = %v, want [newer]", related)
+	}
+
+	// Rewritten to be about something else, it goes from older's.
+	newer.Content = []byte("<p>cheese sandwiches</p>")
+	if err := IndexEntry(c, &newer); err != nil {
+		t.Fatalf("IndexEntry(newer): %v", err)
+	}
+	if related := relatedTo(t, c, "older"); len(related) != 0 {
+		t.Errorf("related to older = %v, want none after newer changed", related)
+	}
+}
+
+func TestIndexEntriesDoesntDependOnOrder(t *testing.T) {
+	entries := []SavedEntry{
+		wordyEntry("one", 1, "bicycle wheels spokes"),
+		wordyEntry("two", 2, "bicycle wheels saddle"),
+		wordyEntry("three", 3, "saddle leather"),
+		wordyEntry("four", 4, "leather boots rain"),
+		wordyEntry("five", 5, "rain bicycle"),
+	}
+	var reversed []SavedEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		reversed = append(reversed, entries[i])
+	}
+
+	forwards := newTestContext("admin")
+	if err := indexEntries(forwards, entries); err != nil {
+		t.Fatalf("indexEntries: %v", err)
+	}
+	backwards := newTestContext("admin")
+	if err := indexEntries(backwards, reversed); err != nil {
+		t.Fatalf("indexEntries: %v", err)
+	}
+	for _, e := range entries {
+		if a, b := relatedTo(t, forwards, e.Slug), relatedTo(t, backwards, e.Slug); !equalStrings(a, b) {
+			t.Errorf("related to %s = %v indexed one way and %v the other", e.Slug, a, b)
+		}
+	}
+	if related := relatedTo(t, forwards, "five"); len(related) == 0 {
+		t.Errorf("the first post indexed has no related posts")
+	}
+}
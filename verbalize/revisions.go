@@ -0,0 +1,210 @@
+// Revision history for entries: every save is kept, and can be compared or restored.
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Revision struct, stored in Datastore. Revisions are never modified once saved.
+type SavedRevision struct {
+	// Given by the store when the revision is first saved.
+	ID      int64
+	Slug    string
+	Author  string
+	Date    time.Time
+	Title   string
+	Content []byte
+	// Format of Content. Empty in revisions saved before it was kept.
+	Format string
+}
+
+// newRevision returns a revision recording the current state of an entry.
+func newRevision(e *SavedEntry, author string, date time.Time) SavedRevision {
+	format := e.Format
+	if format == "" {
+		format = FORMAT_HTML
+	}
+	return SavedRevision{
+		Slug:    e.Slug,
+		Author:  author,
+		Date:    date,
+		Title:   e.Title,
+		Content: e.Content,
+		Format:  format,
+	}
+}
+
+// GetRevisions retrieves the revisions of an entry, newest first
+func GetRevisions(c Context, slug string) ([]SavedRevision, error) {
+	return c.Store().GetRevisions(slug)
+}
+
+// GetRevision retrieves a single revision by ID
+func GetRevision(c Context, id int64) (SavedRevision, error) {
+	return c.Store().GetRevision(id)
+}
+
+// PutRevision saves a revision to the store
+func PutRevision(c Context, rev *SavedRevision) error {
+	return c.Store().PutRevision(rev)
+}
+
+// moveRevisions moves the history kept under one slug to another.
+func moveRevisions(c Context, from string, to string) error {
+	revisions, err := GetRevisions(c, from)
+	if err != nil {
+		return err
+	}
+	for _, rev := range revisions {
+		rev.Slug = to
+		if err := PutRevision(c, &rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveEntryRevision stores a new revision for an entry that was just saved by
+// the current user. Entries that predate revision history get their previous
+// text recorded first, so that it is not lost.
+func saveEntryRevision(c Context, e *SavedEntry, previous *SavedEntry) error {
+	if previous != nil {
+		if revs, err := GetRevisions(c, previous.Slug); err == nil && len(revs) == 0 {
+			rev := newRevision(previous, previous.Author, previous.PublishDate)
+			if err := PutRevision(c, &rev); err != nil {
+				return err
+			}
+		}
+	}
+	rev := newRevision(e, c.CurrentUser(), time.Now())
+	return PutRevision(c, &rev)
+}
+
+// mayReadHistory returns true if the current user may see the revisions of
+// the entry at slug. The history of an entry that is gone is only for editors.
+func mayReadHistory(c Context, slug string) bool {
+	entry, err := GetSingleEntry(c, slug)
+	if err != nil {
+		return hasRole(c, ROLE_EDITOR)
+	}
+	return mayTouch(c, &entry)
+}
+
+// handler for /admin/revisions - lists the revisions of an entry
+func adminRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	slug := strings.TrimSpace(r.FormValue("slug"))
+	c := contextFor(r)
+
+	entry, err := GetSingleEntry(c, slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !mayTouch(c, &entry) {
+		http.Error(w, "Contributors can only see the history of their own entries.", http.StatusForbidden)
+		return
+	}
+	revisions, err := GetRevisions(c, slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	context, _ := GetTemplateContext([]SavedEntry{entry}, nil, "Revisions", "admin_revisions", r)
+	context.Revisions = revisions
+	renderTemplate(w, *adminRevisionsTpl, context)
+}
+
+// handler for /admin/diff - compares two revisions
+func adminDiffHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	from_id, _ := strconv.ParseInt(r.FormValue("from"), 10, 64)
+	to_id, _ := strconv.ParseInt(r.FormValue("to"), 10, 64)
+
+	from, err := GetRevision(c, from_id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("revision %d: %v", from_id, err), http.StatusNotFound)
+		return
+	}
+	to, err := GetRevision(c, to_id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("revision %d: %v", to_id, err), http.StatusNotFound)
+		return
+	}
+	if from.Slug != to.Slug {
+		http.Error(w, "Only revisions of the same entry can be compared.", http.StatusBadRequest)
+		return
+	}
+	if !mayReadHistory(c, from.Slug) {
+		http.Error(w, "Contributors can only see the history of their own entries.", http.StatusForbidden)
+		return
+	}
+	// Always show the change going forward in time.
+	if from.Date.After(to.Date) {
+		from, to = to, from
+	}
+
+	context, _ := GetTemplateContext(nil, nil, "Diff", "admin_revisions", r)
+	context.Revisions = []SavedRevision{from, to}
+	context.TitleDiff = Diff(from.Title, to.Title, true)
+	context.Diff = Diff(string(from.Content), string(to.Content), r.FormValue("mode") == "word")
+	renderTemplate(w, *adminDiffTpl, context)
+}
+
+// handler for /admin/restore_revision - makes an old revision current again
+func adminRestoreRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Restoring a revision requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+
+	rev, err := GetRevision(c, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	entry, err := GetSingleEntry(c, rev.Slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !canEdit(c, &entry) {
+		http.Error(w, "Only editors can change published entries, or other people's", http.StatusForbidden)
+		return
+	}
+	previous := entry
+	entry.Title = rev.Title
+	entry.Content = rev.Content
+	if rev.Format != "" {
+		entry.Format = rev.Format
+	}
+	entry.UpdatedDate = time.Now()
+	// Anyone still editing the version being replaced hears about it, and so
+	// do we if someone saved the entry since we loaded it.
+	if err := UpdateEntry(c, &entry, previous.Version); err == ErrVersionConflict {
+		if current, current_err := GetSingleEntry(c, entry.Slug); current_err == nil {
+			renderEditConflict(w, r, &entry, &current, entry.Slug)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := saveEntryRevision(c, &entry, &previous); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := IndexEntry(c, &entry); err != nil {
+		c.Errorf("error indexing %s: %v", entry.Slug, err)
+	}
+	c.Infof("Restored %s to revision %d", entry.Slug, rev.ID)
+	c.Cache().Flush()
+	http.Redirect(w, r, fmt.Sprintf("/admin/revisions?slug=%s", entry.Slug), http.StatusFound)
+}
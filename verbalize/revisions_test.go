@@ -0,0 +1,123 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestRevisionsGetDistinctIDs(t *testing.T) {
+	c := newTestContext("editor")
+	// Entries published at the same instant used to give their first
+	// revisions the same ID.
+	first, second := testEntry("first", 1), testEntry("second", 1)
+	second.PublishDate = first.PublishDate
+	putEntries(t, c, first, second)
+
+	for _, e := range []SavedEntry{first, second} {
+		edited := e
+		edited.Content = []byte("edited")
+		if err := saveEntryRevision(c, &edited, &e); err != nil {
+			t.Fatalf("saveEntryRevision(%s): %v", e.Slug, err)
+		}
+	}
+	ids := make(map[int64]bool)
+	for _, slug := range []string{"first", "second"} {
+		revisions, err := GetRevisions(c, slug)
+		if err != nil {
+			t.Fatalf("GetRevisions(%s): %v", slug, err)
+		}
+		if len(revisions) != 2 {
+			t.Fatalf("got %d revisions of %s, want 2", len(revisions), slug)
+		}
+		for _, rev := range revisions {
+			if rev.ID == 0 || ids[rev.ID] {
+				t.Errorf("revision of %s has ID %d, which is zero or taken", slug, rev.ID)
+			}
+			ids[rev.ID] = true
+		}
+	}
+}
+
+func TestRevisionHistoryIsOnlyForThoseWhoMayTouchTheEntry(t *testing.T) {
+	c := newTestContext("")
+	putAuthor(t, c, "alice", ROLE_CONTRIBUTOR)
+	putAuthor(t, c, "bob", ROLE_CONTRIBUTOR)
+	putAuthor(t, c, "ed", ROLE_EDITOR)
+	draft := testEntry("alices-draft", 1)
+	draft.Author = "alice"
+	draft.setStatus(STATUS_DRAFT)
+	putEntries(t, c, draft)
+	edited := draft
+	edited.Content = []byte("second thoughts")
+	if err := saveEntryRevision(c, &edited, &draft); err != nil {
+		t.Fatalf("saveEntryRevision: %v", err)
+	}
+	revisions, _ := GetRevisions(c, draft.Slug)
+	diff := url.Values{
+		"from": {strconv.FormatInt(revisions[0].ID, 10)},
+		"to":   {strconv.FormatInt(revisions[1].ID, 10)},
+	}
+
+	for _, tt := range []struct {
+		user string
+		want int
+	}{
+		{"alice", http.StatusOK},
+		{"ed", http.StatusOK},
+		{"bob", http.StatusForbidden},
+	} {
+		w := serve(adminRevisionsHandler, "GET", "/admin/revisions", url.Values{"slug": {draft.Slug}}, tt.user)
+		if w.Code != tt.want {
+			t.Errorf("revisions for %s: got %d, want %d", tt.user, w.Code, tt.want)
+		}
+		w = serve(adminDiffHandler, "GET", "/admin/diff", diff, tt.user)
+		if w.Code != tt.want {
+			t.Errorf("diff for %s: got %d, want %d", tt.user, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRestoringARevisionRestoresItsFormat(t *testing.T) {
+	c := newTestContext("editor")
+	written := testEntry("formats", 1)
+	written.Format = FORMAT_MARKDOWN
+	written.Content = []byte("*markdown*")
+	putEntries(t, c, written)
+	if err := saveEntryRevision(c, &written, nil); err != nil {
+		t.Fatalf("saveEntryRevision: %v", err)
+	}
+	rewritten, _ := c.Store().GetSingleEntry("formats")
+	rewritten.Format = ""
+	rewritten.Content = []byte("<p>html</p>")
+	if err := c.Store().UpdateEntry(&rewritten, rewritten.Version); err != nil {
+		t.Fatalf("UpdateEntry: %v", err)
+	}
+	if err := saveEntryRevision(c, &rewritten, &written); err != nil {
+		t.Fatalf("saveEntryRevision: %v", err)
+	}
+	revisions, _ := GetRevisions(c, "formats")
+	if len(revisions) != 2 || revisions[0].Format != FORMAT_HTML || revisions[1].Format != FORMAT_MARKDOWN {
+		t.Fatalf("got revisions %+v, want an HTML one after a Markdown one", revisions)
+	}
+
+	form := url.Values{"id": {strconv.FormatInt(revisions[1].ID, 10)}}
+	if w := serve(adminRestoreRevisionHandler, "POST", "/admin/restore_revision", form, "editor"); w.Code != http.StatusFound {
+		t.Fatalf("restoring a revision gave %d", w.Code)
+	}
+	if restored, _ := c.Store().GetSingleEntry("formats"); restored.Format != FORMAT_MARKDOWN || string(restored.Content) != "*markdown*" {
+		t.Errorf("restored %q as %q, want *markdown* as Markdown", restored.Content, restored.Format)
+	}
+
+	// Revisions from before their format was kept leave the entry's alone.
+	legacy := SavedRevision{Slug: "formats", Title: "Legacy", Content: []byte("*old*")}
+	if err := PutRevision(c, &legacy); err != nil {
+		t.Fatalf("PutRevision: %v", err)
+	}
+	form.Set("id", strconv.FormatInt(legacy.ID, 10))
+	serve(adminRestoreRevisionHandler, "POST", "/admin/restore_revision", form, "editor")
+	if restored, _ := c.Store().GetSingleEntry("formats"); restored.Format != FORMAT_MARKDOWN || string(restored.Content) != "*old*" {
+		t.Errorf("restored %q as %q, want *old* as Markdown", restored.Content, restored.Format)
+	}
+}
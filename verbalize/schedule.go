@@ -0,0 +1,108 @@
+// Scheduled publishing: entries with a future PublishDate go live on their own.
+package blog
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// Format of the publish date field in the edit form (HTML datetime-local).
+	PUBLISH_DATE_FORMAT = "2006-01-02T15:04"
+)
+
+var (
+	// Time zone that publish dates are shown and entered in.
+	location = loadLocation()
+)
+
+// loadLocation returns the configured time zone, or UTC if there is none.
+func loadLocation() *time.Location {
+	name, err := config.Get("timezone")
+	if err != nil || name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unable to load timezone %s, using UTC: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// parsePublishDate parses the publish date field of the edit form. An empty
+// field returns the zero time.
+func parsePublishDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(PUBLISH_DATE_FORMAT, value, location)
+}
+
+// end returns the latest PublishDate a query may return, or the zero time if
+// there is no limit.
+func (params EntryQuery) end() time.Time {
+	if params.IncludeScheduled {
+		return params.End
+	}
+	now := time.Now()
+	if params.End.IsZero() || params.End.After(now) {
+		return now
+	}
+	return params.End
+}
+
+// IsPublished returns true if an entry's PublishDate has arrived.
+func (s *SavedEntry) IsPublished() bool {
+	return !s.PublishDate.After(time.Now())
+}
+
+// PublishScheduled marks scheduled entries whose time has come as published,
+// and flushes the cache so that they appear on the front page, feed and archive.
+func PublishScheduled(c Context) (published []SavedEntry, err error) {
+	for _, is_page := range []bool{false, true} {
+		// Without IncludeScheduled, only entries whose time has come are returned.
+		query := EntryQuery{IsPage: is_page, IncludeHidden: true, ScheduledOnly: true}
+		entries, _, err := GetEntries(c, query)
+		if err != nil {
+			return published, err
+		}
+		for _, entry := range entries {
+			if entry.CurrentStatus() == STATUS_SCHEDULED {
+				entry.setStatus(STATUS_PUBLISHED)
+			} else {
+				// Hidden before there was a Status: it stays hidden.
+				entry.IsScheduled = false
+			}
+			if err := PutEntry(c, &entry); err != nil {
+				return published, err
+			}
+			c.Infof("Published scheduled entry %s (%s)", entry.Slug, entry.PublishDate)
+			published = append(published, entry)
+		}
+	}
+	if len(published) > 0 {
+		// Cached archive pages, tag pages and feeds could all include the entry.
+		c.Cache().Flush()
+	}
+	return published, nil
+}
+
+// HTTP handler for /cron/publish, run by cron.yaml. Expired pins come off
+// the front page here too, as they are also entries whose time has come.
+func cronPublishHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	published, err := PublishScheduled(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	unpinned, err := UnpinExpired(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Published %d scheduled entries, unpinned %d expired ones\n", len(published), len(unpinned))
+}
@@ -0,0 +1,129 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scheduledEntry returns an entry scheduled for days from now, which may be
+// negative for one whose time has already come.
+func scheduledEntry(slug string, days int) SavedEntry {
+	e := testEntry(slug, -days)
+	e.Status = STATUS_SCHEDULED
+	e.IsScheduled = true
+	return e
+}
+
+func TestParsePublishDate(t *testing.T) {
+	if date, err := parsePublishDate(""); err != nil || !date.IsZero() {
+		t.Errorf("parsePublishDate(\"\") = %s, %v, want the zero time", date, err)
+	}
+	date, err := parsePublishDate("2026-03-01T09:30")
+	if err != nil {
+		t.Fatalf("parsePublishDate: %v", err)
+	}
+	if want := time.Date(2026, 3, 1, 9, 30, 0, 0, location); !date.Equal(want) {
+		t.Errorf("parsePublishDate = %s, want %s", date, want)
+	}
+	if _, err := parsePublishDate("March 1st"); err == nil {
+		t.Errorf("parsePublishDate(\"March 1st\") returned no error")
+	}
+}
+
+func TestFutureEntriesAreScheduled(t *testing.T) {
+	c := newTestContext("editor")
+	publish := time.Now().In(location).Add(48 * time.Hour)
+	entry := submitEntry(t, c, "editor", url.Values{
+		"title":        {"Later"},
+		"slug":         {"later"},
+		"content":      {"Not yet."},
+		"status":       {STATUS_PUBLISHED},
+		"publish_date": {publish.Format(PUBLISH_DATE_FORMAT)},
+		"is_new_post":  {"1"},
+	})
+	if entry.CurrentStatus() != STATUS_SCHEDULED {
+		t.Errorf("status = %s, want %s", entry.CurrentStatus(), STATUS_SCHEDULED)
+	}
+	if entry.IsPublished() {
+		t.Errorf("entry for %s is already published", entry.PublishDate)
+	}
+	entries, _, err := GetEntries(c, EntryQuery{})
+	if err != nil {
+		t.Fatalf("GetEntries: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("GetEntries = %v, want the scheduled entry left out", slugs(entries))
+	}
+}
+
+func TestPublishScheduled(t *testing.T) {
+	c := newTestContext("")
+	// Hidden before there was a Status, with a date that has passed.
+	legacy := testEntry("legacy", 1)
+	legacy.Status = ""
+	legacy.IsHidden = true
+	legacy.IsScheduled = true
+	putEntries(t, c, testEntry("published", 3), scheduledEntry("due", -1), scheduledEntry("later", 1), legacy)
+	c.Cache().Set("page", []byte("stale"), 0)
+
+	published, err := PublishScheduled(c)
+	if err != nil {
+		t.Fatalf("PublishScheduled: %v", err)
+	}
+	if got, want := slugs(published), []string{"due", "legacy"}; !equalStrings(got, want) {
+		t.Errorf("PublishScheduled published %v, want %v", got, want)
+	}
+	if _, err := c.Cache().Get("page"); err == nil {
+		t.Errorf("the cache wasn't flushed after publishing")
+	}
+
+	want := map[string]string{
+		"published": STATUS_PUBLISHED,
+		"due":       STATUS_PUBLISHED,
+		"later":     STATUS_SCHEDULED,
+		"legacy":    STATUS_DRAFT,
+	}
+	for slug, status := range want {
+		entry, err := c.Store().GetSingleEntry(slug)
+		if err != nil {
+			t.Fatalf("GetSingleEntry(%s): %v", slug, err)
+		}
+		if entry.CurrentStatus() != status {
+			t.Errorf("%s has status %s, want %s", slug, entry.CurrentStatus(), status)
+		}
+	}
+	entries, _, err := GetEntries(c, EntryQuery{})
+	if err != nil {
+		t.Fatalf("GetEntries: %v", err)
+	}
+	if got, want := slugs(entries), []string{"due", "published"}; !equalStrings(got, want) {
+		t.Errorf("GetEntries = %v, want %v", got, want)
+	}
+
+	// Nothing else is due, so nothing is published or flushed a second time.
+	c.Cache().Set("page", []byte("fresh"), 0)
+	if published, err := PublishScheduled(c); err != nil || len(published) != 0 {
+		t.Errorf("PublishScheduled again = %v, %v, want nothing", slugs(published), err)
+	}
+	if _, err := c.Cache().Get("page"); err != nil {
+		t.Errorf("the cache was flushed with nothing published: %v", err)
+	}
+}
+
+func TestCronPublishHandler(t *testing.T) {
+	c := newTestContext("")
+	putEntries(t, c, scheduledEntry("due", -1), scheduledEntry("later", 1))
+	w := serve(cronPublishHandler, "GET", "/cron/publish", nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("/cron/publish gave %d: %s", w.Code, w.Body)
+	}
+	if !strings.HasPrefix(w.Body.String(), "Published 1 scheduled entries") {
+		t.Errorf("/cron/publish said %q", w.Body)
+	}
+	if entry, _ := c.Store().GetSingleEntry("due"); entry.CurrentStatus() != STATUS_PUBLISHED {
+		t.Errorf("due has status %s, want %s", entry.CurrentStatus(), STATUS_PUBLISHED)
+	}
+}
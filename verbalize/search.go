@@ -0,0 +1,341 @@
+// Full-text search over entry titles and content.
+package blog
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// A word in the title counts as much as this many in the content.
+	SEARCH_TITLE_WEIGHT = 5
+	// Most results a search returns, across all of its pages.
+	MAX_SEARCH_RESULTS = 100
+)
+
+var (
+	// regexp matching an HTML tag
+	html_tag_re = regexp.MustCompile(`<[^>]*>`)
+
+	// Words too common to be worth indexing.
+	stop_words = map[string]bool{
+		"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+		"be": true, "but": true, "by": true, "for": true, "from": true, "has": true,
+		"have": true, "i": true, "in": true, "is": true, "it": true, "its": true,
+		"of": true, "on": true, "or": true, "that": true, "the": true, "this": true,
+		"to": true, "was": true, "were": true, "with": true,
+	}
+)
+
+// Search index document for an entry, stored in Datastore. Terms are indexed so
+// that documents can be found by term; Weights holds the weight of each term.
+type SearchDocument struct {
+	Slug        string
+	Terms       []string
+	Weights     []int `datastore:",noindex"`
+	PublishDate time.Time
+	IsPage      bool `datastore:",noindex"`
+	// Slugs of the posts most like this one, best first, see findRelated.
+	Related []string `datastore:",noindex"`
+}
+
+// A search match, ranked by how many of the query's terms it contains and then
+// by their weight.
+type searchResult struct {
+	Slug        string
+	Matched     int
+	Weight      int
+	PublishDate time.Time
+}
+
+// searchResultsByRank sorts search results best first, then newest first.
+type searchResultsByRank []searchResult
+
+func (s searchResultsByRank) Len() int      { return len(s) }
+func (s searchResultsByRank) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s searchResultsByRank) Less(i, j int) bool {
+	if s[i].Matched != s[j].Matched {
+		return s[i].Matched > s[j].Matched
+	}
+	if s[i].Weight != s[j].Weight {
+		return s[i].Weight > s[j].Weight
+	}
+	return s[i].PublishDate.After(s[j].PublishDate)
+}
+
+// stripTags returns the text of an HTML fragment.
+func stripTags(content string) string {
+	return html.UnescapeString(html_tag_re.ReplaceAllString(content, " "))
+}
+
+// tokenize splits text into lower case words, leaving out stop words.
+func tokenize(text string) (tokens []string) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if len(word) > 1 && !stop_words[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// newSearchDocument builds the search document for an entry.
+func newSearchDocument(e *SavedEntry) SearchDocument {
+	weights := make(map[string]int)
+	for _, term := range tokenize(stripTags(e.Title)) {
+		weights[term] += SEARCH_TITLE_WEIGHT
+	}
+	for _, term := range tokenize(stripTags(string(e.HTML()))) {
+		weights[term]++
+	}
+
+	d := SearchDocument{Slug: e.Slug, PublishDate: e.PublishDate, IsPage: e.IsPage}
+	for term := range weights {
+		d.Terms = append(d.Terms, term)
+	}
+	sort.Strings(d.Terms)
+	for _, term := range d.Terms {
+		d.Weights = append(d.Weights, weights[term])
+	}
+	return d
+}
+
+// weight returns the weight of a term in a document, or 0 if it is not there.
+func (d *SearchDocument) weight(term string) int {
+	i := sort.SearchStrings(d.Terms, term)
+	if i < len(d.Terms) && d.Terms[i] == term && i < len(d.Weights) {
+		return d.Weights[i]
+	}
+	return 0
+}
+
+// IndexEntry brings the search index up to date with an entry, and finds the
+// posts related to it. Hidden entries are taken out of the index.
+func IndexEntry(c Context, e *SavedEntry) error {
+	if e.IsHidden {
+		return UnindexEntry(c, e.Slug)
+	}
+	return indexEntries(c, []SavedEntry{*e})
+}
+
+// relateDocument finds the posts related to a post's search document, and
+// saves it with them.
+func relateDocument(c Context, d *SearchDocument) error {
+	related, err := findRelated(c, d)
+	if err != nil {
+		return err
+	}
+	d.Related = related
+	return c.Store().PutSearchDocument(d)
+}
+
+// indexEntries indexes entries, and finds the posts related to them. Every
+// one is indexed before the related posts of any are found, so that a whole
+// blog being restored or imported comes out the same whatever order it came
+// in. The posts they were and are now most like have theirs found again, as
+// they may have gained or lost them. Hidden entries are left out.
+func indexEntries(c Context, entries []SavedEntry) error {
+	var posts []SearchDocument
+	var neighbours []string
+	done := make(map[string]bool)
+	for i := range entries {
+		if entries[i].IsHidden {
+			continue
+		}
+		if previous, err := c.Store().GetSearchDocument(entries[i].Slug); err == nil {
+			neighbours = append(neighbours, previous.Related...)
+		} else if err != ErrNoSuchEntity {
+			return err
+		}
+		// Saved before related posts are found, so that each counts towards
+		// the IDF of its terms.
+		d := newSearchDocument(&entries[i])
+		if err := c.Store().PutSearchDocument(&d); err != nil {
+			return err
+		}
+		if !d.IsPage {
+			posts = append(posts, d)
+			done[d.Slug] = true
+		}
+	}
+	for i := range posts {
+		if err := relateDocument(c, &posts[i]); err != nil {
+			return err
+		}
+		neighbours = append(neighbours, posts[i].Related...)
+	}
+	for _, slug := range neighbours {
+		if done[slug] {
+			continue
+		}
+		done[slug] = true
+		d, err := c.Store().GetSearchDocument(slug)
+		if err == ErrNoSuchEntity {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := relateDocument(c, &d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnindexEntry removes an entry from the search index.
+func UnindexEntry(c Context, slug string) error {
+	err := c.Store().DeleteSearchDocument(slug)
+	if err == ErrNoSuchEntity {
+		return nil
+	}
+	return err
+}
+
+// RebuildSearchIndex indexes every entry and page from scratch.
+func RebuildSearchIndex(c Context) (indexed int, err error) {
+	if err := c.Store().DeleteSearchDocuments(); err != nil {
+		return 0, err
+	}
+	var entries []SavedEntry
+	for _, is_page := range []bool{false, true} {
+		// Scheduled entries are indexed now, and left out of results until they are published.
+		found, _, err := GetEntries(c, EntryQuery{IsPage: is_page, IncludeScheduled: true})
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, found...)
+	}
+	return len(entries), indexEntries(c, entries)
+}
+
+// Search returns the published entries and pages matching a query, best first.
+func Search(c Context, query string) (entries []SavedEntry, err error) {
+	found := make(map[string]*searchResult)
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		docs, err := c.Store().FindSearchDocuments(term)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			result, ok := found[d.Slug]
+			if !ok {
+				result = &searchResult{Slug: d.Slug, PublishDate: d.PublishDate}
+				found[d.Slug] = result
+			}
+			result.Matched++
+			result.Weight += d.weight(term)
+		}
+	}
+
+	var results []searchResult
+	for _, result := range found {
+		results = append(results, *result)
+	}
+	sort.Sort(searchResultsByRank(results))
+
+	for _, result := range results {
+		if len(entries) == MAX_SEARCH_RESULTS {
+			break
+		}
+		// The index may be behind: check that the entry is still there to be seen.
+		entry, err := GetSingleEntry(c, result.Slug)
+		if err != nil || entry.IsHidden || !entry.IsPublished() {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// searchURL returns the URL of a page of search results.
+func searchURL(query string, page int) string {
+	values := url.Values{"q": {query}}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return "/search?" + values.Encode()
+}
+
+// HTTP handler for /search
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	query := strings.TrimSpace(r.FormValue("q"))
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var entries []SavedEntry
+	if query != "" {
+		var err error
+		entries, err = Search(c, query)
+		if err != nil {
+			c.Errorf("Search for %q failed: %v", query, err)
+			http.Error(w, "Search is not available right now.", http.StatusInternalServerError)
+			return
+		}
+	}
+	result_count := len(entries)
+
+	entries_per_page, _ := config.GetInt("entries_per_page")
+	previousURL := ""
+	nextURL := ""
+	start := int(entries_per_page) * (page - 1)
+	if start > len(entries) {
+		start = len(entries)
+	}
+	entries = entries[start:]
+	if page > 1 {
+		previousURL = searchURL(query, page-1)
+	}
+	if len(entries) > int(entries_per_page) {
+		nextURL = searchURL(query, page+1)
+		entries = entries[:entries_per_page]
+	}
+
+	links, _ := GetLinks(c)
+	title := "Search"
+	if query != "" {
+		title = fmt.Sprintf("Search results for %s", query)
+	}
+	context, _ := GetTemplateContext(entries, links, title, "search", r)
+	context.SearchQuery = query
+	context.SearchResultCount = result_count
+	context.PreviousURL = previousURL
+	context.NextURL = nextURL
+	renderTemplate(w, *searchTpl, context)
+}
+
+// handler for /admin/rebuild_search - indexes every entry from scratch
+func adminRebuildSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Rebuilding the search index requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	indexed, err := RebuildSearchIndex(c)
+	// Related posts may have changed along with the index.
+	c.Cache().Flush()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Rebuilt the search index with %d entries", indexed)
+	http.Redirect(w, r, fmt.Sprintf("/admin?reindexed=%d", indexed), http.StatusFound)
+}
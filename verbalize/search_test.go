@@ -0,0 +1,38 @@
+package blog
+
+import "testing"
+
+func TestTokenize(t *testing.T) {
+	tests := map[string][]string{
+		"The quick, brown fox!":     {"quick", "brown", "fox"},
+		"Going to the café in 2012": {"going", "café", "2012"},
+		"a I x":                     nil,
+	}
+	for text, want := range tests {
+		if got := tokenize(text); !equalStrings(got, want) {
+			t.Errorf("tokenize(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
+
+func TestSearchRanksMatches(t *testing.T) {
+	c := newTestContext("admin")
+	titled := wordyEntry("bicycle", 3, "about wheels")
+	both := wordyEntry("both", 2, "bicycle wheels")
+	once := wordyEntry("once", 1, "bicycle")
+	hidden := wordyEntry("hidden", 1, "bicycle wheels")
+	hidden.setStatus(STATUS_DRAFT)
+	putEntries(t, c, titled, both, once, hidden)
+	if _, err := RebuildSearchIndex(c); err != nil {
+		t.Fatalf("RebuildSearchIndex: %v", err)
+	}
+
+	entries, err := Search(c, "bicycle wheels")
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	// Matching both terms beats one, and a word in the title beats one in the content.
+	if got := slugs(entries); !equalStrings(got, []string{"bicycle", "both", "once"}) {
+		t.Errorf("Search = %v, want [bicycle both once]", got)
+	}
+}
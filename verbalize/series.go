@@ -0,0 +1,86 @@
+// Named series of posts, which are read in an order of their own rather
+// than by PublishDate.
+package blog
+
+import (
+	"html/template"
+	"net/url"
+	"sort"
+)
+
+// entriesBySeriesOrder sorts the entries of a series by SeriesOrder, and then
+// by PublishDate for entries given the same place.
+type entriesBySeriesOrder []SavedEntry
+
+func (e entriesBySeriesOrder) Len() int      { return len(e) }
+func (e entriesBySeriesOrder) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entriesBySeriesOrder) Less(i, j int) bool {
+	if e[i].SeriesOrder != e[j].SeriesOrder {
+		return e[i].SeriesOrder < e[j].SeriesOrder
+	}
+	return e[i].PublishDate.Before(e[j].PublishDate)
+}
+
+// seriesURL returns the URL of the index of a series, relative to the blog,
+// escaped as tagURL escapes tags.
+func seriesURL(series string) string {
+	return "series/" + url.PathEscape(series)
+}
+
+// GetSeries retrieves the published posts of a series, in series order
+func GetSeries(c Context, series string) ([]SavedEntry, error) {
+	entries, _, err := GetEntries(c, EntryQuery{IsPage: false, Series: series})
+	sort.Sort(entriesBySeriesOrder(entries))
+	return entries, err
+}
+
+// nextSeriesOrder returns the SeriesOrder that puts an entry after every
+// other in a series, unpublished ones included.
+func nextSeriesOrder(c Context, series string) (int, error) {
+	entries, _, err := GetEntries(c, EntryQuery{IsPage: false, Series: series, IncludeHidden: true, IncludeScheduled: true})
+	if err != nil {
+		return 0, err
+	}
+	next := 1
+	for _, e := range entries {
+		if e.SeriesOrder >= next {
+			next = e.SeriesOrder + 1
+		}
+	}
+	return next, nil
+}
+
+// addSeries fills in where each entry is in its series, and its neighbours
+// there. Entries that aren't published have no place in their series yet.
+func addSeries(c Context, entries []EntryContext) {
+	found := make(map[string][]SavedEntry)
+	for i := range entries {
+		e := &entries[i]
+		if e.Series == "" || e.IsPage {
+			continue
+		}
+		parts, ok := found[e.Series]
+		if !ok {
+			var err error
+			if parts, err = GetSeries(c, e.Series); err != nil {
+				c.Errorf("Unable to get series %s: %v", e.Series, err)
+				continue
+			}
+			found[e.Series] = parts
+		}
+		for j, part := range parts {
+			if part.Slug != e.Slug {
+				continue
+			}
+			e.SeriesPart = j + 1
+			e.SeriesParts = len(parts)
+			if j > 0 {
+				e.SeriesPrevious = &EntryLink{Title: template.HTML(parts[j-1].Title), RelativeURL: parts[j-1].RelativeURL}
+			}
+			if j < len(parts)-1 {
+				e.SeriesNext = &EntryLink{Title: template.HTML(parts[j+1].Title), RelativeURL: parts[j+1].RelativeURL}
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package blog
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// seriesEntry returns a post in series at order, published days days ago.
+func seriesEntry(slug string, days int, series string, order int) SavedEntry {
+	e := testEntry(slug, days)
+	e.Series, e.SeriesOrder = series, order
+	return e
+}
+
+func TestSeriesURLsReachTheirSeries(t *testing.T) {
+	for _, series := range []string{"tour", "tour de france", "part 1?", "50% done", "#1"} {
+		link := "/" + seriesURL(series)
+		if r := httptest.NewRequest("GET", link, nil); r.URL.Path != "/series/"+series {
+			t.Errorf("the link to %q is %s, which reaches %q", series, link, r.URL.Path)
+		}
+	}
+}
+
+func TestGetSeriesIsInSeriesOrder(t *testing.T) {
+	c := newTestContext("")
+	draft := seriesEntry("draft", 0, "tour", 4)
+	draft.setStatus(STATUS_DRAFT)
+	putEntries(t, c,
+		seriesEntry("third", 9, "tour", 3),
+		seriesEntry("first", 1, "tour", 1),
+		seriesEntry("second-b", 2, "tour", 2),
+		seriesEntry("second-a", 3, "tour", 2),
+		seriesEntry("elsewhere", 1, "other", 1),
+		draft)
+	entries, err := GetSeries(c, "tour")
+	if want := []string{"first", "second-a", "second-b", "third"}; err != nil || !equalStrings(slugs(entries), want) {
+		t.Errorf("GetSeries = %v, %v; want %v", slugs(entries), err, want)
+	}
+	// Unpublished parts still have their places.
+	if next, err := nextSeriesOrder(c, "tour"); err != nil || next != 5 {
+		t.Errorf("nextSeriesOrder = %d, %v; want 5", next, err)
+	}
+	if next, _ := nextSeriesOrder(c, "new"); next != 1 {
+		t.Errorf("nextSeriesOrder of a new series = %d, want 1", next)
+	}
+}
+
+func TestAddSeries(t *testing.T) {
+	c := newTestContext("")
+	first, second, third := seriesEntry("first", 3, "tour", 1), seriesEntry("second", 2, "tour", 2), seriesEntry("third", 1, "tour", 3)
+	draft := seriesEntry("draft", 0, "tour", 4)
+	draft.setStatus(STATUS_DRAFT)
+	putEntries(t, c, first, second, third, draft)
+
+	alone := testEntry("alone", 1)
+	entries := []EntryContext{second.Context(), draft.Context(), alone.Context()}
+	addSeries(c, entries)
+	middle := entries[0]
+	if middle.SeriesPart != 2 || middle.SeriesParts != 3 || middle.SeriesPrevious == nil || middle.SeriesPrevious.RelativeURL != first.RelativeURL ||
+		middle.SeriesNext == nil || middle.SeriesNext.RelativeURL != third.RelativeURL {
+		t.Errorf("second is part %d of %d, after %+v and before %+v", middle.SeriesPart, middle.SeriesParts, middle.SeriesPrevious, middle.SeriesNext)
+	}
+	if entries[1].SeriesPart != 0 || entries[2].SeriesPart != 0 {
+		t.Errorf("a draft and a post in no series have parts %d and %d, want none", entries[1].SeriesPart, entries[2].SeriesPart)
+	}
+}
+
+func TestNewPartsGoAtTheEndOfTheirSeries(t *testing.T) {
+	c := newTestContext("editor")
+	putEntries(t, c, seriesEntry("first", 2, "tour", 1), seriesEntry("second", 1, "tour", 2))
+	entry := submitEntry(t, c, "editor", url.Values{
+		"title":       {"Third"},
+		"slug":        {"third"},
+		"content":     {"The end."},
+		"status":      {STATUS_PUBLISHED},
+		"series":      {" Tour "},
+		"is_new_post": {"1"},
+	})
+	if entry.Series != "tour" || entry.SeriesOrder != 3 {
+		t.Errorf("new part is in %q at %d, want tour at 3", entry.Series, entry.SeriesOrder)
+	}
+}
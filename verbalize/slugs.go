@@ -0,0 +1,72 @@
+// Checking slugs before an entry is saved under one.
+package blog
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// Most suffixes tried when looking for a free slug.
+	MAX_SLUG_SUFFIX = 100
+)
+
+var (
+	// regexp matching a valid slug
+	valid_slug_re = regexp.MustCompile(`^[\w-]+$`)
+	// regexp matching a slug that would be taken for a page number or a year
+	numeric_slug_re = regexp.MustCompile(`^\d+$`)
+
+	// Slugs that rootHandler or other handlers already answer to.
+	reserved_slugs = map[string]bool{
+		"admin": true, "archive": true, "author": true, "cron": true, "feed": true, "media": true, "search": true, "series": true,
+		"tag": true, "themes": true, "third_party": true,
+	}
+)
+
+// validateSlug returns a description of what is wrong with a slug, or "" if
+// nothing is.
+func validateSlug(slug string) string {
+	switch {
+	case slug == "":
+		return "The slug is empty."
+	case !valid_slug_re.MatchString(slug):
+		return fmt.Sprintf("The slug %q may only contain letters, numbers, dashes and underscores.", slug)
+	case numeric_slug_re.MatchString(slug):
+		return fmt.Sprintf("The slug %q can't be only numbers, as it would be mistaken for a page number.", slug)
+	case reserved_slugs[slug]:
+		return fmt.Sprintf("The slug %q is already used by the blog itself.", slug)
+	}
+	return ""
+}
+
+// slugTaken returns true if an entry, or an entry in the trash, has slug.
+func slugTaken(c Context, slug string) bool {
+	if _, err := GetSingleEntry(c, slug); err == nil {
+		return true
+	}
+	if _, err := c.Store().GetTrashedEntry(slug); err == nil {
+		return true
+	}
+	return false
+}
+
+// availableSlug returns slug with the lowest numeric suffix that isn't taken.
+func availableSlug(c Context, slug string) string {
+	for i := 2; i < MAX_SLUG_SUFFIX; i++ {
+		candidate := fmt.Sprintf("%s-%d", slug, i)
+		if !slugTaken(c, candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// CreateEntry saves a new blog entry to the store, unless its slug is already
+// taken, in which case it returns ErrEntityExists.
+func CreateEntry(c Context, e *SavedEntry) error {
+	if _, err := c.Store().GetTrashedEntry(e.Slug); err == nil {
+		return ErrEntityExists
+	}
+	return c.Store().CreateEntry(e)
+}
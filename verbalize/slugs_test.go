@@ -0,0 +1,72 @@
+package blog
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateSlug(t *testing.T) {
+	tests := map[string]bool{
+		"hello-world": true,
+		"2014_review": true,
+		"":            false,
+		"hello world": false,
+		"a/b":         false,
+		"2014":        false,
+		"admin":       false,
+		"feed":        false,
+	}
+	for slug, valid := range tests {
+		if problem := validateSlug(slug); (problem == "") != valid {
+			t.Errorf("validateSlug(%q) = %q, want valid %t", slug, problem, valid)
+		}
+	}
+}
+
+func TestAvailableSlug(t *testing.T) {
+	c := newTestContext("")
+	putEntries(t, c, testEntry("post", 1), testEntry("post-2", 1), testEntry("gone-3", 1))
+	if got := availableSlug(c, "post"); got != "post-3" {
+		t.Errorf("availableSlug(post) = %q, want post-3", got)
+	}
+	if err := TrashEntry(c, "gone-3"); err != nil {
+		t.Fatalf("TrashEntry: %v", err)
+	}
+	if !slugTaken(c, "gone-3") {
+		t.Errorf("a slug in the trash isn't taken")
+	}
+	if got := availableSlug(c, "gone"); got != "gone-2" {
+		t.Errorf("availableSlug(gone) = %q, want gone-2", got)
+	}
+}
+
+func TestNewEntriesDontOverwriteOthers(t *testing.T) {
+	c := newTestContext("editor")
+	putEntries(t, c, testEntry("taken", 1), testEntry("binned", 1))
+	if err := TrashEntry(c, "binned"); err != nil {
+		t.Fatalf("TrashEntry: %v", err)
+	}
+	form := url.Values{
+		"title":       {"Another"},
+		"content":     {"Mine."},
+		"status":      {STATUS_PUBLISHED},
+		"is_new_post": {"1"},
+	}
+	for _, slug := range []string{"taken", "binned"} {
+		form.Set("slug", slug)
+		w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor")
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), slug+"-2") {
+			t.Errorf("a new entry named %s gave %d, want %d suggesting %s-2", slug, w.Code, http.StatusConflict, slug+"-2")
+		}
+	}
+	if taken, _ := c.Store().GetSingleEntry("taken"); taken.Title != "taken" {
+		t.Errorf("taken was overwritten, its title is now %q", taken.Title)
+	}
+
+	form.Set("slug", "admin")
+	if w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, "editor"); w.Code != http.StatusBadRequest {
+		t.Errorf("a new entry named admin gave %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
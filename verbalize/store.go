@@ -0,0 +1,1000 @@
+// Storage interfaces for entries and links, and an in-memory implementation.
+package blog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSuchEntity is returned by a Store when a requested item does not exist.
+var ErrNoSuchEntity = errors.New("blog: no such entity")
+
+// ErrEntityExists is returned by a Store when creating an item that already exists.
+var ErrEntityExists = errors.New("blog: entity already exists")
+
+// ErrVersionConflict is returned by a Store when updating an entry that has
+// been saved by someone else since it was loaded.
+var ErrVersionConflict = errors.New("blog: entity was changed since it was loaded")
+
+// EntryStore retrieves and saves blog entries and pages.
+type EntryStore interface {
+	// GetEntries returns entries matching params, and if Count of them were
+	// returned and there are more, a cursor to continue from.
+	GetEntries(params EntryQuery) ([]SavedEntry, string, error)
+	// GetPublishDates returns just the PublishDate of entries matching params.
+	GetPublishDates(params EntryQuery) ([]time.Time, error)
+	GetSingleEntry(slug string) (SavedEntry, error)
+	PutEntry(e *SavedEntry) error
+	// CreateEntry is PutEntry for a new entry: it atomically checks that the
+	// slug is free, and returns ErrEntityExists if it isn't.
+	CreateEntry(e *SavedEntry) error
+	// UpdateEntry is PutEntry for an entry that was loaded at version: it
+	// atomically checks that nobody has saved it since, returning
+	// ErrVersionConflict if they have, and increments e.Version.
+	UpdateEntry(e *SavedEntry, version int64) error
+	// DeleteEntry returns ErrNoSuchEntity if there is no entry to delete.
+	DeleteEntry(slug string) error
+}
+
+// LinkStore retrieves and saves links.
+type LinkStore interface {
+	GetLinks() ([]SavedLink, error)
+	PutLink(l *SavedLink) error
+	DeleteLink(url string) error
+}
+
+// RevisionStore keeps the history of entries.
+type RevisionStore interface {
+	// GetRevisions returns the revisions of an entry, newest first.
+	GetRevisions(slug string) ([]SavedRevision, error)
+	GetRevision(id int64) (SavedRevision, error)
+	// PutRevision stores a revision, first giving it a new ID if it has none.
+	PutRevision(rev *SavedRevision) error
+	DeleteRevisions(slug string) error
+}
+
+// DraftStore keeps autosaved drafts, keyed by SavedDraft.ID().
+type DraftStore interface {
+	GetDraft(id string) (SavedDraft, error)
+	PutDraft(d *SavedDraft) error
+	DeleteDraft(id string) error
+}
+
+// TrashStore keeps deleted entries and links until they are restored or purged.
+type TrashStore interface {
+	// GetTrashedEntries returns trashed entries and pages, most recently deleted first.
+	GetTrashedEntries() ([]TrashedEntry, error)
+	GetTrashedEntry(slug string) (TrashedEntry, error)
+	PutTrashedEntry(t *TrashedEntry) error
+	PurgeTrashedEntry(slug string) error
+	// GetTrashedLinks returns trashed links, most recently deleted first.
+	GetTrashedLinks() ([]TrashedLink, error)
+	GetTrashedLink(url string) (TrashedLink, error)
+	PutTrashedLink(t *TrashedLink) error
+	PurgeTrashedLink(url string) error
+}
+
+// SearchStore keeps the search index, one SearchDocument per entry.
+type SearchStore interface {
+	// FindSearchDocuments returns the documents containing term.
+	FindSearchDocuments(term string) ([]SearchDocument, error)
+	GetSearchDocument(slug string) (SearchDocument, error)
+	// CountSearchDocuments returns how many documents are in the index.
+	CountSearchDocuments() (int, error)
+	PutSearchDocument(d *SearchDocument) error
+	DeleteSearchDocument(slug string) error
+	// DeleteSearchDocuments empties the search index.
+	DeleteSearchDocuments() error
+}
+
+// RedirectStore keeps the redirects left behind by renamed entries, keyed by
+// their old slug.
+type RedirectStore interface {
+	// GetRedirects returns every redirect, by old slug.
+	GetRedirects() ([]SavedRedirect, error)
+	GetRedirect(oldSlug string) (SavedRedirect, error)
+	PutRedirect(r *SavedRedirect) error
+	DeleteRedirect(oldSlug string) error
+}
+
+// AuthorStore keeps author profiles, keyed by their ID.
+type AuthorStore interface {
+	// GetAuthors returns every author, by ID.
+	GetAuthors() ([]SavedAuthor, error)
+	GetAuthor(id string) (SavedAuthor, error)
+	// GetAuthorByUser returns the profile of a user.
+	GetAuthorByUser(user string) (SavedAuthor, error)
+	PutAuthor(a *SavedAuthor) error
+	DeleteAuthor(id string) error
+}
+
+// MigrationStore records which migrations have run, keyed by their ID.
+type MigrationStore interface {
+	GetMigrations() ([]SavedMigration, error)
+	PutMigration(m *SavedMigration) error
+}
+
+// MediaStore keeps the records of uploaded files, keyed by their ID. The
+// files themselves are kept in a BlobStore.
+type MediaStore interface {
+	// GetMediaFiles returns every uploaded file, newest first.
+	GetMediaFiles() ([]SavedMediaFile, error)
+	GetMediaFile(id string) (SavedMediaFile, error)
+	PutMediaFile(f *SavedMediaFile) error
+	DeleteMediaFile(id string) error
+}
+
+// Store is everything verbalize needs to persist.
+type Store interface {
+	EntryStore
+	LinkStore
+	RevisionStore
+	DraftStore
+	TrashStore
+	SearchStore
+	RedirectStore
+	AuthorStore
+	MigrationStore
+	MediaStore
+}
+
+// MemoryStore is a Store that keeps everything in process memory. Contents
+// are lost on restart unless it was opened with OpenFileStore.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	path      string
+	entries   map[string]SavedEntry
+	links     map[string]SavedLink
+	revisions map[int64]SavedRevision
+	drafts    map[string]SavedDraft
+
+	trashedEntries map[string]TrashedEntry
+	trashedLinks   map[string]TrashedLink
+
+	searchDocuments map[string]SearchDocument
+	redirects       map[string]SavedRedirect
+	authors         map[string]SavedAuthor
+	migrations      map[string]SavedMigration
+	mediaFiles      map[string]SavedMediaFile
+}
+
+// memorySnapshot is the on-disk format of a file backed MemoryStore.
+type memorySnapshot struct {
+	Entries   []SavedEntry
+	Links     []SavedLink
+	Revisions []SavedRevision
+	Drafts    []SavedDraft
+
+	TrashedEntries []TrashedEntry
+	TrashedLinks   []TrashedLink
+
+	SearchDocuments []SearchDocument
+	Redirects       []SavedRedirect
+	Authors         []SavedAuthor
+	Migrations      []SavedMigration
+	MediaFiles      []SavedMediaFile
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]SavedEntry),
+		links:     make(map[string]SavedLink),
+		revisions: make(map[int64]SavedRevision),
+		drafts:    make(map[string]SavedDraft),
+
+		trashedEntries: make(map[string]TrashedEntry),
+		trashedLinks:   make(map[string]TrashedLink),
+
+		searchDocuments: make(map[string]SearchDocument),
+		redirects:       make(map[string]SavedRedirect),
+		authors:         make(map[string]SavedAuthor),
+		migrations:      make(map[string]SavedMigration),
+		mediaFiles:      make(map[string]SavedMediaFile),
+	}
+}
+
+// OpenFileStore returns a MemoryStore that is loaded from and saved to a
+// JSON file at path, so that a standalone server keeps its contents.
+func OpenFileStore(path string) (*MemoryStore, error) {
+	m := NewMemoryStore()
+	m.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	for _, e := range snapshot.Entries {
+		m.entries[e.Slug] = e
+	}
+	for _, l := range snapshot.Links {
+		m.links[l.URL] = l
+	}
+	for _, rev := range snapshot.Revisions {
+		m.revisions[rev.ID] = rev
+	}
+	for _, d := range snapshot.Drafts {
+		m.drafts[d.ID()] = d
+	}
+	for _, t := range snapshot.TrashedEntries {
+		m.trashedEntries[t.Slug] = t
+	}
+	for _, t := range snapshot.TrashedLinks {
+		m.trashedLinks[t.URL] = t
+	}
+	for _, d := range snapshot.SearchDocuments {
+		m.searchDocuments[d.Slug] = d
+	}
+	for _, r := range snapshot.Redirects {
+		m.redirects[r.OldSlug] = r
+	}
+	for _, a := range snapshot.Authors {
+		m.authors[a.ID] = a
+	}
+	for _, mig := range snapshot.Migrations {
+		m.migrations[mig.ID] = mig
+	}
+	for _, f := range snapshot.MediaFiles {
+		m.mediaFiles[f.ID] = f
+	}
+	return m, nil
+}
+
+// persist writes the store to disk if it is file backed. Callers hold m.mu.
+func (m *MemoryStore) persist() error {
+	if m.path == "" {
+		return nil
+	}
+	var snapshot memorySnapshot
+	for _, e := range m.entries {
+		snapshot.Entries = append(snapshot.Entries, e)
+	}
+	for _, l := range m.links {
+		snapshot.Links = append(snapshot.Links, l)
+	}
+	for _, rev := range m.revisions {
+		snapshot.Revisions = append(snapshot.Revisions, rev)
+	}
+	for _, d := range m.drafts {
+		snapshot.Drafts = append(snapshot.Drafts, d)
+	}
+	for _, t := range m.trashedEntries {
+		snapshot.TrashedEntries = append(snapshot.TrashedEntries, t)
+	}
+	for _, t := range m.trashedLinks {
+		snapshot.TrashedLinks = append(snapshot.TrashedLinks, t)
+	}
+	for _, d := range m.searchDocuments {
+		snapshot.SearchDocuments = append(snapshot.SearchDocuments, d)
+	}
+	for _, r := range m.redirects {
+		snapshot.Redirects = append(snapshot.Redirects, r)
+	}
+	for _, a := range m.authors {
+		snapshot.Authors = append(snapshot.Authors, a)
+	}
+	for _, mig := range m.migrations {
+		snapshot.Migrations = append(snapshot.Migrations, mig)
+	}
+	for _, f := range m.mediaFiles {
+		snapshot.MediaFiles = append(snapshot.MediaFiles, f)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to the side and rename, so that a crash never leaves half a file.
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// entriesByDate sorts entries newest first, as the datastore does with
+// -PublishDate, and then by key.
+type entriesByDate []SavedEntry
+
+func (e entriesByDate) Len() int      { return len(e) }
+func (e entriesByDate) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e entriesByDate) Less(i, j int) bool {
+	if !e[i].PublishDate.Equal(e[j].PublishDate) {
+		return e[i].PublishDate.After(e[j].PublishDate)
+	}
+	return e[i].Slug < e[j].Slug
+}
+
+// revisionsByDate sorts revisions newest first.
+type revisionsByDate []SavedRevision
+
+func (r revisionsByDate) Len() int           { return len(r) }
+func (r revisionsByDate) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r revisionsByDate) Less(i, j int) bool { return r[i].Date.After(r[j].Date) }
+
+// trashedEntriesByDate sorts trashed entries, most recently deleted first.
+type trashedEntriesByDate []TrashedEntry
+
+func (t trashedEntriesByDate) Len() int           { return len(t) }
+func (t trashedEntriesByDate) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t trashedEntriesByDate) Less(i, j int) bool { return t[i].DeletedDate.After(t[j].DeletedDate) }
+
+// trashedLinksByDate sorts trashed links, most recently deleted first.
+type trashedLinksByDate []TrashedLink
+
+func (t trashedLinksByDate) Len() int           { return len(t) }
+func (t trashedLinksByDate) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t trashedLinksByDate) Less(i, j int) bool { return t[i].DeletedDate.After(t[j].DeletedDate) }
+
+// redirectsBySlug sorts redirects by old slug.
+type redirectsBySlug []SavedRedirect
+
+func (r redirectsBySlug) Len() int           { return len(r) }
+func (r redirectsBySlug) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r redirectsBySlug) Less(i, j int) bool { return r[i].OldSlug < r[j].OldSlug }
+
+// authorsByID sorts authors by ID.
+type authorsByID []SavedAuthor
+
+func (a authorsByID) Len() int           { return len(a) }
+func (a authorsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a authorsByID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+
+// mediaFilesByDate sorts uploaded files newest first.
+type mediaFilesByDate []SavedMediaFile
+
+func (f mediaFilesByDate) Len() int           { return len(f) }
+func (f mediaFilesByDate) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f mediaFilesByDate) Less(i, j int) bool { return f[i].Uploaded.After(f[j].Uploaded) }
+
+// linksByOrder sorts links by Order, then Title.
+type linksByOrder []SavedLink
+
+func (l linksByOrder) Len() int      { return len(l) }
+func (l linksByOrder) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l linksByOrder) Less(i, j int) bool {
+	if l[i].Order != l[j].Order {
+		return l[i].Order < l[j].Order
+	}
+	return l[i].Title < l[j].Title
+}
+
+// matches returns true if an entry passes the filters of an EntryQuery.
+func (params EntryQuery) matches(e *SavedEntry) bool {
+	if e.IsPage != params.IsPage {
+		return false
+	}
+	if !params.Start.IsZero() && e.PublishDate.Before(params.Start) {
+		return false
+	}
+	if end := params.end(); !end.IsZero() && !e.PublishDate.Before(end) {
+		return false
+	}
+	if params.ScheduledOnly && !e.IsScheduled {
+		return false
+	}
+	if !params.IncludeHidden && e.IsHidden {
+		return false
+	}
+	if params.Tag != "" && !hasTag(e.Tags, params.Tag) {
+		return false
+	}
+	if params.Status != "" && e.CurrentStatus() != params.Status {
+		return false
+	}
+	if params.Author != "" && e.Author != params.Author {
+		return false
+	}
+	if params.Series != "" && e.Series != params.Series {
+		return false
+	}
+	if params.PinnedOnly && !e.IsPinned {
+		return false
+	}
+	for _, slug := range params.ExcludeSlugs {
+		if e.Slug == slug {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryCursor returns a cursor for the entries that sort after e. Dates are
+// kept as text, as undated drafts are too early to have a UnixNano.
+func memoryCursor(e *SavedEntry) string {
+	position := fmt.Sprintf("%s/%s", e.PublishDate.UTC().Format(time.RFC3339Nano), e.Slug)
+	return base64.URLEncoding.EncodeToString([]byte(position))
+}
+
+// afterMemoryCursor returns the entries that sort after a cursor.
+func afterMemoryCursor(entries []SavedEntry, cursor string) ([]SavedEntry, error) {
+	position, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(position), "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	date, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+	slug := parts[1]
+	// entries are in entriesByDate order: find the first that sorts after the cursor.
+	i := sort.Search(len(entries), func(i int) bool {
+		if !entries[i].PublishDate.Equal(date) {
+			return entries[i].PublishDate.Before(date)
+		}
+		return entries[i].Slug > slug
+	})
+	return entries[i:], nil
+}
+
+// GetEntries returns entries matching params, newest first.
+func (m *MemoryStore) GetEntries(params EntryQuery) (entries []SavedEntry, cursor string, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, e := range m.entries {
+		if params.matches(&e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Sort(entriesByDate(entries))
+
+	if params.Cursor != "" {
+		if entries, err = afterMemoryCursor(entries, params.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	if params.Offset > 0 {
+		if params.Offset >= len(entries) {
+			return nil, "", nil
+		}
+		entries = entries[params.Offset:]
+	}
+	if params.Count > 0 && len(entries) > params.Count {
+		entries = entries[:params.Count]
+		cursor = memoryCursor(&entries[len(entries)-1])
+	}
+	return entries, cursor, nil
+}
+
+// GetPublishDates returns the PublishDate of entries matching params, newest first.
+func (m *MemoryStore) GetPublishDates(params EntryQuery) (dates []time.Time, err error) {
+	entries, _, err := m.GetEntries(params)
+	for _, e := range entries {
+		dates = append(dates, e.PublishDate)
+	}
+	return dates, err
+}
+
+// GetSingleEntry returns the entry stored under slug.
+func (m *MemoryStore) GetSingleEntry(slug string) (SavedEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	e, ok := m.entries[slug]
+	if !ok {
+		return SavedEntry{Slug: slug}, ErrNoSuchEntity
+	}
+	return e, nil
+}
+
+// PutEntry stores an entry under its slug, replacing any previous version.
+func (m *MemoryStore) PutEntry(e *SavedEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *e
+	saved.Content = append([]byte(nil), e.Content...)
+	m.entries[e.Slug] = saved
+	return m.persist()
+}
+
+// UpdateEntry stores an entry, unless it has been saved since version.
+func (m *MemoryStore) UpdateEntry(e *SavedEntry, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok := m.entries[e.Slug]; ok && current.Version != version {
+		return ErrVersionConflict
+	}
+	e.Version = version + 1
+	saved := *e
+	saved.Content = append([]byte(nil), e.Content...)
+	m.entries[e.Slug] = saved
+	return m.persist()
+}
+
+// CreateEntry stores a new entry, unless one is already stored under its slug.
+func (m *MemoryStore) CreateEntry(e *SavedEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[e.Slug]; ok {
+		return ErrEntityExists
+	}
+	saved := *e
+	saved.Content = append([]byte(nil), e.Content...)
+	m.entries[e.Slug] = saved
+	return m.persist()
+}
+
+// DeleteEntry removes the entry stored under slug.
+func (m *MemoryStore) DeleteEntry(slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[slug]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.entries, slug)
+	return m.persist()
+}
+
+// GetLinks returns all links in display order.
+func (m *MemoryStore) GetLinks() (links []SavedLink, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, l := range m.links {
+		links = append(links, l)
+	}
+	sort.Sort(linksByOrder(links))
+	return links, nil
+}
+
+// PutLink stores a link under its URL, replacing any previous version.
+func (m *MemoryStore) PutLink(l *SavedLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.links[l.URL] = *l
+	return m.persist()
+}
+
+// DeleteLink removes the link stored under url.
+func (m *MemoryStore) DeleteLink(url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.links[url]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.links, url)
+	return m.persist()
+}
+
+// GetRevisions returns the revisions of an entry, newest first.
+func (m *MemoryStore) GetRevisions(slug string) (revisions []SavedRevision, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rev := range m.revisions {
+		if rev.Slug == slug {
+			revisions = append(revisions, rev)
+		}
+	}
+	sort.Sort(revisionsByDate(revisions))
+	return revisions, nil
+}
+
+// GetRevision returns a single revision by ID.
+func (m *MemoryStore) GetRevision(id int64) (SavedRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rev, ok := m.revisions[id]
+	if !ok {
+		return SavedRevision{ID: id}, ErrNoSuchEntity
+	}
+	return rev, nil
+}
+
+// PutRevision stores a revision under its ID, giving it one after the
+// highest in use if it has none.
+func (m *MemoryStore) PutRevision(rev *SavedRevision) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rev.ID == 0 {
+		for id := range m.revisions {
+			if id > rev.ID {
+				rev.ID = id
+			}
+		}
+		rev.ID++
+	}
+	saved := *rev
+	saved.Content = append([]byte(nil), rev.Content...)
+	m.revisions[rev.ID] = saved
+	return m.persist()
+}
+
+// DeleteRevisions removes every revision of an entry.
+func (m *MemoryStore) DeleteRevisions(slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, rev := range m.revisions {
+		if rev.Slug == slug {
+			delete(m.revisions, id)
+		}
+	}
+	return m.persist()
+}
+
+// GetDraft returns the draft stored under id.
+func (m *MemoryStore) GetDraft(id string) (SavedDraft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d, ok := m.drafts[id]
+	if !ok {
+		return SavedDraft{}, ErrNoSuchEntity
+	}
+	return d, nil
+}
+
+// PutDraft stores a draft, replacing the previous one for that user and entry.
+func (m *MemoryStore) PutDraft(d *SavedDraft) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *d
+	saved.Content = append([]byte(nil), d.Content...)
+	m.drafts[d.ID()] = saved
+	return m.persist()
+}
+
+// DeleteDraft removes the draft stored under id.
+func (m *MemoryStore) DeleteDraft(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.drafts[id]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.drafts, id)
+	return m.persist()
+}
+
+// GetTrashedEntries returns trashed entries and pages, most recently deleted first.
+func (m *MemoryStore) GetTrashedEntries() (trashed []TrashedEntry, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.trashedEntries {
+		trashed = append(trashed, t)
+	}
+	sort.Sort(trashedEntriesByDate(trashed))
+	return trashed, nil
+}
+
+// GetTrashedEntry returns the trashed entry that was stored under slug.
+func (m *MemoryStore) GetTrashedEntry(slug string) (TrashedEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.trashedEntries[slug]
+	if !ok {
+		return t, ErrNoSuchEntity
+	}
+	return t, nil
+}
+
+// PutTrashedEntry stores a trashed entry under its slug.
+func (m *MemoryStore) PutTrashedEntry(t *TrashedEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trashedEntries[t.Slug] = *t
+	return m.persist()
+}
+
+// PurgeTrashedEntry permanently removes a trashed entry.
+func (m *MemoryStore) PurgeTrashedEntry(slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.trashedEntries[slug]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.trashedEntries, slug)
+	return m.persist()
+}
+
+// GetTrashedLinks returns trashed links, most recently deleted first.
+func (m *MemoryStore) GetTrashedLinks() (trashed []TrashedLink, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.trashedLinks {
+		trashed = append(trashed, t)
+	}
+	sort.Sort(trashedLinksByDate(trashed))
+	return trashed, nil
+}
+
+// GetTrashedLink returns the trashed link that was stored under url.
+func (m *MemoryStore) GetTrashedLink(url string) (TrashedLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.trashedLinks[url]
+	if !ok {
+		return t, ErrNoSuchEntity
+	}
+	return t, nil
+}
+
+// PutTrashedLink stores a trashed link under its URL.
+func (m *MemoryStore) PutTrashedLink(t *TrashedLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trashedLinks[t.URL] = *t
+	return m.persist()
+}
+
+// PurgeTrashedLink permanently removes a trashed link.
+func (m *MemoryStore) PurgeTrashedLink(url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.trashedLinks[url]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.trashedLinks, url)
+	return m.persist()
+}
+
+// FindSearchDocuments returns the search documents containing term.
+func (m *MemoryStore) FindSearchDocuments(term string) (docs []SearchDocument, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, d := range m.searchDocuments {
+		if d.weight(term) > 0 {
+			docs = append(docs, d)
+		}
+	}
+	return docs, nil
+}
+
+// GetSearchDocument returns the search document stored under slug.
+func (m *MemoryStore) GetSearchDocument(slug string) (SearchDocument, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d, ok := m.searchDocuments[slug]
+	if !ok {
+		return SearchDocument{}, ErrNoSuchEntity
+	}
+	return d, nil
+}
+
+// CountSearchDocuments returns how many documents are in the search index.
+func (m *MemoryStore) CountSearchDocuments() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.searchDocuments), nil
+}
+
+// PutSearchDocument stores a search document under its slug.
+func (m *MemoryStore) PutSearchDocument(d *SearchDocument) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.searchDocuments[d.Slug] = *d
+	return m.persist()
+}
+
+// DeleteSearchDocument removes the search document stored under slug.
+func (m *MemoryStore) DeleteSearchDocument(slug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.searchDocuments[slug]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.searchDocuments, slug)
+	return m.persist()
+}
+
+// DeleteSearchDocuments empties the search index.
+func (m *MemoryStore) DeleteSearchDocuments() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.searchDocuments = make(map[string]SearchDocument)
+	return m.persist()
+}
+
+// GetRedirects returns every redirect, by old slug.
+func (m *MemoryStore) GetRedirects() (redirects []SavedRedirect, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.redirects {
+		redirects = append(redirects, r)
+	}
+	sort.Sort(redirectsBySlug(redirects))
+	return redirects, nil
+}
+
+// GetRedirect returns the redirect left behind by an old slug.
+func (m *MemoryStore) GetRedirect(oldSlug string) (SavedRedirect, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.redirects[oldSlug]
+	if !ok {
+		return SavedRedirect{}, ErrNoSuchEntity
+	}
+	return r, nil
+}
+
+// PutRedirect stores a redirect under its old slug.
+func (m *MemoryStore) PutRedirect(r *SavedRedirect) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.redirects[r.OldSlug] = *r
+	return m.persist()
+}
+
+// DeleteRedirect removes the redirect left behind by an old slug.
+func (m *MemoryStore) DeleteRedirect(oldSlug string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.redirects[oldSlug]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.redirects, oldSlug)
+	return m.persist()
+}
+
+// GetAuthors returns every author, by ID.
+func (m *MemoryStore) GetAuthors() (authors []SavedAuthor, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, a := range m.authors {
+		authors = append(authors, a)
+	}
+	sort.Sort(authorsByID(authors))
+	return authors, nil
+}
+
+// GetAuthor returns the author stored under id.
+func (m *MemoryStore) GetAuthor(id string) (SavedAuthor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.authors[id]
+	if !ok {
+		return SavedAuthor{}, ErrNoSuchEntity
+	}
+	return a, nil
+}
+
+// GetAuthorByUser returns the profile of a user.
+func (m *MemoryStore) GetAuthorByUser(user string) (SavedAuthor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, a := range m.authors {
+		if a.User == user {
+			return a, nil
+		}
+	}
+	return SavedAuthor{}, ErrNoSuchEntity
+}
+
+// PutAuthor stores an author under its ID.
+func (m *MemoryStore) PutAuthor(a *SavedAuthor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *a
+	saved.Links = append([]string(nil), a.Links...)
+	m.authors[a.ID] = saved
+	return m.persist()
+}
+
+// DeleteAuthor removes the author stored under id.
+func (m *MemoryStore) DeleteAuthor(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.authors[id]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.authors, id)
+	return m.persist()
+}
+
+// GetMigrations returns the records of every migration that has started.
+func (m *MemoryStore) GetMigrations() (migrations []SavedMigration, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, mig := range m.migrations {
+		migrations = append(migrations, mig)
+	}
+	return migrations, nil
+}
+
+// PutMigration stores the record of a migration under its ID.
+func (m *MemoryStore) PutMigration(mig *SavedMigration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.migrations[mig.ID] = *mig
+	return m.persist()
+}
+
+// GetMediaFiles returns every uploaded file, newest first.
+func (m *MemoryStore) GetMediaFiles() (files []SavedMediaFile, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, f := range m.mediaFiles {
+		files = append(files, f)
+	}
+	sort.Sort(mediaFilesByDate(files))
+	return files, nil
+}
+
+// GetMediaFile returns the uploaded file stored under id.
+func (m *MemoryStore) GetMediaFile(id string) (SavedMediaFile, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.mediaFiles[id]
+	if !ok {
+		return SavedMediaFile{}, ErrNoSuchEntity
+	}
+	return f, nil
+}
+
+// PutMediaFile stores the record of an uploaded file under its ID.
+func (m *MemoryStore) PutMediaFile(f *SavedMediaFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := *f
+	saved.Variants = append([]MediaVariant(nil), f.Variants...)
+	m.mediaFiles[f.ID] = saved
+	return m.persist()
+}
+
+// DeleteMediaFile removes the record of an uploaded file.
+func (m *MemoryStore) DeleteMediaFile(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mediaFiles[id]; !ok {
+		return ErrNoSuchEntity
+	}
+	delete(m.mediaFiles, id)
+	return m.persist()
+}
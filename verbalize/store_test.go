@@ -0,0 +1,225 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestContext returns a Context backed by an empty MemoryStore, for a
+// request made by user. Handlers called after it share its store.
+func newTestContext(user string) Context {
+	local := &Local{Store: NewMemoryStore(), Cache: NewMemoryCache(), Client: http.DefaultClient}
+	contextFor = local.NewContext
+	return local.NewContext(testRequest("GET", "/", nil, user))
+}
+
+// newTestContextWithBlobs is newTestContext, with uploaded files kept in a
+// temporary directory.
+func newTestContextWithBlobs(t *testing.T, user string) Context {
+	local := &Local{Store: NewMemoryStore(), Blobs: NewDiskBlobStore(t.TempDir()), Cache: NewMemoryCache(), Client: http.DefaultClient}
+	contextFor = local.NewContext
+	return local.NewContext(testRequest("GET", "/", nil, user))
+}
+
+// testRequest returns a request for target made by user, with form as its
+// body if it is a POST.
+func testRequest(method string, target string, form url.Values, user string) *http.Request {
+	var r *http.Request
+	if method == "POST" {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		if form != nil {
+			target += "?" + form.Encode()
+		}
+		r = httptest.NewRequest(method, target, nil)
+	}
+	if user != "" {
+		r.SetBasicAuth(user, "")
+	}
+	return r
+}
+
+// serve calls handler with a request made by user, and returns its response.
+func serve(handler http.HandlerFunc, method string, target string, form url.Values, user string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, testRequest(method, target, form, user))
+	return w
+}
+
+// putAuthor gives user a profile with role, failing the test if it can't.
+func putAuthor(t *testing.T, c Context, user string, role string) {
+	t.Helper()
+	a := SavedAuthor{ID: authorID(user), User: user, Role: role}
+	if err := c.Store().PutAuthor(&a); err != nil {
+		t.Fatalf("PutAuthor(%s): %v", user, err)
+	}
+}
+
+// testEntry returns a published post with the given slug, published days
+// days before now.
+func testEntry(slug string, days int) SavedEntry {
+	e := SavedEntry{
+		Title:       slug,
+		Slug:        slug,
+		Content:     []byte("<p>" + slug + "</p>"),
+		PublishDate: time.Now().Add(-time.Duration(days) * 24 * time.Hour),
+		Status:      STATUS_PUBLISHED,
+	}
+	e.setRelativeURL()
+	return e
+}
+
+// putEntries saves entries, failing the test if any can't be.
+func putEntries(t *testing.T, c Context, entries ...SavedEntry) {
+	t.Helper()
+	for i := range entries {
+		if err := c.Store().PutEntry(&entries[i]); err != nil {
+			t.Fatalf("PutEntry(%s): %v", entries[i].Slug, err)
+		}
+	}
+}
+
+// slugs returns the slugs of entries, in order.
+func slugs(entries []SavedEntry) (s []string) {
+	for _, e := range entries {
+		s = append(s, e.Slug)
+	}
+	return s
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryStoreGetEntries(t *testing.T) {
+	c := newTestContext("")
+	hidden := testEntry("hidden", 1)
+	hidden.IsHidden = true
+	scheduled := testEntry("scheduled", -1)
+	scheduled.IsScheduled = true
+	page := testEntry("page", 1)
+	page.IsPage = true
+	tagged := testEntry("tagged", 3)
+	tagged.Tags = []string{"bikes"}
+	putEntries(t, c, testEntry("new", 0), testEntry("old", 10), hidden, scheduled, page, tagged)
+
+	tests := []struct {
+		name  string
+		query EntryQuery
+		want  []string
+	}{
+		{"posts", EntryQuery{}, []string{"new", "tagged", "old"}},
+		{"pages", EntryQuery{IsPage: true}, []string{"page"}},
+		{"hidden", EntryQuery{IncludeHidden: true}, []string{"new", "hidden", "tagged", "old"}},
+		{"scheduled", EntryQuery{IncludeScheduled: true}, []string{"scheduled", "new", "tagged", "old"}},
+		{"tag", EntryQuery{Tag: "bikes"}, []string{"tagged"}},
+		{"count", EntryQuery{Count: 2}, []string{"new", "tagged"}},
+		{"offset", EntryQuery{Offset: 1}, []string{"tagged", "old"}},
+		{"start", EntryQuery{Start: time.Now().Add(-5 * 24 * time.Hour)}, []string{"new", "tagged"}},
+		{"end", EntryQuery{End: time.Now().Add(-5 * 24 * time.Hour)}, []string{"old"}},
+	}
+	for _, tt := range tests {
+		entries, _, err := c.Store().GetEntries(tt.query)
+		if err != nil {
+			t.Errorf("%s: GetEntries: %v", tt.name, err)
+			continue
+		}
+		if got := slugs(entries); !equalStrings(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreGetSingleEntry(t *testing.T) {
+	c := newTestContext("")
+	putEntries(t, c, testEntry("there", 1))
+
+	if e, err := c.Store().GetSingleEntry("there"); err != nil || e.Slug != "there" {
+		t.Errorf("GetSingleEntry(there) = %q, %v", e.Slug, err)
+	}
+	if _, err := c.Store().GetSingleEntry("missing"); err != ErrNoSuchEntity {
+		t.Errorf("GetSingleEntry(missing) error = %v, want ErrNoSuchEntity", err)
+	}
+	if err := c.Store().DeleteEntry("there"); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	if _, err := c.Store().GetSingleEntry("there"); err != ErrNoSuchEntity {
+		t.Errorf("GetSingleEntry after DeleteEntry error = %v, want ErrNoSuchEntity", err)
+	}
+	if err := c.Store().DeleteEntry("there"); err != ErrNoSuchEntity {
+		t.Errorf("DeleteEntry again error = %v, want ErrNoSuchEntity", err)
+	}
+}
+
+func TestFileStoreKeepsEntries(t *testing.T) {
+	path := t.TempDir() + "/verbalize.json"
+	m, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	e := testEntry("kept", 1)
+	if err := m.PutEntry(&e); err != nil {
+		t.Fatalf("PutEntry: %v", err)
+	}
+	if err := m.PutLink(&SavedLink{Title: "Example", URL: "http://example.com/"}); err != nil {
+		t.Fatalf("PutLink: %v", err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	if _, err := reopened.GetSingleEntry("kept"); err != nil {
+		t.Errorf("GetSingleEntry after reopening: %v", err)
+	}
+	if links, _ := reopened.GetLinks(); len(links) != 1 {
+		t.Errorf("got %d links after reopening, want 1", len(links))
+	}
+	if err := reopened.DeleteEntry("missing"); err != ErrNoSuchEntity {
+		t.Errorf("DeleteEntry(missing) error = %v, want ErrNoSuchEntity", err)
+	}
+}
+
+func TestMemoryStoreCursorsPastUndatedDrafts(t *testing.T) {
+	c := newTestContext("")
+	var want []string
+	for _, slug := range []string{"a", "b", "c", "d"} {
+		draft := testEntry(slug, 0)
+		draft.PublishDate = time.Time{}
+		draft.setStatus(STATUS_DRAFT)
+		putEntries(t, c, draft)
+		want = append(want, slug)
+	}
+	putEntries(t, c, testEntry("dated", 1))
+	want = append([]string{"dated"}, want...)
+
+	var got []string
+	query := EntryQuery{IncludeHidden: true, Count: 2}
+	for i := 0; i < 5; i++ {
+		entries, cursor, err := c.Store().GetEntries(query)
+		if err != nil {
+			t.Fatalf("GetEntries: %v", err)
+		}
+		got = append(got, slugs(entries)...)
+		if cursor == "" {
+			break
+		}
+		query.Cursor = cursor
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("paged through %v, want %v", got, want)
+	}
+}
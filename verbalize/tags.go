@@ -0,0 +1,41 @@
+// Helpers for entry tags.
+package blog
+
+import (
+	"net/url"
+	"strings"
+)
+
+// normalizeTag returns the canonical form of a tag, as stored and used in URLs.
+func normalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	return strings.Trim(strings.Replace(tag, "/", "-", -1), "-")
+}
+
+// tagURL returns the URL of the posts tagged with tag, relative to the blog.
+// Tags may have spaces and punctuation in them, so it is escaped, and
+// http.Request unescapes it again for rootHandler.
+func tagURL(tag string) string {
+	return "tag/" + url.PathEscape(tag)
+}
+
+// parseTags splits a comma separated list of tags, dropping blanks and duplicates.
+func parseTags(list string) (tags []string) {
+	for _, tag := range strings.Split(list, ",") {
+		tag = normalizeTag(tag)
+		if tag != "" && !hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// hasTag returns true if tag is one of tags.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
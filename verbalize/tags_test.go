@@ -0,0 +1,54 @@
+package blog
+
+import (
+	"bytes"
+	"html/template"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := map[string]string{
+		"  Road Trip ": "road trip",
+		"a/b":          "a-b",
+		"/leading/":    "leading",
+		"C#":           "c#",
+	}
+	for tag, want := range tests {
+		if got := normalizeTag(tag); got != want {
+			t.Errorf("normalizeTag(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	if got, want := parseTags("Bikes, road trip,, bikes ,/"), []string{"bikes", "road trip"}; !equalStrings(got, want) {
+		t.Errorf("parseTags = %v, want %v", got, want)
+	}
+}
+
+func TestTagURLsReachTheirTags(t *testing.T) {
+	tpl := template.Must(template.New("link").Funcs(template.FuncMap{"TagURL": tagURL}).Parse(`<a href="/{{TagURL .}}">`))
+	for _, tag := range []string{"bikes", "road trip", "c#", "why?", "100%", "ünïcode"} {
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, tag); err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		link := buf.String()[len(`<a href="`) : buf.Len()-len(`">`)]
+		r := httptest.NewRequest("GET", link, nil)
+		if r.URL.Path != "/tag/"+tag {
+			t.Errorf("the link to %q is %s, which reaches %q", tag, link, r.URL.Path)
+		}
+	}
+}
+
+func TestGetEntriesByTag(t *testing.T) {
+	c := newTestContext("")
+	tagged := testEntry("tagged", 1)
+	tagged.Tags = []string{"road trip", "bikes"}
+	putEntries(t, c, tagged, testEntry("untagged", 2))
+	entries, _, err := GetEntries(c, EntryQuery{IsPage: false, Tag: normalizeTag("Road Trip")})
+	if err != nil || !equalStrings(slugs(entries), []string{"tagged"}) {
+		t.Errorf("GetEntries(road trip) = %v, %v; want [tagged]", slugs(entries), err)
+	}
+}
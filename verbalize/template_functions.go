@@ -2,9 +2,6 @@
 package blog
 
 import (
-	"appengine"
-	"appengine/memcache"
-	"appengine/urlfetch"
 	"bufio"
 	"bytes"
 	"fmt"
@@ -25,20 +22,20 @@ func DaysUntil(dateStr string) (days int, err error) {
 }
 
 // ExtractPage extracts content from a given URL - used for templates.
-func ExtractPageContent(c appengine.Context, URL, start_token, end_token string) (content template.HTML, err error) {
+func ExtractPageContent(c Context, URL, start_token, end_token string) (content template.HTML, err error) {
 	key := fmt.Sprintf("%s-%s-%s", URL, start_token, end_token)
 
-	if item, err := memcache.Get(c, key); err == memcache.ErrCacheMiss {
+	if value, err := c.Cache().Get(key); err == ErrCacheMiss {
 		c.Infof("URL %s not in the cache", URL)
 	} else if err != nil {
 		c.Errorf("error getting URL: %v", err)
 	} else {
 		c.Infof("Returning cached contents of  %s", URL)
-		return template.HTML(item.Value), nil
+		return template.HTML(value), nil
 	}
 
 	c.Infof("Key %s not in the cache - fetching!", key)
-	client := urlfetch.Client(c)
+	client := c.Client()
 	resp, err := client.Get(URL)
 	if err != nil {
 		c.Errorf("error fetching %s: %v", URL, err)
@@ -0,0 +1,272 @@
+// Deleting entries and links: they go to the trash first, and can be restored
+// until they expire or are purged.
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Trashed entry struct, stored in Datastore.
+type TrashedEntry struct {
+	SavedEntry
+	DeletedDate time.Time
+	DeletedBy   string
+	// What the entry's revisions are kept under while it is in the trash, so
+	// that they stay apart from those of a new entry given its slug. Empty
+	// for entries trashed before it was kept, whose revisions kept the slug.
+	HistorySlug string
+}
+
+// Trashed link struct, stored in Datastore.
+type TrashedLink struct {
+	SavedLink
+	DeletedDate time.Time
+	DeletedBy   string
+}
+
+// trashDuration returns how long deleted items can be restored for.
+func trashDuration() time.Duration {
+	days, err := config.GetInt("trash_days")
+	if err != nil {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ExpiresDate returns when a trashed entry will be purged.
+func (t TrashedEntry) ExpiresDate() time.Time {
+	return t.DeletedDate.Add(trashDuration())
+}
+
+// ExpiresDate returns when a trashed link will be purged.
+func (t TrashedLink) ExpiresDate() time.Time {
+	return t.DeletedDate.Add(trashDuration())
+}
+
+// TrashEntry moves an entry or page to the trash.
+func TrashEntry(c Context, slug string) error {
+	entry, err := GetSingleEntry(c, slug)
+	if err != nil {
+		return err
+	}
+	// Store the trashed copy first, so that a failure never loses the entry.
+	trashed := TrashedEntry{SavedEntry: entry, DeletedDate: time.Now(), DeletedBy: c.CurrentUser()}
+	trashed.HistorySlug = trashHistorySlug(slug, trashed.DeletedDate)
+	if err := c.Store().PutTrashedEntry(&trashed); err != nil {
+		return err
+	}
+	if err := moveRevisions(c, slug, trashed.HistorySlug); err != nil {
+		return err
+	}
+	if err := UnindexEntry(c, slug); err != nil {
+		return err
+	}
+	return c.Store().DeleteEntry(slug)
+}
+
+// RestoreEntry moves an entry or page back out of the trash.
+func RestoreEntry(c Context, slug string) (SavedEntry, error) {
+	trashed, err := c.Store().GetTrashedEntry(slug)
+	if err != nil {
+		return trashed.SavedEntry, err
+	}
+	if _, err := GetSingleEntry(c, slug); err == nil {
+		return trashed.SavedEntry, fmt.Errorf("an entry named %s already exists", slug)
+	}
+	if err := PutEntry(c, &trashed.SavedEntry); err != nil {
+		return trashed.SavedEntry, err
+	}
+	if trashed.HistorySlug != "" {
+		if err := moveRevisions(c, trashed.HistorySlug, slug); err != nil {
+			return trashed.SavedEntry, err
+		}
+	}
+	if err := IndexEntry(c, &trashed.SavedEntry); err != nil {
+		return trashed.SavedEntry, err
+	}
+	return trashed.SavedEntry, c.Store().PurgeTrashedEntry(slug)
+}
+
+// trashHistorySlug returns what the revisions of an entry are kept under
+// while it is in the trash. No entry can have it as its slug.
+func trashHistorySlug(slug string, deleted time.Time) string {
+	return fmt.Sprintf("%s@trash-%d", slug, deleted.UnixNano())
+}
+
+// PurgeEntry permanently removes an entry from the trash, along with its history.
+func PurgeEntry(c Context, slug string) error {
+	trashed, err := c.Store().GetTrashedEntry(slug)
+	if err != nil {
+		return err
+	}
+	if err := c.Store().PurgeTrashedEntry(slug); err != nil {
+		return err
+	}
+	if trashed.HistorySlug != "" {
+		return c.Store().DeleteRevisions(trashed.HistorySlug)
+	}
+	// Entries trashed before HistorySlug was kept share their slug's history,
+	// which now belongs to any entry that has since been given the slug.
+	if _, err := GetSingleEntry(c, slug); err == nil {
+		c.Infof("Keeping the history of %s, which belongs to a live entry", slug)
+		return nil
+	}
+	return c.Store().DeleteRevisions(slug)
+}
+
+// TrashLink moves a link to the trash.
+func TrashLink(c Context, url string) error {
+	links, err := GetLinks(c)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.URL == url {
+			trashed := TrashedLink{SavedLink: link, DeletedDate: time.Now(), DeletedBy: c.CurrentUser()}
+			if err := c.Store().PutTrashedLink(&trashed); err != nil {
+				return err
+			}
+			return c.Store().DeleteLink(url)
+		}
+	}
+	return ErrNoSuchEntity
+}
+
+// RestoreLink moves a link back out of the trash.
+func RestoreLink(c Context, url string) error {
+	trashed, err := c.Store().GetTrashedLink(url)
+	if err != nil {
+		return err
+	}
+	if err := PutLink(c, &trashed.SavedLink); err != nil {
+		return err
+	}
+	return c.Store().PurgeTrashedLink(url)
+}
+
+// PurgeExpiredTrash permanently removes everything that has been in the
+// trash for longer than trash_days.
+func PurgeExpiredTrash(c Context) (purged int, err error) {
+	now := time.Now()
+	entries, err := c.Store().GetTrashedEntries()
+	if err != nil {
+		return purged, err
+	}
+	for _, t := range entries {
+		if t.ExpiresDate().Before(now) {
+			if err := PurgeEntry(c, t.Slug); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	links, err := c.Store().GetTrashedLinks()
+	if err != nil {
+		return purged, err
+	}
+	for _, t := range links {
+		if t.ExpiresDate().Before(now) {
+			if err := c.Store().PurgeTrashedLink(t.URL); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// handler for /admin/trash
+func adminTrashHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	context, _ := GetTemplateContext(nil, nil, "Trash", "admin_trash", r)
+	context.TrashedEntries, _ = c.Store().GetTrashedEntries()
+	context.TrashedLinks, _ = c.Store().GetTrashedLinks()
+	renderTemplate(w, *adminTrashTpl, context)
+}
+
+// handler for /admin/delete_entry - moves an entry or page to the trash
+func adminDeleteEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Deleting requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	slug := strings.TrimSpace(r.FormValue("slug"))
+	if entry, err := GetSingleEntry(c, slug); err == nil && !canEdit(c, &entry) {
+		http.Error(w, "Only editors can delete published entries, or other people's", http.StatusForbidden)
+		return
+	}
+	if err := TrashEntry(c, slug); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Moved %s to the trash", slug)
+	c.Cache().Flush()
+	http.Redirect(w, r, fmt.Sprintf("/admin/trash?deleted=%s", slug), http.StatusFound)
+}
+
+// handler for /admin/delete_link - moves a link to the trash
+func adminDeleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Deleting requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	url := strings.TrimSpace(r.FormValue("url"))
+	if err := TrashLink(c, url); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Infof("Moved link %s to the trash", url)
+	c.Cache().Flush()
+	http.Redirect(w, r, "/admin/links", http.StatusFound)
+}
+
+// handler for /admin/submit_trash - restores or purges trashed items
+func adminSubmitTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Changing the trash requires a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	c := contextFor(r)
+	slug := strings.TrimSpace(r.FormValue("slug"))
+	url := strings.TrimSpace(r.FormValue("url"))
+
+	var err error
+	switch r.FormValue("action") {
+	case "restore":
+		if slug != "" {
+			_, err = RestoreEntry(c, slug)
+		} else {
+			err = RestoreLink(c, url)
+		}
+	case "purge":
+		if slug != "" {
+			err = PurgeEntry(c, slug)
+		} else {
+			err = c.Store().PurgeTrashedLink(url)
+		}
+	default:
+		err = fmt.Errorf("unknown action: %q", r.FormValue("action"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Cache().Flush()
+	http.Redirect(w, r, "/admin/trash", http.StatusFound)
+}
+
+// HTTP handler for /cron/purge_trash, run by cron.yaml.
+func cronPurgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	purged, err := PurgeExpiredTrash(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Purged %d expired items from the trash\n", purged)
+}
@@ -0,0 +1,88 @@
+package blog
+
+import (
+	"testing"
+)
+
+// saveRevision records the current state of e as a revision, failing the
+// test if it can't.
+func saveRevision(t *testing.T, c Context, e SavedEntry) {
+	t.Helper()
+	rev := newRevision(&e, e.Author, e.PublishDate)
+	if err := PutRevision(c, &rev); err != nil {
+		t.Fatalf("PutRevision(%s): %v", e.Slug, err)
+	}
+}
+
+func TestTrashAndRestoreEntry(t *testing.T) {
+	c := newTestContext("editor")
+	e := testEntry("gone", 1)
+	putEntries(t, c, e)
+	saveRevision(t, c, e)
+
+	if err := TrashEntry(c, "gone"); err != nil {
+		t.Fatalf("TrashEntry: %v", err)
+	}
+	if _, err := GetSingleEntry(c, "gone"); err != ErrNoSuchEntity {
+		t.Errorf("GetSingleEntry after TrashEntry error = %v, want ErrNoSuchEntity", err)
+	}
+	if revisions, _ := GetRevisions(c, "gone"); len(revisions) != 0 {
+		t.Errorf("got %d revisions under the slug of a trashed entry, want 0", len(revisions))
+	}
+
+	if _, err := RestoreEntry(c, "gone"); err != nil {
+		t.Fatalf("RestoreEntry: %v", err)
+	}
+	if _, err := GetSingleEntry(c, "gone"); err != nil {
+		t.Errorf("GetSingleEntry after RestoreEntry: %v", err)
+	}
+	if revisions, _ := GetRevisions(c, "gone"); len(revisions) != 1 {
+		t.Errorf("got %d revisions after RestoreEntry, want 1", len(revisions))
+	}
+	if _, err := c.Store().GetTrashedEntry("gone"); err != ErrNoSuchEntity {
+		t.Errorf("GetTrashedEntry after RestoreEntry error = %v, want ErrNoSuchEntity", err)
+	}
+}
+
+func TestPurgeEntryKeepsTheHistoryOfANewEntryWithItsSlug(t *testing.T) {
+	c := newTestContext("editor")
+	old := testEntry("reused", 10)
+	putEntries(t, c, old)
+	saveRevision(t, c, old)
+	if err := TrashEntry(c, "reused"); err != nil {
+		t.Fatalf("TrashEntry: %v", err)
+	}
+
+	// A new entry takes the slug, and has history of its own.
+	replacement := testEntry("reused", 0)
+	replacement.Title = "Replacement"
+	putEntries(t, c, replacement)
+	saveRevision(t, c, replacement)
+
+	if err := PurgeEntry(c, "reused"); err != nil {
+		t.Fatalf("PurgeEntry: %v", err)
+	}
+	revisions, _ := GetRevisions(c, "reused")
+	if len(revisions) != 1 || revisions[0].Title != "Replacement" {
+		t.Errorf("got revisions %v after PurgeEntry, want just the replacement's", revisions)
+	}
+}
+
+func TestPurgeEntryTrashedBeforeHistorySlugs(t *testing.T) {
+	c := newTestContext("editor")
+	live := testEntry("shared", 0)
+	putEntries(t, c, live)
+	saveRevision(t, c, live)
+	// Trashed by an older version, which left its history under the slug.
+	trashed := TrashedEntry{SavedEntry: testEntry("shared", 10)}
+	if err := c.Store().PutTrashedEntry(&trashed); err != nil {
+		t.Fatalf("PutTrashedEntry: %v", err)
+	}
+
+	if err := PurgeEntry(c, "shared"); err != nil {
+		t.Fatalf("PurgeEntry: %v", err)
+	}
+	if revisions, _ := GetRevisions(c, "shared"); len(revisions) != 1 {
+		t.Errorf("got %d revisions after PurgeEntry, want the live entry's 1", len(revisions))
+	}
+}
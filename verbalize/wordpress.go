@@ -0,0 +1,359 @@
+// Importing posts and pages from a WordPress export (WXR) file.
+package blog
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Layout of the dates in a WXR file.
+	WXR_DATE_LAYOUT = "2006-01-02 15:04:05"
+	// What WordPress leaves in the GMT date of a post that was never published.
+	WXR_ZERO_DATE = "0000-00-00 00:00:00"
+	// Largest WXR file we accept, in bytes.
+	MAX_WXR_SIZE = 32 << 20
+)
+
+var (
+	// WordPress post statuses, as the workflow status they are imported with.
+	// Anything else, such as "trash" or "auto-draft", is skipped.
+	wordpress_statuses = map[string]string{
+		"publish": STATUS_PUBLISHED,
+		"future":  STATUS_SCHEDULED,
+		"pending": STATUS_REVIEW,
+		"draft":   STATUS_DRAFT,
+		"private": STATUS_DRAFT,
+	}
+
+	// regexp matching anything that can't be in a slug
+	non_slug_re = regexp.MustCompile(`[^a-z0-9_-]+`)
+	// regexp matching the blank lines between paragraphs
+	blank_line_re = regexp.MustCompile(`\n\s*\n`)
+	// regexp matching the start of a paragraph that is already a block element
+	block_start_re = regexp.MustCompile(`^<(?i:p|div|h[1-6]|ul|ol|li|blockquote|pre|table|dl|hr|figure|iframe|object|script|style|form|address)\b`)
+)
+
+// The parts of a WXR file we import.
+type wxrFile struct {
+	Authors []wxrAuthor `xml:"channel>author"`
+	Items   []wxrItem   `xml:"channel>item"`
+}
+
+type wxrAuthor struct {
+	Login       string `xml:"author_login"`
+	Email       string `xml:"author_email"`
+	DisplayName string `xml:"author_display_name"`
+}
+
+type wxrItem struct {
+	Title         string        `xml:"title"`
+	Creator       string        `xml:"creator"`
+	Content       string        `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	PostName      string        `xml:"post_name"`
+	PostType      string        `xml:"post_type"`
+	Status        string        `xml:"status"`
+	PostDate      string        `xml:"post_date"`
+	PostDateGMT   string        `xml:"post_date_gmt"`
+	CommentStatus string        `xml:"comment_status"`
+	Categories    []wxrCategory `xml:"category"`
+
+	// Only in exports from newer versions of WordPress.
+	PostModified    string `xml:"post_modified"`
+	PostModifiedGMT string `xml:"post_modified_gmt"`
+}
+
+type wxrCategory struct {
+	Domain string `xml:"domain,attr"`
+	Name   string `xml:",chardata"`
+}
+
+// What happened, or would happen, to an item of a WXR file.
+type ImportItem struct {
+	Title  string
+	Slug   string
+	Type   string
+	Status string
+	// Why the item was skipped, or "" if it was imported.
+	Skipped string
+}
+
+// ImportReport describes an import of a WXR file, or what one would do.
+type ImportReport struct {
+	DryRun  bool
+	Items   []ImportItem
+	Authors []SavedAuthor
+	// How many items were (or would be) imported and skipped.
+	Imported int
+	Skipped  int
+}
+
+// parseWXR reads a WXR file.
+func parseWXR(r io.Reader) (wxr wxrFile, err error) {
+	decoder := xml.NewDecoder(r)
+	// Exports are UTF-8, but don't let a mislabelled one stop us.
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	err = decoder.Decode(&wxr)
+	return
+}
+
+// slugify makes a slug out of a title, for items WordPress never gave one.
+func slugify(title string) string {
+	slug := non_slug_re.ReplaceAllString(strings.ToLower(stripTags(title)), "-")
+	return strings.Trim(slug, "-")
+}
+
+// autoParagraph wraps the blank line separated paragraphs of WordPress content
+// in <p> tags, as WordPress does when showing it, and turns the line breaks
+// inside them into <br>.
+func autoParagraph(content string) string {
+	content = strings.Replace(strings.TrimSpace(content), "\r\n", "\n", -1)
+	var out []string
+	for _, para := range blank_line_re.Split(content, -1) {
+		para = strings.TrimSpace(para)
+		switch {
+		case para == "":
+			continue
+		case block_start_re.MatchString(para) || strings.HasPrefix(para, "<!--"):
+			out = append(out, para)
+		default:
+			out = append(out, "<p>"+strings.Replace(para, "\n", "<br>\n", -1)+"</p>")
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// wxrPublishDate returns when an item was published, preferring the GMT date
+// WordPress records and falling back to its local one. Items with neither
+// are left undated, and dated when they are published.
+func wxrPublishDate(item *wxrItem) (time.Time, error) {
+	if item.PostDateGMT != "" && item.PostDateGMT != WXR_ZERO_DATE {
+		return time.Parse(WXR_DATE_LAYOUT, item.PostDateGMT)
+	}
+	if item.PostDate != "" && item.PostDate != WXR_ZERO_DATE {
+		return time.ParseInLocation(WXR_DATE_LAYOUT, item.PostDate, location)
+	}
+	return time.Time{}, nil
+}
+
+// wxrModifiedDate returns when an item was last modified, or zero if the
+// export doesn't say or says something we can't read.
+func wxrModifiedDate(item *wxrItem) time.Time {
+	if item.PostModifiedGMT != "" && item.PostModifiedGMT != WXR_ZERO_DATE {
+		if t, err := time.Parse(WXR_DATE_LAYOUT, item.PostModifiedGMT); err == nil {
+			return t
+		}
+	}
+	if item.PostModified != "" && item.PostModified != WXR_ZERO_DATE {
+		if t, err := time.ParseInLocation(WXR_DATE_LAYOUT, item.PostModified, location); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// wxrEntry turns a WXR item into an entry by the user of an author, or
+// returns why it can't be imported.
+func wxrEntry(item *wxrItem, users map[string]string) (entry SavedEntry, skipped string) {
+	if item.PostType != "post" && item.PostType != "page" {
+		return entry, fmt.Sprintf("%s items aren't imported", item.PostType)
+	}
+	status, ok := wordpress_statuses[item.Status]
+	if !ok {
+		return entry, fmt.Sprintf("%s items aren't imported", item.Status)
+	}
+	publish_date, err := wxrPublishDate(item)
+	if err != nil {
+		return entry, fmt.Sprintf("Invalid publish date: %v", err)
+	}
+
+	entry = SavedEntry{
+		Author:        users[item.Creator],
+		IsPage:        item.PostType == "page",
+		AllowComments: item.CommentStatus == "open",
+		PublishDate:   publish_date,
+		UpdatedDate:   wxrModifiedDate(item),
+		Title:         strings.TrimSpace(item.Title),
+		Content:       []byte(autoParagraph(item.Content)),
+		Slug:          item.PostName,
+	}
+	if entry.Author == "" {
+		entry.Author = item.Creator
+	}
+	if entry.Slug == "" {
+		entry.Slug = slugify(entry.Title)
+	}
+	for _, category := range item.Categories {
+		switch category.Domain {
+		case "post_tag":
+			if tag := normalizeTag(category.Name); tag != "" && !hasTag(entry.Tags, tag) {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		case "category":
+			if name := strings.TrimSpace(category.Name); name != "" && !hasTag(entry.Categories, name) {
+				entry.Categories = append(entry.Categories, name)
+			}
+		}
+	}
+	entry.setStatus(status)
+	entry.setRelativeURL()
+	entry.Version = 1
+
+	if entry.Title == "" {
+		return entry, "No title"
+	}
+	if problem := validateSlug(entry.Slug); problem != "" {
+		return entry, problem
+	}
+	return entry, ""
+}
+
+// wxrAuthors returns the user each WordPress login is imported as, and the
+// profiles that need to be created for logins without one. Authors are known
+// by their email address, as that is what CurrentUser returns on App Engine.
+func wxrAuthors(c Context, wxr *wxrFile) (users map[string]string, created []SavedAuthor) {
+	users = make(map[string]string)
+	// Logins that differ only in case or punctuation have the same ID, so the
+	// IDs of the profiles being created are taken as well as the stored ones.
+	creating, creating_users := make(map[string]bool), make(map[string]bool)
+	free := func(id string) bool {
+		if creating[id] || validateSlug(id) != "" {
+			return false
+		}
+		_, err := GetAuthor(c, id)
+		return err != nil
+	}
+	for _, a := range wxr.Authors {
+		user := a.Email
+		if user == "" {
+			user = a.Login
+		}
+		if _, ok := users[a.Login]; ok {
+			continue
+		}
+		users[a.Login] = user
+		if creating_users[user] {
+			continue
+		}
+		if _, err := GetAuthorByUser(c, user); err == nil {
+			continue
+		}
+		base := authorID(a.Login)
+		if !free(base) {
+			base = authorID(user)
+		}
+		id := base
+		for i := 2; !free(id) && i <= MAX_SLUG_SUFFIX; i++ {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		if !free(id) {
+			c.Errorf("No free profile ID for %s, importing their entries without one", user)
+			continue
+		}
+		creating[id], creating_users[user] = true, true
+		created = append(created, SavedAuthor{ID: id, User: user, Role: ROLE_CONTRIBUTOR, DisplayName: a.DisplayName})
+	}
+	return users, created
+}
+
+// ImportWXR imports the posts and pages of a WXR file, or with dryRun only
+// reports what it would do. Items whose slug is already taken are skipped
+// rather than overwriting anything.
+func ImportWXR(c Context, wxr *wxrFile, dryRun bool) (report ImportReport, err error) {
+	report.DryRun = dryRun
+	users, authors := wxrAuthors(c, wxr)
+	report.Authors = authors
+	if !dryRun {
+		for _, a := range authors {
+			if err := PutAuthor(c, &a); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	// Indexed together at the end, however the import ends, so that
+	// which posts are related doesn't depend on the order of the file.
+	var imported []SavedEntry
+	defer func() {
+		if err := indexEntries(c, imported); err != nil {
+			c.Errorf("Unable to index imported entries: %v", err)
+		}
+	}()
+
+	seen := make(map[string]bool)
+	for _, item := range wxr.Items {
+		entry, skipped := wxrEntry(&item, users)
+		if skipped == "" && (seen[entry.Slug] || slugTaken(c, entry.Slug)) {
+			skipped = fmt.Sprintf("There is already an entry named %q", entry.Slug)
+		}
+		if skipped == "" && !dryRun {
+			err := CreateEntry(c, &entry)
+			if err == ErrEntityExists {
+				skipped = fmt.Sprintf("There is already an entry named %q", entry.Slug)
+			} else if err != nil {
+				return report, err
+			} else {
+				if err := saveEntryRevision(c, &entry, nil); err != nil {
+					c.Errorf("Unable to save revision of %s: %v", entry.Slug, err)
+				}
+				imported = append(imported, entry)
+			}
+		}
+
+		report.Items = append(report.Items, ImportItem{
+			Title:   item.Title,
+			Slug:    entry.Slug,
+			Type:    item.PostType,
+			Status:  entry.Status,
+			Skipped: skipped,
+		})
+		if skipped != "" {
+			report.Skipped++
+			continue
+		}
+		seen[entry.Slug] = true
+		report.Imported++
+	}
+	return report, nil
+}
+
+// handler for /admin/import - imports a WordPress export, or shows what an
+// import of one would do
+func adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	c := contextFor(r)
+	context, _ := GetTemplateContext(nil, nil, "Import", "admin_import", r)
+
+	if r.Method == "POST" {
+		file, _, err := r.FormFile("wxr")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("No WXR file: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		wxr, err := parseWXR(io.LimitReader(file, MAX_WXR_SIZE))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Unable to read WXR file: %v", err), http.StatusBadRequest)
+			return
+		}
+		report, err := ImportWXR(c, &wxr, r.FormValue("dry_run") == "on")
+		if err != nil {
+			c.Cache().Flush()
+			http.Error(w, fmt.Sprintf("Import stopped after %d items: %v", report.Imported, err), http.StatusInternalServerError)
+			return
+		}
+		if !report.DryRun {
+			c.Infof("Imported %d WordPress items, skipped %d", report.Imported, report.Skipped)
+			c.Cache().Flush()
+		}
+		context.Import = &report
+	}
+	renderTemplate(w, *adminImportTpl, context)
+}
@@ -0,0 +1,243 @@
+package blog
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWXR = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<wp:author>
+		<wp:author_login>bob</wp:author_login>
+		<wp:author_email>bob@example.com</wp:author_email>
+		<wp:author_display_name>Bob</wp:author_display_name>
+	</wp:author>
+	<item>
+		<title>Hello world</title>
+		<dc:creator>bob</dc:creator>
+		<content:encoded><![CDATA[First line
+second line
+
+<h2>A heading</h2>]]></content:encoded>
+		<wp:post_name>hello-world</wp:post_name>
+		<wp:post_type>post</wp:post_type>
+		<wp:status>publish</wp:status>
+		<wp:post_date>2012-03-04 05:06:07</wp:post_date>
+		<wp:post_date_gmt>2012-03-04 13:06:07</wp:post_date_gmt>
+		<wp:post_modified_gmt>2012-04-01 00:00:00</wp:post_modified_gmt>
+		<wp:comment_status>open</wp:comment_status>
+		<category domain="post_tag" nicename="bikes">Bikes</category>
+		<category domain="category" nicename="rides">Rides</category>
+	</item>
+	<item>
+		<title>Unfinished</title>
+		<dc:creator>bob</dc:creator>
+		<content:encoded><![CDATA[Not yet.]]></content:encoded>
+		<wp:post_type>post</wp:post_type>
+		<wp:status>draft</wp:status>
+		<wp:post_date>0000-00-00 00:00:00</wp:post_date>
+		<wp:post_date_gmt>0000-00-00 00:00:00</wp:post_date_gmt>
+	</item>
+	<item>
+		<title>About</title>
+		<dc:creator>alice</dc:creator>
+		<content:encoded><![CDATA[<p>About me.</p>]]></content:encoded>
+		<wp:post_name>about</wp:post_name>
+		<wp:post_type>page</wp:post_type>
+		<wp:status>publish</wp:status>
+		<wp:post_date_gmt>2011-01-01 00:00:00</wp:post_date_gmt>
+	</item>
+	<item>
+		<title>photo.jpg</title>
+		<wp:post_name>photo</wp:post_name>
+		<wp:post_type>attachment</wp:post_type>
+		<wp:status>inherit</wp:status>
+	</item>
+	<item>
+		<title>Thrown away</title>
+		<wp:post_name>thrown-away</wp:post_name>
+		<wp:post_type>post</wp:post_type>
+		<wp:status>trash</wp:status>
+	</item>
+	<item>
+		<title>Hello again</title>
+		<wp:post_name>hello-world</wp:post_name>
+		<wp:post_type>post</wp:post_type>
+		<wp:status>publish</wp:status>
+		<wp:post_date_gmt>2012-05-01 00:00:00</wp:post_date_gmt>
+	</item>
+	<item>
+		<title>Taken</title>
+		<wp:post_name>taken</wp:post_name>
+		<wp:post_type>post</wp:post_type>
+		<wp:status>publish</wp:status>
+		<wp:post_date_gmt>2012-05-01 00:00:00</wp:post_date_gmt>
+	</item>
+</channel>
+</rss>`
+
+func TestAutoParagraph(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"one", "<p>one</p>"},
+		{"one\r\n\r\ntwo", "<p>one</p>\n<p>two</p>"},
+		{"one\ntwo", "<p>one<br>\ntwo</p>"},
+		{"<h2>Title</h2>\n\ntext", "<h2>Title</h2>\n<p>text</p>"},
+		{"<!-- more -->\n\n<ul><li>a</li></ul>", "<!-- more -->\n<ul><li>a</li></ul>"},
+		{"\n\n  \n\n", ""},
+	}
+	for _, tt := range tests {
+		if got := autoParagraph(tt.in); got != tt.want {
+			t.Errorf("autoParagraph(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Hello World":           "hello-world",
+		"  What's <em>up</em>?": "what-s-up",
+		"2012: a year":          "2012-a-year",
+	}
+	for title, want := range tests {
+		if got := slugify(title); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+// importTestWXR imports testWXR into a store that already has an entry
+// named "taken".
+func importTestWXR(t *testing.T, dryRun bool) (Context, ImportReport) {
+	t.Helper()
+	c := newTestContext("admin")
+	putEntries(t, c, testEntry("taken", 1))
+	wxr, err := parseWXR(strings.NewReader(testWXR))
+	if err != nil {
+		t.Fatalf("parseWXR: %v", err)
+	}
+	report, err := ImportWXR(c, &wxr, dryRun)
+	if err != nil {
+		t.Fatalf("ImportWXR: %v", err)
+	}
+	return c, report
+}
+
+func TestImportWXRReport(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		_, report := importTestWXR(t, dryRun)
+		if report.Imported != 3 || report.Skipped != 4 {
+			t.Errorf("dry run %t: imported %d and skipped %d, want 3 and 4", dryRun, report.Imported, report.Skipped)
+		}
+		var skipped []string
+		for _, item := range report.Items {
+			if item.Skipped != "" {
+				skipped = append(skipped, item.Title)
+			}
+		}
+		if want := []string{"photo.jpg", "Thrown away", "Hello again", "Taken"}; !equalStrings(skipped, want) {
+			t.Errorf("dry run %t: skipped %v, want %v", dryRun, skipped, want)
+		}
+		if len(report.Authors) != 1 || report.Authors[0].User != "bob@example.com" || report.Authors[0].Role != ROLE_CONTRIBUTOR {
+			t.Errorf("dry run %t: authors = %+v, want a contributor profile for bob@example.com", dryRun, report.Authors)
+		}
+	}
+}
+
+func TestImportWXRDryRunChangesNothing(t *testing.T) {
+	c, _ := importTestWXR(t, true)
+	for _, slug := range []string{"hello-world", "unfinished", "about"} {
+		if _, err := c.Store().GetSingleEntry(slug); err != ErrNoSuchEntity {
+			t.Errorf("GetSingleEntry(%s) after a dry run error = %v, want ErrNoSuchEntity", slug, err)
+		}
+	}
+	if _, err := GetAuthorByUser(c, "bob@example.com"); err == nil {
+		t.Errorf("a dry run created a profile for bob@example.com")
+	}
+}
+
+func TestImportWXREntries(t *testing.T) {
+	c, _ := importTestWXR(t, false)
+
+	hello, err := c.Store().GetSingleEntry("hello-world")
+	if err != nil {
+		t.Fatalf("GetSingleEntry(hello-world): %v", err)
+	}
+	if want := time.Date(2012, 3, 4, 13, 6, 7, 0, time.UTC); !hello.PublishDate.Equal(want) {
+		t.Errorf("PublishDate = %s, want %s", hello.PublishDate, want)
+	}
+	if want := time.Date(2012, 4, 1, 0, 0, 0, 0, time.UTC); !hello.UpdatedDate.Equal(want) {
+		t.Errorf("UpdatedDate = %s, want %s", hello.UpdatedDate, want)
+	}
+	if hello.Author != "bob@example.com" || !hello.AllowComments || hello.CurrentStatus() != STATUS_PUBLISHED {
+		t.Errorf("hello-world by %q, comments %t, %s", hello.Author, hello.AllowComments, hello.CurrentStatus())
+	}
+	if want := "<p>First line<br>\nsecond line</p>\n<h2>A heading</h2>"; string(hello.Content) != want {
+		t.Errorf("Content = %q, want %q", hello.Content, want)
+	}
+	if !equalStrings(hello.Tags, []string{"bikes"}) || !equalStrings(hello.Categories, []string{"Rides"}) {
+		t.Errorf("Tags = %v and Categories = %v, want [bikes] and [Rides]", hello.Tags, hello.Categories)
+	}
+	if hello.RelativeURL != "2012/03/hello-world" {
+		t.Errorf("RelativeURL = %q", hello.RelativeURL)
+	}
+
+	draft, err := c.Store().GetSingleEntry("unfinished")
+	if err != nil {
+		t.Fatalf("GetSingleEntry(unfinished): %v", err)
+	}
+	if draft.CurrentStatus() != STATUS_DRAFT || !draft.PublishDate.IsZero() {
+		t.Errorf("unfinished is %s, dated %s; want an undated draft", draft.CurrentStatus(), draft.PublishDate)
+	}
+
+	about, err := c.Store().GetSingleEntry("about")
+	if err != nil {
+		t.Fatalf("GetSingleEntry(about): %v", err)
+	}
+	if !about.IsPage || about.Author != "alice" || about.RelativeURL != "about" {
+		t.Errorf("about: page %t, by %q, at %q", about.IsPage, about.Author, about.RelativeURL)
+	}
+	if taken, _ := c.Store().GetSingleEntry("taken"); taken.Title != "taken" {
+		t.Errorf("importing overwrote taken, whose title is now %q", taken.Title)
+	}
+	if _, err := GetAuthorByUser(c, "bob@example.com"); err != nil {
+		t.Errorf("GetAuthorByUser(bob@example.com): %v", err)
+	}
+}
+
+func TestWXRAuthorsGetProfilesOfTheirOwn(t *testing.T) {
+	c := newTestContext("admin")
+	putAuthor(t, c, "bob@elsewhere.com", ROLE_EDITOR)
+	wxr := wxrFile{Authors: []wxrAuthor{
+		{Login: "bob", Email: "bob@a.com"},
+		{Login: "Bob", Email: "bob@b.com"},
+		{Login: "b.o.b", Email: "bob@c.com"},
+		{Login: "bob", Email: "bob@d.com"},
+		{Login: "carol", Email: "carol@a.com"},
+		{Login: "carol2", Email: "carol@a.com"},
+	}}
+	users, created := wxrAuthors(c, &wxr)
+	if users["Bob"] != "bob@b.com" || users["carol2"] != "carol@a.com" {
+		t.Errorf("users = %v", users)
+	}
+	ids := make(map[string]string)
+	for _, a := range created {
+		if other, ok := ids[a.ID]; ok {
+			t.Errorf("%s and %s would both be given the profile %s", other, a.User, a.ID)
+		}
+		ids[a.ID] = a.User
+	}
+	if len(created) != 4 || ids["carol"] != "carol@a.com" {
+		t.Errorf("created profiles %v, want one each for three Bobs and Carol", ids)
+	}
+	if _, ok := ids["bob"]; ok {
+		t.Errorf("an imported author was given the existing profile bob")
+	}
+}
@@ -0,0 +1,105 @@
+// Editorial workflow: entries move from draft to review to published, and only
+// editors may put them in front of readers or take them away again.
+package blog
+
+import (
+	"time"
+)
+
+const (
+	STATUS_DRAFT     = "draft"
+	STATUS_REVIEW    = "review"
+	STATUS_SCHEDULED = "scheduled"
+	STATUS_PUBLISHED = "published"
+)
+
+var (
+	// Statuses in workflow order, for the admin list filters.
+	statuses = []string{STATUS_DRAFT, STATUS_REVIEW, STATUS_SCHEDULED, STATUS_PUBLISHED}
+
+	status_labels = map[string]string{
+		STATUS_DRAFT:     "Draft",
+		STATUS_REVIEW:    "In review",
+		STATUS_SCHEDULED: "Scheduled",
+		STATUS_PUBLISHED: "Published",
+	}
+
+	// Statuses that anyone may move an entry between; only editors may move
+	// one into or out of anything else. "" is an entry that isn't saved yet.
+	unpublished_statuses = map[string]bool{"": true, STATUS_DRAFT: true, STATUS_REVIEW: true}
+)
+
+// StatusLabel returns how a status is shown in the admin.
+func StatusLabel(status string) string {
+	return status_labels[status]
+}
+
+// CurrentStatus returns the workflow status of an entry. Entries saved before
+// there was a Status are worked out from IsHidden and IsScheduled.
+func (s *SavedEntry) CurrentStatus() string {
+	switch {
+	case s.Status != "":
+		return s.Status
+	case s.IsHidden:
+		return STATUS_DRAFT
+	case s.IsScheduled:
+		return STATUS_SCHEDULED
+	}
+	return STATUS_PUBLISHED
+}
+
+// setStatus moves an entry to a status, and keeps the IsHidden and IsScheduled
+// flags that queries filter on in step with it. Entries are dated when they
+// are first published, so drafts have no PublishDate until then. Published
+// entries with a PublishDate still to come are scheduled instead.
+func (s *SavedEntry) setStatus(status string) {
+	if !unpublished_statuses[status] && s.PublishDate.IsZero() {
+		s.PublishDate = time.Now()
+	}
+	if status == STATUS_PUBLISHED && !s.IsPublished() {
+		status = STATUS_SCHEDULED
+	}
+	if status == STATUS_SCHEDULED && s.IsPublished() {
+		status = STATUS_PUBLISHED
+	}
+	s.Status = status
+	s.IsHidden = unpublished_statuses[status]
+	s.IsScheduled = status == STATUS_SCHEDULED
+}
+
+// isPublisher returns true if the current user may publish entries: editors
+// and admins may, contributors may not.
+func isPublisher(c Context) bool {
+	return hasRole(c, ROLE_EDITOR)
+}
+
+// mayTouch returns true if the current user may work on an entry at all.
+// Contributors may only work on their own.
+func mayTouch(c Context, e *SavedEntry) bool {
+	return hasRole(c, ROLE_EDITOR) || e.Author == c.CurrentUser()
+}
+
+// canChangeStatus returns true if the current user may save an entry that is
+// in status from as status to.
+func canChangeStatus(c Context, from string, to string) bool {
+	if unpublished_statuses[from] && unpublished_statuses[to] {
+		return true
+	}
+	return isPublisher(c)
+}
+
+// canEdit returns true if the current user may change an entry as it is.
+func canEdit(c Context, e *SavedEntry) bool {
+	return mayTouch(c, e) && canChangeStatus(c, e.CurrentStatus(), e.CurrentStatus())
+}
+
+// countUnmigrated returns how many entries have yet to be given a Status by
+// the 2014-05-entry-status migration.
+func countUnmigrated(entries []SavedEntry) (count int) {
+	for _, entry := range entries {
+		if entry.Status == "" {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,88 @@
+package blog
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// submitEntry saves an entry through the edit form as user, failing the
+// test unless it is saved.
+func submitEntry(t *testing.T, c Context, user string, form url.Values) SavedEntry {
+	t.Helper()
+	w := serve(adminSubmitEntryHandler, "POST", "/admin/submit", form, user)
+	if w.Code != http.StatusFound {
+		t.Fatalf("submitting %s gave %d: %s", form.Get("slug"), w.Code, w.Body)
+	}
+	entry, err := c.Store().GetSingleEntry(form.Get("slug"))
+	if err != nil {
+		t.Fatalf("GetSingleEntry(%s): %v", form.Get("slug"), err)
+	}
+	return entry
+}
+
+func TestEntriesAreDatedWhenFirstPublished(t *testing.T) {
+	c := newTestContext("editor")
+	form := url.Values{
+		"title":       {"Work in progress"},
+		"slug":        {"work-in-progress"},
+		"content":     {"Not finished yet."},
+		"status":      {STATUS_DRAFT},
+		"is_new_post": {"1"},
+	}
+	for _, status := range []string{STATUS_DRAFT, STATUS_REVIEW} {
+		form.Set("status", status)
+		entry := submitEntry(t, c, "editor", form)
+		if !entry.PublishDate.IsZero() {
+			t.Errorf("%s entry has PublishDate %s, want none", status, entry.PublishDate)
+		}
+		form.Del("is_new_post")
+		form.Set("version", strconv.FormatInt(entry.Version, 10))
+	}
+
+	before := time.Now()
+	form.Set("status", STATUS_PUBLISHED)
+	entry := submitEntry(t, c, "editor", form)
+	if entry.PublishDate.Before(before) {
+		t.Errorf("published entry has PublishDate %s, want it to be when it was published", entry.PublishDate)
+	}
+	if entry.CurrentStatus() != STATUS_PUBLISHED {
+		t.Errorf("status = %s, want %s", entry.CurrentStatus(), STATUS_PUBLISHED)
+	}
+	want := fmt.Sprintf("%d/%02d/work-in-progress", entry.PublishDate.Year(), entry.PublishDate.Month())
+	if entry.RelativeURL != want {
+		t.Errorf("RelativeURL = %q, want %q", entry.RelativeURL, want)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	tests := []struct {
+		status      string
+		publishDate time.Time
+		want        string
+		dated       bool
+	}{
+		{STATUS_DRAFT, time.Time{}, STATUS_DRAFT, false},
+		{STATUS_REVIEW, time.Time{}, STATUS_REVIEW, false},
+		{STATUS_PUBLISHED, time.Time{}, STATUS_PUBLISHED, true},
+		{STATUS_PUBLISHED, future, STATUS_SCHEDULED, true},
+		{STATUS_SCHEDULED, time.Time{}, STATUS_PUBLISHED, true},
+	}
+	for _, tt := range tests {
+		e := SavedEntry{PublishDate: tt.publishDate}
+		e.setStatus(tt.status)
+		if e.Status != tt.want {
+			t.Errorf("setStatus(%s) with PublishDate %s gave %s, want %s", tt.status, tt.publishDate, e.Status, tt.want)
+		}
+		if e.PublishDate.IsZero() == tt.dated {
+			t.Errorf("setStatus(%s) left PublishDate %s", tt.status, e.PublishDate)
+		}
+		if e.IsHidden != unpublished_statuses[tt.want] || e.IsScheduled != (tt.want == STATUS_SCHEDULED) {
+			t.Errorf("setStatus(%s) gave IsHidden=%t IsScheduled=%t", tt.status, e.IsHidden, e.IsScheduled)
+		}
+	}
+}